@@ -0,0 +1,216 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package advisory correlates security advisories in the OSV format with the
+// versions of tracked projects people run.
+package advisory
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/microcosm-cc/bluemonday"
+
+	"git.sr.ht/~amolith/willow/db"
+	"git.sr.ht/~amolith/willow/notify"
+)
+
+// Advisory is a stored advisory that mentions a tracked project
+type Advisory struct {
+	ID        string
+	ProjectID string
+	Summary   string
+	// Severity is critical, high, medium, low, or empty if unknown
+	Severity string
+	URL      string
+	// Affected are the advisory's entries that refer to the project
+	Affected []Affected
+	Modified time.Time
+}
+
+var bmStrict = bluemonday.StrictPolicy()
+
+// Affects returns true if the version falls in any of the advisory's affected
+// ranges for the project
+func (a Advisory) Affects(version string) bool {
+	for _, affected := range a.Affected {
+		if affected.Affects(version) {
+			return true
+		}
+	}
+	return false
+}
+
+// Affecting returns the advisories that affect the version
+func Affecting(advisories []Advisory, version string) []Advisory {
+	var affecting []Advisory
+	for _, a := range advisories {
+		if a.Affects(version) {
+			affecting = append(affecting, a)
+		}
+	}
+	return affecting
+}
+
+// ForProject returns all stored advisories that mention the project, whether
+// or not they affect anyone's running version
+func ForProject(dbConn *sql.DB, projectID string) ([]Advisory, error) {
+	rows, err := db.GetAdvisories(dbConn, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	advisories := make([]Advisory, 0, len(rows))
+	for _, row := range rows {
+		a := Advisory{
+			ID:        row["id"],
+			ProjectID: projectID,
+			Summary:   row["summary"],
+			Severity:  row["severity"],
+			URL:       row["url"],
+		}
+		if err := json.Unmarshal([]byte(row["affected"]), &a.Affected); err != nil {
+			return nil, fmt.Errorf("decoding affected versions of %s: %w", a.ID, err)
+		}
+		// Dates that fail to parse are left as the zero time
+		a.Modified, _ = time.Parse(time.RFC3339, row["modified"])
+		advisories = append(advisories, a)
+	}
+	return advisories, nil
+}
+
+// Refresh fetches advisories for every tracked project from each source and
+// stores those that mention it. New advisories that affect a version someone
+// runs are announced through the notifiers.
+func Refresh(dbConn *sql.DB, mu *sync.Mutex, sources []Source, notifiers []notify.Notifier) {
+	targets, err := trackedTargets(dbConn)
+	if err != nil {
+		fmt.Println("Error getting projects to check for advisories:", err)
+		return
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	known := make(map[string]map[string]bool, len(targets))
+	for _, t := range targets {
+		known[t.ProjectID] = make(map[string]bool)
+		stored, err := db.GetAdvisories(dbConn, t.ProjectID)
+		if err != nil {
+			fmt.Println("Error getting known advisories:", err)
+			continue
+		}
+		for _, row := range stored {
+			known[t.ProjectID][row["id"]] = true
+		}
+	}
+
+	for _, source := range sources {
+		vulns, err := source.Fetch(targets)
+		if err != nil {
+			// Sources return what they could fetch along with the error
+			fmt.Println("Error fetching advisories:", err)
+		}
+		for _, v := range vulns {
+			for _, t := range targets {
+				save(dbConn, mu, notifiers, t, v, known[t.ProjectID])
+			}
+		}
+	}
+}
+
+// save stores the advisory if it mentions the target and announces it if
+// it's new and affects a running version
+func save(dbConn *sql.DB, mu *sync.Mutex, notifiers []notify.Notifier, t Target, v Vulnerability, known map[string]bool) {
+	matching := v.Matching(t.URL)
+	if len(matching) == 0 {
+		return
+	}
+
+	if v.Withdrawn != nil {
+		if known[v.ID] {
+			if err := db.DeleteAdvisory(dbConn, mu, v.ID, t.ProjectID); err != nil {
+				fmt.Println("Error deleting withdrawn advisory:", err)
+			}
+			delete(known, v.ID)
+		}
+		return
+	}
+
+	// Databases often republish each other's advisories under their own IDs,
+	// so only keep whichever was seen first
+	if !known[v.ID] {
+		for _, alias := range v.Aliases {
+			if known[alias] {
+				return
+			}
+		}
+	}
+
+	affected, err := json.Marshal(matching)
+	if err != nil {
+		fmt.Println("Error encoding affected versions:", err)
+		return
+	}
+	modified := ""
+	if !v.Modified.IsZero() {
+		modified = v.Modified.UTC().Format(time.RFC3339)
+	}
+	link := v.Link()
+	err = db.UpsertAdvisory(dbConn, mu, bmStrict.Sanitize(v.ID), t.ProjectID, bmStrict.Sanitize(v.Summary), v.Rating(), bmStrict.Sanitize(link), string(affected), modified)
+	if err != nil {
+		fmt.Println("Error saving advisory:", err)
+		return
+	}
+	if known[v.ID] {
+		return
+	}
+	known[v.ID] = true
+
+	a := Advisory{Affected: matching}
+	var running []string
+	for _, version := range t.Versions {
+		if a.Affects(version) {
+			running = append(running, version)
+		}
+	}
+	if len(running) > 0 && len(notifiers) > 0 {
+		notify.AllAdvisory(notifiers, notify.Advisory{
+			Project:  t.Name,
+			ID:       v.ID,
+			Summary:  v.Summary,
+			Severity: v.Rating(),
+			URL:      link,
+			Versions: running,
+		})
+	}
+}
+
+// trackedTargets returns every tracked project and the versions people and
+// deployments run of it
+func trackedTargets(dbConn *sql.DB) ([]Target, error) {
+	projects, err := db.GetProjects(dbConn)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]Target, 0, len(projects))
+	for _, p := range projects {
+		versions, err := db.GetProjectVersions(dbConn, p["id"])
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, Target{
+			ProjectID: p["id"],
+			Name:      p["name"],
+			URL:       p["url"],
+			Forge:     p["forge"],
+			Versions:  versions,
+		})
+	}
+	return targets, nil
+}
@@ -0,0 +1,167 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package advisory
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"git.sr.ht/~amolith/willow/db"
+	"git.sr.ht/~amolith/willow/notify"
+)
+
+type testNotifier chan notify.Advisory
+
+func (n testNotifier) Notify(notify.Release) error { return nil }
+
+func (n testNotifier) NotifyAdvisory(a notify.Advisory) error {
+	n <- a
+	return nil
+}
+
+func testDB(t *testing.T) (*sql.DB, *sync.Mutex) {
+	dbConn, err := db.Open(filepath.Join(t.TempDir(), "willow.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+	if err := db.InitialiseDatabase(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(dbConn); err != nil {
+		t.Fatal(err)
+	}
+
+	mu := &sync.Mutex{}
+	if err := db.UpsertProject(dbConn, mu, "willow", "https://github.com/example/willow", "Willow", "github"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpsertUserProject(dbConn, mu, "alice", "willow", "v2.0.5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpsertDeployment(dbConn, mu, "prod", "willow", "prod", "v1.0.0", "", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	return dbConn, mu
+}
+
+func TestRefreshFromDir(t *testing.T) {
+	dbConn, mu := testDB(t)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "GHSA-xxxx-xxxx-xxxx.json"), []byte(testAdvisory), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The Go database's copy of the same advisory, which should be skipped
+	alias := strings.NewReplacer(`"id": "GHSA-xxxx-xxxx-xxxx"`, `"id": "GO-2024-0001"`, `"aliases": ["CVE-2024-0001"]`, `"aliases": ["GHSA-xxxx-xxxx-xxxx"]`).Replace(testAdvisory)
+	unrelated := `{"id": "OSV-1", "affected": [{"package": {"ecosystem": "npm", "name": "left-pad"}, "versions": ["1.0.0"]}]}`
+	f, err := os.Create(filepath.Join(dir, "all.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, content := range map[string]string{"GO-2024-0001.json": alias, "OSV-1.json": unrelated} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	notifications := make(testNotifier, 10)
+	Refresh(dbConn, mu, []Source{Dir{Path: dir}}, []notify.Notifier{notifications})
+
+	advisories, err := ForProject(dbConn, "willow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(advisories) != 1 || advisories[0].ID != "GHSA-xxxx-xxxx-xxxx" || advisories[0].Severity != "medium" {
+		t.Fatalf("ForProject() = %+v, want only GHSA-xxxx-xxxx-xxxx", advisories)
+	}
+	if affecting := Affecting(advisories, "v2.0.5"); len(affecting) != 1 {
+		t.Errorf("Affecting(v2.0.5) = %+v, want the advisory", affecting)
+	}
+	if affecting := Affecting(advisories, "v2.2.0"); len(affecting) != 0 {
+		t.Errorf("Affecting(v2.2.0) = %+v, want none", affecting)
+	}
+
+	// Announcements are sent before Refresh returns, so the CLI exiting
+	// straight after doesn't lose them
+	select {
+	case n := <-notifications:
+		if n.Project != "Willow" || n.ID != "GHSA-xxxx-xxxx-xxxx" || strings.Join(n.Versions, " ") != "v1.0.0 v2.0.5" {
+			t.Errorf("notification = %+v", n)
+		}
+	default:
+		t.Fatal("no notification for the new advisory")
+	}
+
+	// Known advisories aren't announced again, and withdrawn ones are removed
+	Refresh(dbConn, mu, []Source{Dir{Path: dir}}, []notify.Notifier{notifications})
+	withdrawn := strings.Replace(testAdvisory, `"modified"`, `"withdrawn": "2024-04-01T00:00:00Z", "modified"`, 1)
+	if err := os.WriteFile(filepath.Join(dir, "GHSA-xxxx-xxxx-xxxx.json"), []byte(withdrawn), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "all.zip")); err != nil {
+		t.Fatal(err)
+	}
+	Refresh(dbConn, mu, []Source{Dir{Path: dir}}, []notify.Notifier{notifications})
+	select {
+	case n := <-notifications:
+		t.Errorf("unexpected notification %+v", n)
+	default:
+	}
+	if advisories, _ := ForProject(dbConn, "willow"); len(advisories) != 0 {
+		t.Errorf("withdrawn advisory is still stored: %+v", advisories)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	var queries []osvQuery
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/query" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var q osvQuery
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			t.Error(err)
+		}
+		queries = append(queries, q)
+		switch {
+		case q.Version == "v1.0.0" && q.PageToken == "":
+			_, _ = w.Write([]byte(`{"vulns": [` + testAdvisory + `], "next_page_token": "more"}`))
+		case q.Version == "v1.0.0":
+			_, _ = w.Write([]byte(`{"vulns": [{"id": "OSV-2"}]}`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	vulns, err := API{URL: server.URL}.Fetch([]Target{{Name: "Willow", URL: "https://github.com/example/willow", Versions: []string{"v1.0.0", "v2.2.0"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vulns) != 2 || vulns[0].ID != "GHSA-xxxx-xxxx-xxxx" || vulns[1].ID != "OSV-2" {
+		t.Errorf("Fetch() = %+v, want both pages", vulns)
+	}
+	if len(queries) != 3 || queries[0].Package.Ecosystem != "GIT" || queries[0].Package.Name != "https://github.com/example/willow" {
+		t.Errorf("queries = %+v", queries)
+	}
+}
@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package advisory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultGitHubAPI is GitHub's REST API
+const DefaultGitHubAPI = "https://api.github.com"
+
+// GitHub fetches the security advisories published in the repos of projects
+// on GitHub. Those are often published before, or instead of, a release.
+// Token is optional but raises GitHub's rate limit.
+type GitHub struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+// githubAdvisory is a repository security advisory as returned by
+// https://docs.github.com/en/rest/security-advisories/repository-advisories
+type githubAdvisory struct {
+	GHSAID          string     `json:"ghsa_id"`
+	CVEID           string     `json:"cve_id"`
+	HTMLURL         string     `json:"html_url"`
+	Summary         string     `json:"summary"`
+	Description     string     `json:"description"`
+	Severity        string     `json:"severity"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	WithdrawnAt     *time.Time `json:"withdrawn_at"`
+	Vulnerabilities []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		VulnerableVersionRange string `json:"vulnerable_version_range"`
+	} `json:"vulnerabilities"`
+	CVSS struct {
+		VectorString string `json:"vector_string"`
+	} `json:"cvss"`
+}
+
+// Fetch lists the published advisories of every target on GitHub. One repo
+// failing doesn't stop the others being fetched.
+func (g GitHub) Fetch(targets []Target) ([]Vulnerability, error) {
+	var vulns []Vulnerability
+	var errs []string
+	for _, t := range targets {
+		if t.Forge != "github" {
+			continue
+		}
+		advisories, err := g.list(t.URL)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", t.Name, err))
+			continue
+		}
+		for _, a := range advisories {
+			vulns = append(vulns, a.osv(t.URL))
+		}
+	}
+	if len(errs) > 0 {
+		return vulns, fmt.Errorf("fetching GitHub advisories for %s", strings.Join(errs, "; "))
+	}
+	return vulns, nil
+}
+
+// list returns all published advisories for the repo, following pagination
+func (g GitHub) list(repoURL string) ([]githubAdvisory, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	owner, repo, ok := strings.Cut(strings.Trim(u.Path, "/"), "/")
+	if !ok || owner == "" || repo == "" {
+		return nil, fmt.Errorf("%q isn't a GitHub repo URL", repoURL)
+	}
+	repo = strings.TrimSuffix(repo, ".git")
+
+	endpoint := strings.TrimSuffix(g.URL, "/")
+	if endpoint == "" {
+		endpoint = DefaultGitHubAPI
+	}
+	client := g.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	next := endpoint + "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(repo) + "/security-advisories?state=published&per_page=100"
+	var advisories []githubAdvisory
+	for next != "" {
+		req, err := http.NewRequest(http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+		req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+		if g.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+g.Token)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			resp.Body.Close()
+			return nil, fmt.Errorf("GitHub returned %s: %s", resp.Status, respBody)
+		}
+		var page []githubAdvisory
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		advisories = append(advisories, page...)
+		next = nextLink(resp.Header.Get("Link"))
+	}
+	return advisories, nil
+}
+
+var linkNext = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// nextLink returns the URL of the next page from a Link header, or an empty
+// string on the last page
+func nextLink(header string) string {
+	m := linkNext.FindStringSubmatch(header)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// osv converts the advisory to the OSV format, attributing every affected
+// package to the repo it was published in so it matches the project
+func (a githubAdvisory) osv(repoURL string) Vulnerability {
+	v := Vulnerability{
+		ID:               a.GHSAID,
+		Summary:          a.Summary,
+		Details:          a.Description,
+		Modified:         a.UpdatedAt,
+		Withdrawn:        a.WithdrawnAt,
+		DatabaseSpecific: map[string]any{"severity": a.Severity},
+	}
+	if a.CVEID != "" {
+		v.Aliases = []string{a.CVEID}
+	}
+	if a.HTMLURL != "" {
+		v.References = []Reference{{Type: "ADVISORY", URL: a.HTMLURL}}
+	}
+	if a.CVSS.VectorString != "" {
+		v.Severity = []Severity{{Type: "CVSS_V3", Score: a.CVSS.VectorString}}
+	}
+
+	// Advisories for projects that aren't packages often don't list any, so
+	// they're kept for the project even though no version can be flagged
+	if len(a.Vulnerabilities) == 0 {
+		v.Affected = []Affected{{Package: Package{Ecosystem: "GIT", Name: repoURL}}}
+	}
+	for _, vuln := range a.Vulnerabilities {
+		affected := Affected{Package: Package{Ecosystem: "GIT", Name: repoURL}}
+		r, versions := parseVersionRange(vuln.VulnerableVersionRange)
+		if len(r.Events) > 0 {
+			affected.Ranges = []Range{r}
+		}
+		affected.Versions = versions
+		v.Affected = append(v.Affected, affected)
+	}
+	return v
+}
+
+// parseVersionRange converts one of GitHub's version ranges, such as
+// ">= 1.0.0, < 1.2.3" or "= 2.0.0", to an OSV range or a list of versions.
+// OSV ranges always include their start, so "> 1.0.0" is treated like
+// ">= 1.0.0" and may flag that version too.
+func parseVersionRange(versionRange string) (Range, []string) {
+	r := Range{Type: "ECOSYSTEM"}
+	var versions []string
+	introduced := false
+	for _, constraint := range strings.Split(versionRange, ",") {
+		constraint = strings.TrimSpace(constraint)
+		for _, op := range []string{">=", "<=", ">", "<", "="} {
+			version, ok := strings.CutPrefix(constraint, op)
+			if !ok {
+				continue
+			}
+			version = strings.TrimSpace(version)
+			switch op {
+			case ">=", ">":
+				r.Events = append(r.Events, Event{Introduced: version})
+				introduced = true
+			case "<":
+				r.Events = append(r.Events, Event{Fixed: version})
+			case "<=":
+				r.Events = append(r.Events, Event{LastAffected: version})
+			case "=":
+				versions = append(versions, version)
+			}
+			break
+		}
+	}
+	if len(r.Events) > 0 && !introduced {
+		r.Events = append([]Event{{Introduced: "0"}}, r.Events...)
+	}
+	return r, versions
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package advisory
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGitHub(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/example/willow/security-advisories" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+			t.Errorf("Authorization = %q, want %q", auth, "Bearer secret")
+		}
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", `<`+server.URL+`/repos/example/willow/security-advisories?page=2>; rel="next", <`+server.URL+`/repos/example/willow/security-advisories?page=2>; rel="last"`)
+			_, _ = w.Write([]byte(`[{
+				"ghsa_id": "GHSA-aaaa-bbbb-cccc",
+				"cve_id": "CVE-2024-0002",
+				"html_url": "https://github.com/example/willow/security/advisories/GHSA-aaaa-bbbb-cccc",
+				"summary": "Stored XSS in project names",
+				"severity": "high",
+				"updated_at": "2024-05-01T00:00:00Z",
+				"vulnerabilities": [{"package": {"ecosystem": "go", "name": "git.sr.ht/~amolith/willow"}, "vulnerable_version_range": ">= 0.2.0, < 0.3.1"}]
+			}]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"ghsa_id": "GHSA-dddd-eeee-ffff", "summary": "Announced before the fix", "severity": "critical", "vulnerabilities": []}]`))
+	}))
+	defer server.Close()
+
+	targets := []Target{
+		{Name: "Willow", URL: "https://github.com/example/willow", Forge: "github"},
+		{Name: "Elsewhere", URL: "https://git.example.org/elsewhere", Forge: "forgejo"},
+	}
+	vulns, err := GitHub{URL: server.URL, Token: "secret"}.Fetch(targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vulns) != 2 {
+		t.Fatalf("Fetch() returned %d advisories, want 2 across both pages", len(vulns))
+	}
+
+	v := vulns[0]
+	if v.ID != "GHSA-aaaa-bbbb-cccc" || v.Rating() != "high" || v.Link() != "https://github.com/example/willow/security/advisories/GHSA-aaaa-bbbb-cccc" {
+		t.Errorf("first advisory = %+v", v)
+	}
+	a := Advisory{Affected: v.Matching("https://github.com/example/willow")}
+	for version, want := range map[string]bool{"v0.1.9": false, "v0.2.0": true, "v0.3.0": true, "v0.3.1": false} {
+		if got := a.Affects(version); got != want {
+			t.Errorf("Affects(%s) = %v, want %v", version, got, want)
+		}
+	}
+
+	// Advisories without affected packages are kept but don't flag anything
+	unknown := Advisory{Affected: vulns[1].Matching("https://github.com/example/willow")}
+	if len(unknown.Affected) != 1 || unknown.Affects("v0.3.0") {
+		t.Errorf("second advisory = %+v", unknown)
+	}
+}
+
+func TestParseVersionRange(t *testing.T) {
+	tests := []struct {
+		versionRange string
+		affected     []string
+		unaffected   []string
+	}{
+		{"< 1.2.3", []string{"0.1.0", "1.2.2"}, []string{"1.2.3", "2.0.0"}},
+		{"<= 1.2.3", []string{"1.2.3"}, []string{"1.2.4"}},
+		{">= 2.0.0, < 2.1.0", []string{"2.0.0", "2.0.9"}, []string{"1.9.9", "2.1.0"}},
+		{"= 3.0.0", []string{"3.0.0", "v3.0.0"}, []string{"3.0.1"}},
+	}
+	for _, tt := range tests {
+		r, versions := parseVersionRange(tt.versionRange)
+		a := Affected{Versions: versions}
+		if len(r.Events) > 0 {
+			a.Ranges = []Range{r}
+		}
+		for _, v := range tt.affected {
+			if !a.Affects(v) {
+				t.Errorf("%q doesn't include %s", tt.versionRange, v)
+			}
+		}
+		for _, v := range tt.unaffected {
+			if a.Affects(v) {
+				t.Errorf("%q includes %s", tt.versionRange, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package advisory
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/unascribed/FlexVer/go/flexver"
+)
+
+// Vulnerability is a security advisory in the OSV format described at
+// https://ossf.github.io/osv-schema/. Only the fields Willow uses are decoded.
+type Vulnerability struct {
+	ID         string      `json:"id"`
+	Summary    string      `json:"summary"`
+	Details    string      `json:"details"`
+	Aliases    []string    `json:"aliases"`
+	Modified   time.Time   `json:"modified"`
+	Withdrawn  *time.Time  `json:"withdrawn,omitempty"`
+	Severity   []Severity  `json:"severity"`
+	Affected   []Affected  `json:"affected"`
+	References []Reference `json:"references"`
+	// DatabaseSpecific is free-form, but GitHub and others put a severity
+	// rating like "HIGH" in it
+	DatabaseSpecific map[string]any `json:"database_specific"`
+}
+
+// Severity is a scored severity, such as a CVSS vector
+type Severity struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+// Affected is a package the advisory applies to and which of its versions
+// are affected
+type Affected struct {
+	Package  Package    `json:"package"`
+	Ranges   []Range    `json:"ranges"`
+	Versions []string   `json:"versions"`
+	Severity []Severity `json:"severity,omitempty"`
+}
+
+// Package identifies affected software. Git repos use the GIT ecosystem and
+// their URL as the name.
+type Package struct {
+	Ecosystem string `json:"ecosystem"`
+	Name      string `json:"name"`
+	PURL      string `json:"purl,omitempty"`
+}
+
+// Range is a set of affected versions bounded by events. Repo is only set
+// for GIT ranges, whose events are commit hashes.
+type Range struct {
+	Type   string  `json:"type"`
+	Repo   string  `json:"repo,omitempty"`
+	Events []Event `json:"events"`
+}
+
+// Event is one bound of a range; exactly one field is set
+type Event struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+	Limit        string `json:"limit,omitempty"`
+}
+
+// Reference is a link to more information about the advisory
+type Reference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// Matching returns the advisory's affected entries that refer to the project
+// at the given URL, either by naming its repo in a GIT range or by using its
+// URL as the package name, like Go modules and the GIT ecosystem do.
+func (v Vulnerability) Matching(projectURL string) []Affected {
+	repo := normaliseRepo(projectURL)
+	if repo == "" {
+		return nil
+	}
+
+	var matching []Affected
+	for _, a := range v.Affected {
+		if normaliseRepo(a.Package.Name) == repo {
+			matching = append(matching, a)
+			continue
+		}
+		for _, r := range a.Ranges {
+			if r.Type == "GIT" && normaliseRepo(r.Repo) == repo {
+				matching = append(matching, a)
+				break
+			}
+		}
+	}
+	return matching
+}
+
+// Rating returns the advisory's severity as critical, high, medium, or low,
+// or an empty string if it doesn't say. A rating given by the database is
+// preferred over one calculated from a CVSS v3 vector.
+func (v Vulnerability) Rating() string {
+	if s, ok := v.DatabaseSpecific["severity"].(string); ok {
+		if rating := normaliseRating(s); rating != "" {
+			return rating
+		}
+	}
+
+	severities := v.Severity
+	for _, a := range v.Affected {
+		severities = append(severities, a.Severity...)
+	}
+	for _, s := range severities {
+		if s.Type != "CVSS_V3" {
+			continue
+		}
+		if score, err := cvss3Score(s.Score); err == nil {
+			return cvssRating(score)
+		}
+	}
+	return ""
+}
+
+// Link returns the advisory's own page, falling back to its page on osv.dev
+func (v Vulnerability) Link() string {
+	for _, r := range v.References {
+		if r.Type == "ADVISORY" && webURL(r.URL) {
+			return r.URL
+		}
+	}
+	return "https://osv.dev/vulnerability/" + url.PathEscape(v.ID)
+}
+
+// Affects returns true if the version is one of those the entry lists or
+// falls in one of its SEMVER or ECOSYSTEM ranges. GIT ranges are bounded by
+// commits rather than tags, so they're only covered by the version list,
+// which OSV fills in with the affected tags.
+func (a Affected) Affects(version string) bool {
+	version = trimVersion(version)
+	if version == "" {
+		return false
+	}
+
+	for _, v := range a.Versions {
+		if trimVersion(v) == version {
+			return true
+		}
+	}
+	for _, r := range a.Ranges {
+		if r.Type != "GIT" && r.affects(version) {
+			return true
+		}
+	}
+	return false
+}
+
+// affects walks the range's events in version order, as the OSV schema
+// describes, to work out whether the version is inside it.
+func (r Range) affects(version string) bool {
+	events := make([]Event, len(r.Events))
+	copy(events, r.Events)
+	sort.SliceStable(events, func(i, j int) bool {
+		return flexver.Less(events[i].version(), events[j].version())
+	})
+
+	affected := false
+	for _, e := range events {
+		switch {
+		case e.Introduced != "":
+			if e.Introduced == "0" || !flexver.Less(version, trimVersion(e.Introduced)) {
+				affected = true
+			}
+		case e.Fixed != "":
+			if !flexver.Less(version, trimVersion(e.Fixed)) {
+				affected = false
+			}
+		case e.LastAffected != "":
+			if flexver.Less(trimVersion(e.LastAffected), version) {
+				affected = false
+			}
+		case e.Limit != "":
+			if !flexver.Less(version, trimVersion(e.Limit)) {
+				affected = false
+			}
+		}
+	}
+	return affected
+}
+
+// version returns whichever of the event's versions is set
+func (e Event) version() string {
+	switch {
+	case e.Introduced != "":
+		return trimVersion(e.Introduced)
+	case e.Fixed != "":
+		return trimVersion(e.Fixed)
+	case e.LastAffected != "":
+		return trimVersion(e.LastAffected)
+	}
+	return trimVersion(e.Limit)
+}
+
+// trimVersion removes the v many tags start with so they can be compared
+// with the bare versions most ecosystems use
+func trimVersion(version string) string {
+	version = strings.TrimSpace(version)
+	if len(version) > 1 && (version[0] == 'v' || version[0] == 'V') && version[1] >= '0' && version[1] <= '9' {
+		return version[1:]
+	}
+	return version
+}
+
+// normaliseRepo reduces a repo URL or module path to its host and path so
+// https://github.com/foo/bar.git, git@github.com:foo/bar, and
+// github.com/foo/bar all compare equal.
+func normaliseRepo(repo string) string {
+	repo = strings.ToLower(strings.TrimSpace(repo))
+	if i := strings.Index(repo, "://"); i >= 0 {
+		repo = repo[i+3:]
+	} else if user, rest, ok := strings.Cut(repo, "@"); ok && !strings.Contains(user, "/") {
+		repo = strings.Replace(rest, ":", "/", 1)
+	}
+	if _, rest, ok := strings.Cut(repo, "@"); ok {
+		repo = rest
+	}
+	repo = strings.TrimPrefix(repo, "www.")
+	repo = strings.TrimSuffix(repo, "/")
+	repo = strings.TrimSuffix(repo, ".git")
+	return strings.TrimSuffix(repo, "/")
+}
+
+// normaliseRating maps the ratings databases use onto Willow's
+func normaliseRating(rating string) string {
+	switch strings.ToLower(rating) {
+	case "critical":
+		return "critical"
+	case "high":
+		return "high"
+	case "moderate", "medium":
+		return "medium"
+	case "low":
+		return "low"
+	}
+	return ""
+}
+
+// webURL returns true for http and https URLs, which are safe to link to
+func webURL(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package advisory
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// testAdvisory is trimmed down from a real OSV entry for a Go module with an
+// extra GIT range
+const testAdvisory = `{
+	"id": "GHSA-xxxx-xxxx-xxxx",
+	"summary": "Path traversal in release downloads",
+	"aliases": ["CVE-2024-0001"],
+	"modified": "2024-03-01T12:00:00Z",
+	"severity": [{"type": "CVSS_V3", "score": "CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N"}],
+	"affected": [
+		{
+			"package": {"ecosystem": "Go", "name": "github.com/example/willow"},
+			"ranges": [{"type": "SEMVER", "events": [{"introduced": "0"}, {"fixed": "1.2.3"}, {"introduced": "2.0.0"}, {"last_affected": "2.1.0"}]}]
+		},
+		{
+			"package": {"ecosystem": "GIT", "name": "https://git.example.org/other"},
+			"ranges": [{"type": "GIT", "repo": "https://git.example.org/other.git", "events": [{"introduced": "0"}, {"fixed": "abc123"}]}],
+			"versions": ["v0.9.0", "v0.9.1"]
+		}
+	],
+	"references": [
+		{"type": "WEB", "url": "https://example.com/blog"},
+		{"type": "ADVISORY", "url": "https://github.com/advisories/GHSA-xxxx-xxxx-xxxx"}
+	]
+}`
+
+func TestVulnerability(t *testing.T) {
+	var v Vulnerability
+	if err := json.Unmarshal([]byte(testAdvisory), &v); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := v.Rating(); got != "medium" {
+		t.Errorf("Rating() = %q, want medium from the CVSS vector", got)
+	}
+	v.DatabaseSpecific = map[string]any{"severity": "HIGH"}
+	if got := v.Rating(); got != "high" {
+		t.Errorf("Rating() = %q, want the database's high rating", got)
+	}
+	if got := v.Link(); got != "https://github.com/advisories/GHSA-xxxx-xxxx-xxxx" {
+		t.Errorf("Link() = %q", got)
+	}
+
+	for _, url := range []string{"https://github.com/example/willow", "https://github.com/example/willow.git", "git@github.com:example/willow", "https://git.example.org/other/"} {
+		if len(v.Matching(url)) != 1 {
+			t.Errorf("Matching(%q) returned %d entries, want 1", url, len(v.Matching(url)))
+		}
+	}
+	if len(v.Matching("https://github.com/example/willow-extras")) != 0 {
+		t.Error("advisory matched an unrelated project")
+	}
+
+	tests := []struct {
+		url, version string
+		want         bool
+	}{
+		{"https://github.com/example/willow", "v1.0.0", true},
+		{"https://github.com/example/willow", "v1.2.3", false},
+		{"https://github.com/example/willow", "1.9.9", false},
+		{"https://github.com/example/willow", "v2.0.0", true},
+		{"https://github.com/example/willow", "v2.1.0", true},
+		{"https://github.com/example/willow", "v2.1.1", false},
+		{"https://github.com/example/willow", "", false},
+		{"https://git.example.org/other", "v0.9.1", true},
+		{"https://git.example.org/other", "v1.0.0", false},
+	}
+	for _, tt := range tests {
+		a := Advisory{Affected: v.Matching(tt.url)}
+		if got := a.Affects(tt.version); got != tt.want {
+			t.Errorf("Affects(%q) for %s = %v, want %v", tt.version, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestCVSS3Score(t *testing.T) {
+	tests := []struct {
+		vector string
+		want   float64
+	}{
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", 9.8},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N", 6.1},
+		{"CVSS:3.0/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:N/A:N", 5.5},
+		{"CVSS:3.1/AV:N/AC:H/PR:H/UI:R/S:C/C:H/I:H/A:H", 7.6},
+		{"CVSS:3.1/AV:P/AC:H/PR:H/UI:R/S:U/C:N/I:N/A:N", 0},
+	}
+	for _, tt := range tests {
+		got, err := cvss3Score(tt.vector)
+		if err != nil {
+			t.Errorf("cvss3Score(%q) returned error: %v", tt.vector, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("cvss3Score(%q) = %v, want %v", tt.vector, got, tt.want)
+		}
+	}
+
+	if _, err := cvss3Score("CVSS:2.0/AV:N"); err == nil {
+		t.Error("cvss3Score accepted a CVSS v2 vector")
+	}
+	if _, err := cvss3Score("CVSS:3.1/AV:N/AC:L/S:U"); err == nil {
+		t.Error("cvss3Score accepted a vector with missing metrics")
+	}
+}
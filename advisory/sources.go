@@ -0,0 +1,205 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package advisory
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Target is a tracked project along with the versions people run of it
+type Target struct {
+	ProjectID string
+	Name      string
+	URL       string
+	Forge     string
+	Versions  []string
+}
+
+// Source is somewhere advisories come from
+type Source interface {
+	// Fetch returns advisories that may apply to the targets. Those that
+	// don't are filtered out later, so sources can return extra.
+	Fetch(targets []Target) ([]Vulnerability, error)
+}
+
+// Dir reads advisories from an offline copy of an OSV database, such as one
+// of the per-ecosystem all.zip files from
+// https://storage.googleapis.com/osv-vulnerabilities/index.html. Path is
+// searched recursively for .json files with one advisory each and for .zip
+// files full of them.
+type Dir struct {
+	Path string
+}
+
+// Fetch reads every advisory under the directory and returns those that
+// mention one of the targets.
+func (d Dir) Fetch(targets []Target) ([]Vulnerability, error) {
+	var vulns []Vulnerability
+	keep := func(name string, data []byte) {
+		var v Vulnerability
+		if err := json.Unmarshal(data, &v); err != nil {
+			fmt.Printf("Error parsing advisory %s: %v\n", name, err)
+			return
+		}
+		if mentionsAny(v, targets) {
+			vulns = append(vulns, v)
+		}
+	}
+
+	err := filepath.WalkDir(d.Path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".json":
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			keep(path, data)
+		case ".zip":
+			return readZip(path, keep)
+		}
+		return nil
+	})
+	return vulns, err
+}
+
+// readZip calls keep with each JSON file in the archive
+func readZip(path string, keep func(name string, data []byte)) error {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	for _, f := range archive.File {
+		if !strings.EqualFold(filepath.Ext(f.Name), ".json") {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		keep(path+"/"+f.Name, data)
+	}
+	return nil
+}
+
+// DefaultOSVAPI is the public OSV API
+const DefaultOSVAPI = "https://api.osv.dev"
+
+// API queries the OSV API for advisories affecting each version of each
+// target, identifying projects by their git repo's URL.
+type API struct {
+	URL    string
+	Client *http.Client
+}
+
+type osvQuery struct {
+	Package   Package `json:"package"`
+	Version   string  `json:"version"`
+	PageToken string  `json:"page_token,omitempty"`
+}
+
+type osvResponse struct {
+	Vulns         []Vulnerability `json:"vulns"`
+	NextPageToken string          `json:"next_page_token"`
+}
+
+// Fetch queries the API once for every version of every target. One target
+// failing doesn't stop the others being queried.
+func (a API) Fetch(targets []Target) ([]Vulnerability, error) {
+	seen := make(map[string]bool)
+	var vulns []Vulnerability
+	var errs []string
+	for _, t := range targets {
+		for _, version := range t.Versions {
+			found, err := a.query(t.URL, version)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s %s: %v", t.Name, version, err))
+				continue
+			}
+			for _, v := range found {
+				if !seen[v.ID] {
+					seen[v.ID] = true
+					vulns = append(vulns, v)
+				}
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return vulns, fmt.Errorf("querying OSV for %s", strings.Join(errs, "; "))
+	}
+	return vulns, nil
+}
+
+// query returns every advisory the API says affects the repo at the tag,
+// following pagination
+func (a API) query(repo, version string) ([]Vulnerability, error) {
+	endpoint := strings.TrimSuffix(a.URL, "/")
+	if endpoint == "" {
+		endpoint = DefaultOSVAPI
+	}
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	q := osvQuery{Package: Package{Ecosystem: "GIT", Name: repo}, Version: version}
+	var vulns []Vulnerability
+	for {
+		body, err := json.Marshal(q)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Post(endpoint+"/v1/query", "application/json", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		var r osvResponse
+		if resp.StatusCode != http.StatusOK {
+			respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			resp.Body.Close()
+			return nil, fmt.Errorf("OSV API returned %s: %s", resp.Status, respBody)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&r)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		vulns = append(vulns, r.Vulns...)
+		if r.NextPageToken == "" {
+			return vulns, nil
+		}
+		q.PageToken = r.NextPageToken
+	}
+}
+
+// mentionsAny returns true if the advisory refers to any of the targets
+func mentionsAny(v Vulnerability, targets []Target) bool {
+	for _, t := range targets {
+		if len(v.Matching(t.URL)) > 0 {
+			return true
+		}
+	}
+	return false
+}
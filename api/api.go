@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package api describes the JSON Willow's HTTP API and command line print and
+// has a client for scripts and the remote command line to use the API with.
+package api
+
+import (
+	"time"
+
+	"git.sr.ht/~amolith/willow/advisory"
+	"git.sr.ht/~amolith/willow/project"
+)
+
+// Prefix is the path every API endpoint is under
+const Prefix = "/api/v1"
+
+type (
+	Project struct {
+		ID          string       `json:"id"`
+		Name        string       `json:"name"`
+		URL         string       `json:"url"`
+		Forge       string       `json:"forge"`
+		Running     string       `json:"running"`
+		Latest      string       `json:"latest"`
+		Outdated    bool         `json:"outdated"`
+		Managed     bool         `json:"managed"`
+		Deployments []Deployment `json:"deployments"`
+		AffectedBy  []Advisory   `json:"affected_by"`
+	}
+
+	Deployment struct {
+		ID         string     `json:"id"`
+		Name       string     `json:"name"`
+		Running    string     `json:"running"`
+		Notes      string     `json:"notes,omitempty"`
+		Owner      string     `json:"owner"`
+		AffectedBy []Advisory `json:"affected_by"`
+	}
+
+	Advisory struct {
+		ID       string `json:"id"`
+		Summary  string `json:"summary"`
+		Severity string `json:"severity,omitempty"`
+		URL      string `json:"url"`
+	}
+
+	Release struct {
+		Tag     string `json:"tag"`
+		URL     string `json:"url"`
+		Date    string `json:"date,omitempty"`
+		Content string `json:"content"`
+	}
+)
+
+// SetRunning is the body of POST /api/v1/running. Project is the name, URL, or
+// ID of one of the user's projects, and Deployment, if set, is the name of the
+// deployment to set the version of instead of the user's.
+type SetRunning struct {
+	Project    string `json:"project"`
+	Version    string `json:"version"`
+	Deployment string `json:"deployment,omitempty"`
+	Notes      string `json:"notes,omitempty"`
+}
+
+// Error is the body of responses to requests that failed
+type Error struct {
+	Error string `json:"error"`
+}
+
+// FromProject converts a project with its releases, deployments, and
+// advisories to its JSON form
+func FromProject(p project.Project) Project {
+	out := Project{
+		ID:          p.ID,
+		Name:        p.Name,
+		URL:         p.URL,
+		Forge:       p.Forge,
+		Running:     p.Running,
+		Latest:      p.Latest(),
+		Outdated:    p.Outdated(),
+		Managed:     p.Managed,
+		Deployments: make([]Deployment, len(p.Deployments)),
+		AffectedBy:  fromAdvisories(p.AffectedBy),
+	}
+	for i, d := range p.Deployments {
+		out.Deployments[i] = Deployment{
+			ID:         d.ID,
+			Name:       d.Name,
+			Running:    d.Running,
+			Notes:      d.Notes,
+			Owner:      d.Owner,
+			AffectedBy: fromAdvisories(d.AffectedBy),
+		}
+	}
+	return out
+}
+
+// FromReleases converts releases to their JSON form
+func FromReleases(releases []project.Release) []Release {
+	out := make([]Release, len(releases))
+	for i, r := range releases {
+		out[i] = Release{Tag: r.Tag, URL: r.URL, Content: r.Content}
+		if !r.Date.IsZero() {
+			out[i].Date = r.Date.Format(time.RFC3339)
+		}
+	}
+	return out
+}
+
+func fromAdvisories(advisories []advisory.Advisory) []Advisory {
+	out := make([]Advisory, len(advisories))
+	for i, a := range advisories {
+		out[i] = Advisory{ID: a.ID, Summary: a.Summary, Severity: a.Severity, URL: a.URL}
+	}
+	return out
+}
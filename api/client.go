@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Client talks to a Willow server's API as the user the token belongs to
+type Client struct {
+	HTTP *http.Client
+	// URL is the server's BaseURL
+	URL   string
+	Token string
+}
+
+// Projects returns the user's projects, or only those that are outdated
+func (c Client) Projects(outdated bool) ([]Project, error) {
+	path := "/projects"
+	if outdated {
+		path += "?outdated=true"
+	}
+	var projects []Project
+	err := c.do(http.MethodGet, path, nil, &projects)
+	return projects, err
+}
+
+// SetRunning sets the version of one of the user's projects or deployments
+func (c Client) SetRunning(req SetRunning) error {
+	return c.do(http.MethodPost, "/running", req, nil)
+}
+
+// Refresh asks the server to fetch new releases now. It returns before they've
+// been fetched.
+func (c Client) Refresh() error {
+	return c.do(http.MethodPost, "/refresh", nil, nil)
+}
+
+// do sends body as JSON and decodes the response into out if it's not nil
+func (c Client) do(method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.URL, "/")+Prefix+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr Error
+		if json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s: %s", resp.Status, apiErr.Error)
+		}
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
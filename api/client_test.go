@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	var got SetRunning
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(Error{Error: "Invalid API token"})
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET " + Prefix + "/projects":
+			if r.URL.Query().Get("outdated") != "true" {
+				t.Errorf("projects requested without outdated=true")
+			}
+			_ = json.NewEncoder(w).Encode([]Project{{Name: "Willow", Running: "v0.0.1", Latest: "v0.0.2", Outdated: true}})
+		case "POST " + Prefix + "/running":
+			_ = json.NewDecoder(r.Body).Decode(&got)
+			w.WriteHeader(http.StatusNoContent)
+		case "POST " + Prefix + "/refresh":
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := Client{HTTP: server.Client(), URL: server.URL + "/", Token: "secret"}
+	projects, err := client.Projects(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 1 || projects[0].Latest != "v0.0.2" {
+		t.Errorf("Projects() = %+v, want Willow with v0.0.2 out", projects)
+	}
+
+	req := SetRunning{Project: "Willow", Version: "v0.0.2", Deployment: "production"}
+	if err := client.SetRunning(req); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, req) {
+		t.Errorf("server got %+v, want %+v", got, req)
+	}
+	if err := client.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Token = "wrong"
+	if _, err := client.Projects(false); err == nil || !strings.Contains(err.Error(), "Invalid API token") {
+		t.Errorf("Projects() with a wrong token = %v, want the server's error", err)
+	}
+}
@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package audit records who did what so admins can find out later.
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"git.sr.ht/~amolith/willow/db"
+)
+
+// Actions that are recorded
+const (
+	Track            = "track"
+	Untrack          = "untrack"
+	SetRunning       = "set running version"
+	SaveDeployment   = "save deployment"
+	DeleteDeployment = "delete deployment"
+	Login            = "login"
+	CreateUser       = "create user"
+	DeleteUser       = "delete user"
+	SetRole          = "set role"
+	ResetPassword    = "reset password"
+	Reset2FA         = "reset two-factor authentication"
+	LogoutEverywhere = "log out everywhere"
+	CreateAPIToken   = "create API token"
+	RevokeAPIToken   = "revoke API token"
+)
+
+// CLI is the actor for changes made from the command line
+const CLI = "cli"
+
+// ProjectsFile is the actor for changes made by reconciling the projects file
+const ProjectsFile = "projects file"
+
+// Entry is one thing someone did
+type Entry struct {
+	ID        int64  `json:"id"`
+	Actor     string `json:"actor"`
+	Action    string `json:"action"`
+	Target    string `json:"target"`
+	Details   string `json:"details,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Record adds an entry to the audit log. Failing to record shouldn't stop the
+// action itself, so errors are printed rather than returned.
+func Record(dbConn *sql.DB, actor, action, target, details string) {
+	if err := db.CreateAuditEntry(dbConn, actor, action, target, details); err != nil {
+		fmt.Printf("Error recording %s of %s by %s in audit log: %v\n", action, target, actor, err)
+	}
+}
+
+// List returns the most recent entries, newest first. A limit of zero returns
+// all of them.
+func List(dbConn *sql.DB, limit int) ([]Entry, error) {
+	rows, err := db.GetAuditEntries(dbConn, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, len(rows))
+	for i, row := range rows {
+		id, err := strconv.ParseInt(row["id"], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = Entry{
+			ID:        id,
+			Actor:     row["actor"],
+			Action:    row["action"],
+			Target:    row["target"],
+			Details:   row["details"],
+			CreatedAt: row["created_at"],
+		}
+	}
+	return entries, nil
+}
+
+// Export writes every entry to w as JSON lines, oldest first.
+func Export(dbConn *sql.DB, w io.Writer) error {
+	entries, err := List(dbConn, 0)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if err := enc.Encode(entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~amolith/willow/db"
+)
+
+func TestAuditLog(t *testing.T) {
+	dbConn, err := db.Open(filepath.Join(t.TempDir(), "willow.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+	if err := db.InitialiseDatabase(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(dbConn); err != nil {
+		t.Fatal(err)
+	}
+
+	Record(dbConn, "alice", Track, "Willow", "running v0.0.1")
+	Record(dbConn, "alice", SetRunning, "Willow", "from v0.0.1 to v0.0.2")
+	Record(dbConn, CLI, DeleteUser, "bob", "")
+
+	entries, err := List(dbConn, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Action != DeleteUser || entries[1].Action != SetRunning {
+		t.Fatalf("List(2) = %+v, want the two newest entries, newest first", entries)
+	}
+
+	var buf bytes.Buffer
+	if err := Export(dbConn, &buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Export() wrote %d lines, want 3", len(lines))
+	}
+	var first Entry
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Actor != "alice" || first.Action != Track || first.Target != "Willow" || first.Details != "running v0.0.1" {
+		t.Errorf("first exported entry = %+v", first)
+	}
+}
@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package backup exports users' project lists so they can be restored on
+// another instance, and moves feed-based projects to and from feed readers
+// with OPML.
+package backup
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"git.sr.ht/~amolith/willow/db"
+)
+
+// Version is the version of the list format, written to every export so
+// future versions can tell which they're reading
+const Version = 1
+
+// Formats lists the formats lists can be exported as
+var Formats = []string{"toml", "json", "opml"}
+
+// List is a user's tracked projects. Keys are named like those in Willow's
+// config.
+type List struct {
+	Willow   int
+	Projects []Project
+}
+
+// Project is a tracked project and the versions the user runs
+type Project struct {
+	Name    string
+	URL     string
+	Forge   string
+	Running string
+	// Deployments are the user's named instances of the project
+	Deployments []Deployment `toml:",omitempty" json:",omitempty"`
+}
+
+// Deployment is a named instance of a project running its own version
+type Deployment struct {
+	Name    string
+	Running string
+	Notes   string `toml:",omitempty" json:",omitempty"`
+}
+
+// Export returns the projects the user tracks, sorted by name, along with the
+// deployments they own
+func Export(dbConn *sql.DB, username string) (List, error) {
+	rows, err := db.GetUserProjects(dbConn, username)
+	if err != nil {
+		return List{}, err
+	}
+
+	list := List{Willow: Version, Projects: make([]Project, 0, len(rows))}
+	for _, row := range rows {
+		p := Project{Name: row["name"], URL: row["url"], Forge: row["forge"], Running: row["version"]}
+		deployments, err := db.GetDeployments(dbConn, row["id"])
+		if err != nil {
+			return List{}, err
+		}
+		for _, d := range deployments {
+			if d["owner"] == username {
+				p.Deployments = append(p.Deployments, Deployment{Name: d["name"], Running: d["version"], Notes: d["notes"]})
+			}
+		}
+		list.Projects = append(list.Projects, p)
+	}
+	sort.SliceStable(list.Projects, func(i, j int) bool {
+		return strings.ToLower(list.Projects[i].Name) < strings.ToLower(list.Projects[j].Name)
+	})
+	return list, nil
+}
+
+// Encode writes the list in one of Formats. OPML only holds the projects
+// whose releases come from feeds, and nothing about the versions running.
+func Encode(w io.Writer, list List, format, title string) error {
+	switch format {
+	case "toml":
+		return toml.NewEncoder(w).Encode(list)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	case "opml":
+		return encodeOPML(w, list, title)
+	default:
+		return fmt.Errorf("unknown format %q, must be one of %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+// ContentType returns the MIME type of an export format
+func ContentType(format string) string {
+	switch format {
+	case "json":
+		return "application/json"
+	case "opml":
+		return "text/x-opml+xml"
+	default:
+		return "application/toml"
+	}
+}
+
+// Detect reports whether the file is a list Decode can read rather than, say,
+// a manifest. Willow's own lists are recognised by their version key.
+func Detect(filename string, data []byte) bool {
+	if isOPML(filename, data) {
+		return true
+	}
+	_, err := decodeNative(filename, data)
+	return err == nil
+}
+
+// Decode reads a list exported in any of Formats, or an OPML file from a
+// feed reader
+func Decode(filename string, data []byte) (List, error) {
+	if isOPML(filename, data) {
+		return decodeOPML(data)
+	}
+	return decodeNative(filename, data)
+}
+
+// decodeNative reads a list in TOML or JSON, refusing files without the
+// version key so other TOML and JSON files aren't mistaken for empty lists
+func decodeNative(filename string, data []byte) (List, error) {
+	var list List
+	var err error
+	trimmed := bytes.TrimSpace(data)
+	if filepath.Ext(filename) == ".json" || bytes.HasPrefix(trimmed, []byte("{")) {
+		err = json.Unmarshal(data, &list)
+	} else {
+		_, err = toml.Decode(string(data), &list)
+	}
+	if err != nil {
+		return List{}, err
+	}
+	if list.Willow == 0 {
+		return List{}, fmt.Errorf("%s isn't a Willow project list", filepath.Base(filename))
+	}
+	if list.Willow > Version {
+		return List{}, fmt.Errorf("%s is from a newer version of Willow", filepath.Base(filename))
+	}
+	return list, nil
+}
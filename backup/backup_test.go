@@ -0,0 +1,170 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package backup
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"git.sr.ht/~amolith/willow/db"
+	"git.sr.ht/~amolith/willow/project"
+)
+
+func TestRoundTrip(t *testing.T) {
+	list := List{Willow: Version, Projects: []Project{
+		{Name: "nginx", URL: "https://hub.docker.com/r/library/nginx#alpine", Forge: "container", Running: "1.25.3-alpine"},
+		{Name: "Willow", URL: "https://git.sr.ht/~amolith/willow", Forge: "sourcehut", Running: "v0.0.1", Deployments: []Deployment{
+			{Name: "production", Running: "v0.0.1", Notes: "Behind Caddy"},
+			{Name: "staging", Running: "v0.0.2"},
+		}},
+		{Name: "toml", URL: "https://github.com/BurntSushi/toml", Forge: "github", Running: "v1.3.2"},
+	}}
+
+	for _, format := range []string{"toml", "json"} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, list, format, "test"); err != nil {
+			t.Fatal(err)
+		}
+		filename := "projects." + format
+		if !Detect(filename, buf.Bytes()) {
+			t.Errorf("Detect() didn't recognise a %s export", format)
+		}
+		got, err := Decode(filename, buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, list) {
+			t.Errorf("%s round trip = %+v, want %+v", format, got, list)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, list, "opml", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if !Detect("feeds.xml", buf.Bytes()) {
+		t.Error("Detect() didn't recognise an OPML export")
+	}
+	got, err := Decode("feeds.xml", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Project{{Name: "toml", URL: "https://github.com/BurntSushi/toml", Forge: "github"}}
+	if !reflect.DeepEqual(got.Projects, want) {
+		t.Errorf("OPML round trip = %+v, want %+v", got.Projects, want)
+	}
+
+	if err := Encode(&buf, list, "yaml", "test"); err == nil {
+		t.Error("Encode() accepted an unknown format")
+	}
+}
+
+func TestDecodeFeedReaderOPML(t *testing.T) {
+	data := `<?xml version="1.0"?>
+<opml version="1.0">
+  <head><title>Subscriptions</title></head>
+  <body>
+    <outline text="Releases">
+      <outline type="rss" text="gofeed" title="Release notes from gofeed" xmlUrl="https://github.com/mmcdole/gofeed/releases.atom" htmlUrl="https://github.com/mmcdole/gofeed"/>
+      <outline type="rss" text="forgejo" xmlUrl="https://codeberg.org/forgejo/forgejo/releases.rss"/>
+    </outline>
+    <outline type="rss" text="A blog" xmlUrl="https://example.com/feed.xml"/>
+  </body>
+</opml>`
+	list, err := Decode("subscriptions.opml", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Project{
+		{Name: "gofeed", URL: "https://github.com/mmcdole/gofeed", Forge: "github"},
+		{Name: "forgejo", URL: "https://codeberg.org/forgejo/forgejo", Forge: "forgejo"},
+	}
+	if !reflect.DeepEqual(list.Projects, want) {
+		t.Errorf("Decode() = %+v, want %+v", list.Projects, want)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	files := map[string]string{
+		"Cargo.lock":   "version = 3\n\n[[package]]\nname = \"serde\"\nversion = \"1.0.196\"\n",
+		"package.json": `{"dependencies": {"react": "^18.2.0"}}`,
+		"config.toml":  "DBConn = \"willow.sqlite\"\n",
+		"go.mod":       "module example.org/app\n",
+	}
+	for filename, data := range files {
+		if Detect(filename, []byte(data)) {
+			t.Errorf("Detect() mistook %s for a project list", filename)
+		}
+	}
+	if _, err := Decode("projects.toml", []byte("Willow = 2\n")); err == nil {
+		t.Error("Decode() accepted a list from a newer version")
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dbConn, err := db.Open(filepath.Join(t.TempDir(), "willow.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbConn.Close()
+	if err := db.InitialiseDatabase(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	mu := &sync.Mutex{}
+
+	// Someone else already tracks it under another name
+	project.Track(dbConn, mu, nil, "bob", "BurntSushi TOML", "https://github.com/BurntSushi/toml", "github", "v1.2.0")
+
+	result, err := Restore(dbConn, mu, "alice", List{Willow: Version, Projects: []Project{
+		{Name: "toml", URL: "https://github.com/BurntSushi/toml.git", Forge: "github", Running: "v1.3.2"},
+		{Name: "Willow", URL: "https://git.sr.ht/~amolith/willow", Forge: "sourcehut", Running: "v0.0.1", Deployments: []Deployment{
+			{Name: "production", Running: "v0.0.1"},
+		}},
+		{Name: "evil", URL: "javascript:alert(1)", Forge: "other", Running: "1"},
+		{Name: "unknown", URL: "https://example.com/x", Forge: "svn", Running: "1"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Restored) != 2 || len(result.Skipped) != 2 {
+		t.Fatalf("Restore() = %+v, want two restored and two skipped", result)
+	}
+
+	exported, err := Export(dbConn, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Project{
+		{Name: "BurntSushi TOML", URL: "https://github.com/BurntSushi/toml", Forge: "github", Running: "v1.3.2"},
+		{Name: "Willow", URL: "https://git.sr.ht/~amolith/willow", Forge: "sourcehut", Running: "v0.0.1", Deployments: []Deployment{
+			{Name: "production", Running: "v0.0.1"},
+		}},
+	}
+	if !reflect.DeepEqual(exported.Projects, want) {
+		t.Errorf("Export() after Restore() = %+v, want %+v", exported.Projects, want)
+	}
+
+	// Lists from feed readers don't change the version already running
+	_, err = Restore(dbConn, mu, "alice", List{Willow: Version, Projects: []Project{
+		{Name: "toml", URL: "https://github.com/burntsushi/toml/", Forge: "github"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err := db.GetUserProject(dbConn, "alice", project.GenProjectID("https://github.com/BurntSushi/toml", "BurntSushi TOML", "github"))
+	if err != nil || row["version"] != "v1.3.2" {
+		t.Errorf("running version after restoring from OPML = %q, %v; want v1.3.2", row["version"], err)
+	}
+	if strings.Contains(strings.Join(result.Restored, " "), "evil") {
+		t.Error("restored a project with a javascript: URL")
+	}
+}
@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package backup
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// feedForges are the forges whose releases Willow reads from feeds, which
+// are all that OPML can hold
+var feedForges = map[string]bool{
+	"github":  true,
+	"gitea":   true,
+	"forgejo": true,
+}
+
+type opml struct {
+	XMLName xml.Name    `xml:"opml"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"head>title"`
+	Created string      `xml:"head>dateCreated,omitempty"`
+	Body    []opmlEntry `xml:"body>outline"`
+}
+
+// opmlEntry is a feed or, in feed readers' exports, a folder of them. The
+// category holds the forge so it survives a round trip.
+type opmlEntry struct {
+	Type     string      `xml:"type,attr,omitempty"`
+	Text     string      `xml:"text,attr"`
+	Title    string      `xml:"title,attr,omitempty"`
+	XMLURL   string      `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string      `xml:"htmlUrl,attr,omitempty"`
+	Category string      `xml:"category,attr,omitempty"`
+	Children []opmlEntry `xml:"outline"`
+}
+
+func encodeOPML(w io.Writer, list List, title string) error {
+	doc := opml{
+		Version: "2.0",
+		Title:   title,
+		Created: time.Now().UTC().Format(time.RFC1123Z),
+	}
+	for _, p := range list.Projects {
+		if !feedForges[p.Forge] {
+			continue
+		}
+		doc.Body = append(doc.Body, opmlEntry{
+			Type:     "rss",
+			Text:     p.Name,
+			Title:    p.Name,
+			XMLURL:   feedURL(p.URL),
+			HTMLURL:  p.URL,
+			Category: p.Forge,
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// feedURL returns the release feed rss.GetReleases reads for a project
+func feedURL(projectURL string) string {
+	return strings.TrimSuffix(projectURL, "/") + "/releases.atom"
+}
+
+// isOPML reports whether the file looks like OPML, which feed readers give
+// all sorts of extensions
+func isOPML(filename string, data []byte) bool {
+	if filepath.Ext(filename) == ".opml" {
+		return true
+	}
+	head := data
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	return bytes.Contains(head, []byte("<opml"))
+}
+
+// decodeOPML reads the release feeds out of an OPML file, looking through
+// folders and skipping feeds that aren't forge release feeds. Feeds don't say
+// what version is running, so it's left empty.
+func decodeOPML(data []byte) (List, error) {
+	var doc opml
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return List{}, err
+	}
+	list := List{Willow: Version}
+	var walk func([]opmlEntry)
+	walk = func(entries []opmlEntry) {
+		for _, e := range entries {
+			walk(e.Children)
+			if p, ok := feedProject(e); ok {
+				list.Projects = append(list.Projects, p)
+			}
+		}
+	}
+	walk(doc.Body)
+	return list, nil
+}
+
+// feedProject turns a forge release feed back into the project it belongs to.
+// Feeds exported by Willow name their forge in the category; otherwise GitHub
+// is recognised by its host and anything else is assumed to be Forgejo or
+// Gitea, whose feeds are identical.
+func feedProject(e opmlEntry) (Project, bool) {
+	projectURL := ""
+	for _, suffix := range []string{"/releases.atom", "/releases.rss"} {
+		if trimmed, ok := strings.CutSuffix(e.XMLURL, suffix); ok {
+			projectURL = trimmed
+		}
+	}
+	u, err := url.Parse(projectURL)
+	if projectURL == "" || err != nil || u.Host == "" {
+		return Project{}, false
+	}
+
+	forge := e.Category
+	if !feedForges[forge] {
+		forge = "forgejo"
+		if strings.EqualFold(u.Hostname(), "github.com") {
+			forge = "github"
+		}
+	}
+	// GitHub titles its feeds "Release notes from <repo>"
+	name := strings.TrimPrefix(e.Title, "Release notes from ")
+	if name == "" {
+		name = e.Text
+	}
+	if name == "" {
+		name = projectURL[strings.LastIndex(projectURL, "/")+1:]
+	}
+	return Project{Name: name, URL: projectURL, Forge: forge}, true
+}
@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package backup
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/microcosm-cc/bluemonday"
+
+	"git.sr.ht/~amolith/willow/audit"
+	"git.sr.ht/~amolith/willow/db"
+	"git.sr.ht/~amolith/willow/project"
+)
+
+var bmStrict = bluemonday.StrictPolicy()
+
+// Skipped is a project from a list that couldn't be restored
+type Skipped struct {
+	Name string
+	Err  error
+}
+
+// Result lists the names of the projects that were restored and those that
+// were skipped
+type Result struct {
+	Restored []string
+	Skipped  []Skipped
+}
+
+// Restore tracks every project in the list for the user and saves their
+// deployments. Projects that are already tracked under the same URL keep
+// their name, and only have their running version replaced when the list says
+// what it is.
+func Restore(dbConn *sql.DB, mu *sync.Mutex, username string, list List) (Result, error) {
+	tracked, err := project.GetProjectsByRepo(dbConn)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var result Result
+	for _, p := range list.Projects {
+		p.Name = bmStrict.Sanitize(strings.TrimSpace(p.Name))
+		p.URL = bmStrict.Sanitize(strings.TrimSpace(p.URL))
+		p.Forge = strings.ToLower(strings.TrimSpace(p.Forge))
+		p.Running = bmStrict.Sanitize(strings.TrimSpace(p.Running))
+		if err := validate(p); err != nil {
+			result.Skipped = append(result.Skipped, Skipped{Name: p.Name, Err: err})
+			continue
+		}
+
+		if existing, ok := tracked[project.RepoKey(p.Forge, p.URL)]; ok {
+			p.Name, p.URL = existing.Name, existing.URL
+		}
+		id := project.GenProjectID(p.URL, p.Name, p.Forge)
+		if p.Running == "" {
+			row, err := db.GetUserProject(dbConn, username, id)
+			if err == nil {
+				p.Running = row["version"]
+			}
+		}
+		project.Track(dbConn, mu, nil, username, p.Name, p.URL, p.Forge, p.Running)
+
+		for _, d := range p.Deployments {
+			deployment := project.Deployment{
+				ProjectID: id,
+				Name:      bmStrict.Sanitize(strings.TrimSpace(d.Name)),
+				Running:   bmStrict.Sanitize(strings.TrimSpace(d.Running)),
+				Notes:     bmStrict.Sanitize(d.Notes),
+				Owner:     username,
+			}
+			if deployment.Name == "" || deployment.Running == "" {
+				continue
+			}
+			if _, err := project.SaveDeployment(dbConn, mu, deployment); err != nil {
+				return result, err
+			}
+			audit.Record(dbConn, username, audit.SaveDeployment, deployment.Name, "running "+deployment.Running)
+		}
+		result.Restored = append(result.Restored, p.Name)
+	}
+	return result, nil
+}
+
+func validate(p Project) error {
+	if p.Name == "" {
+		return fmt.Errorf("no name")
+	}
+	if !project.ValidForge(p.Forge) {
+		return fmt.Errorf("unknown forge %q", p.Forge)
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return fmt.Errorf("invalid URL %q", p.URL)
+	}
+	return nil
+}
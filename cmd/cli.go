@@ -5,18 +5,53 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"git.sr.ht/~amolith/willow/advisory"
+	"git.sr.ht/~amolith/willow/api"
+	"git.sr.ht/~amolith/willow/audit"
+	"git.sr.ht/~amolith/willow/backup"
+	"git.sr.ht/~amolith/willow/manifest"
+	"git.sr.ht/~amolith/willow/project"
 	"git.sr.ht/~amolith/willow/users"
 	"golang.org/x/term"
 )
 
-// createUser is a CLI that creates a new user with the specified username
-func createUser(dbConn *sql.DB, username string) {
-	fmt.Println("Creating user", username)
+// createUser is a CLI that creates a new user with the specified username and
+// role. If no role is provided, the first user is an admin and the rest are
+// editors.
+func createUser(dbConn *sql.DB, username, role string) {
+	if role == "" {
+		existing, err := users.GetUsers(dbConn)
+		if err != nil {
+			fmt.Println("Error retrieving users from the database:", err)
+			os.Exit(1)
+		}
+		role = users.RoleEditor
+		if len(existing) == 0 {
+			role = users.RoleAdmin
+		}
+	}
+	if !users.ValidRole(role) {
+		fmt.Printf("Invalid role %s, must be %s, %s, or %s\n", role, users.RoleAdmin, users.RoleEditor, users.RoleViewer)
+		os.Exit(1)
+	}
+
+	fmt.Println("Creating", role, username)
 
 	fmt.Print("Enter password: ")
 	password, err := term.ReadPassword(int(syscall.Stdin))
@@ -38,12 +73,13 @@ func createUser(dbConn *sql.DB, username string) {
 		fmt.Println("Passwords do not match")
 		os.Exit(1)
 	}
-	err = users.Register(dbConn, username, string(password))
+	err = users.Register(dbConn, username, string(password), role)
 	if err != nil {
 		fmt.Println("Error creating user:", err)
 		os.Exit(1)
 	}
 
+	audit.Record(dbConn, audit.CLI, audit.CreateUser, username, role)
 	fmt.Println("\nUser", username, "created successfully")
 	os.Exit(0)
 }
@@ -57,27 +93,285 @@ func deleteUser(dbConn *sql.DB, username string) {
 		os.Exit(1)
 	}
 
+	audit.Record(dbConn, audit.CLI, audit.DeleteUser, username, "")
 	fmt.Printf("User %s deleted successfully\n", username)
 	os.Exit(0)
 }
 
 // listUsers is a CLI that lists all users in the database
-func listUsers(dbConn *sql.DB) {
-	fmt.Println("Listing all users")
-
-	dbUsers, err := users.GetUsers(dbConn)
+func listUsers(dbConn *sql.DB, asJSON bool) {
+	dbUsers, err := users.ListUsers(dbConn)
 	if err != nil {
 		fmt.Println("Error retrieving users from the database:", err)
 		os.Exit(1)
 	}
 
+	if asJSON {
+		list := make([]userJSON, len(dbUsers))
+		for i, u := range dbUsers {
+			list[i] = userJSON{Username: u.Username, Role: u.Role}
+		}
+		printJSON(list)
+		os.Exit(0)
+	}
+
+	fmt.Println("Listing all users")
 	if len(dbUsers) == 0 {
 		fmt.Println("- No users found")
 	} else {
 		for _, u := range dbUsers {
-			fmt.Println("-", u)
+			fmt.Printf("- %s (%s)\n", u.Username, u.Role)
+		}
+	}
+	os.Exit(0)
+}
+
+// setRole is a CLI that changes the role of the user with the specified
+// username
+func setRole(dbConn *sql.DB, username, role string) {
+	fmt.Printf("Setting role of user %s to %s\n", username, role)
+	err := users.SetRole(dbConn, username, role)
+	if err != nil {
+		fmt.Println("Error setting role:", err)
+		os.Exit(1)
+	}
+
+	audit.Record(dbConn, audit.CLI, audit.SetRole, username, role)
+	fmt.Printf("User %s is now %s\n", username, role)
+	os.Exit(0)
+}
+
+// reset2FA is a CLI that disables two-factor authentication for the user with
+// the specified username, such as when they've lost their authenticator and
+// recovery codes
+func reset2FA(dbConn *sql.DB, username string) {
+	fmt.Println("Disabling two-factor authentication for user", username)
+	err := users.DisableTOTP(dbConn, username)
+	if err != nil {
+		fmt.Println("Error disabling two-factor authentication:", err)
+		os.Exit(1)
+	}
+
+	audit.Record(dbConn, audit.CLI, audit.Reset2FA, username, "")
+	fmt.Printf("User %s can now log in with only their password\n", username)
+	os.Exit(0)
+}
+
+// listSessions is a CLI that lists the devices the user with the specified
+// username is logged in on
+func listSessions(dbConn *sql.DB, username string, asJSON bool) {
+	sessions, err := users.ListSessions(dbConn, username, "")
+	if err != nil {
+		fmt.Println("Error retrieving sessions from the database:", err)
+		os.Exit(1)
+	}
+
+	if asJSON {
+		list := make([]sessionJSON, len(sessions))
+		for i, s := range sessions {
+			list[i] = sessionJSON{
+				ID:        s.ID,
+				IP:        s.IP,
+				UserAgent: s.UserAgent,
+				CreatedAt: s.CreatedAt,
+				LastSeen:  s.LastSeen,
+				Expires:   s.Expires,
+			}
+		}
+		printJSON(list)
+		os.Exit(0)
+	}
+
+	fmt.Println("Listing sessions for user", username)
+	if len(sessions) == 0 {
+		fmt.Println("- No sessions found")
+	} else {
+		for _, s := range sessions {
+			fmt.Printf("- %s from %s, logged in %s, last seen %s\n", s.UserAgent, s.IP, s.CreatedAt, s.LastSeen)
+		}
+	}
+	os.Exit(0)
+}
+
+// logoutEverywhere is a CLI that revokes every session of the user with the
+// specified username
+func logoutEverywhere(dbConn *sql.DB, username string) {
+	fmt.Println("Logging out user", username, "everywhere")
+	err := users.RevokeAllSessions(dbConn, username)
+	if err != nil {
+		fmt.Println("Error revoking sessions:", err)
+		os.Exit(1)
+	}
+
+	audit.Record(dbConn, audit.CLI, audit.LogoutEverywhere, username, "")
+	fmt.Printf("User %s has been logged out of every session\n", username)
+	os.Exit(0)
+}
+
+// resetPassword is a CLI that sets a new password for the user with the
+// specified username and logs them out everywhere. The password is prompted
+// for twice on a terminal, otherwise the first line of stdin is used so it can
+// be scripted.
+func resetPassword(dbConn *sql.DB, username string) {
+	fmt.Println("Resetting password for user", username)
+
+	var password string
+	if term.IsTerminal(int(syscall.Stdin)) {
+		fmt.Print("Enter new password: ")
+		entered, err := term.ReadPassword(int(syscall.Stdin))
+		if err != nil {
+			fmt.Println("Error reading password:", err)
+			os.Exit(1)
+		}
+		fmt.Println()
+
+		fmt.Print("Confirm new password: ")
+		confirmation, err := term.ReadPassword(int(syscall.Stdin))
+		if err != nil {
+			fmt.Println("Error reading password confirmation:", err)
+			os.Exit(1)
+		}
+		fmt.Println()
+
+		if string(entered) != string(confirmation) {
+			fmt.Println("Passwords do not match")
+			os.Exit(1)
+		}
+		password = string(entered)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			fmt.Println("Error reading password from stdin:", err)
+			os.Exit(1)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	err := users.SetPassword(dbConn, username, password)
+	if err != nil {
+		fmt.Println("Error setting password:", err)
+		os.Exit(1)
+	}
+	err = users.RevokeAllSessions(dbConn, username)
+	if err != nil {
+		fmt.Println("Error revoking sessions:", err)
+		os.Exit(1)
+	}
+
+	audit.Record(dbConn, audit.CLI, audit.ResetPassword, username, "")
+	fmt.Printf("Password for user %s changed and all their sessions logged out\n", username)
+	os.Exit(0)
+}
+
+// importManifests is a CLI that tracks the dependencies in each manifest or
+// SBOM for the user, untracking those that disappeared since the last import
+// if asked, and restores project lists from willow export or feed readers.
+// Their releases are fetched the next time Willow refreshes.
+func importManifests(dbConn *sql.DB, username string, paths []string, untrack, upstream bool) {
+	if username == "" || len(paths) == 0 {
+		fmt.Println("Usage: willow import --user <username> [--untrack] [--upstream] <manifest, SBOM, or project list>...")
+		fmt.Println("Supported manifests:", strings.Join(manifest.Supported(), ", "))
+		fmt.Println("SBOMs can be CycloneDX in JSON or XML, or SPDX in JSON or tag-value")
+		fmt.Println("Kubernetes manifests are YAML files of Kubernetes objects with any name")
+		fmt.Println("Project lists are files from willow export, or OPML from feed readers")
+		os.Exit(1)
+	}
+	role, err := users.GetRole(dbConn, username)
+	if err != nil {
+		fmt.Println("Error getting user's role:", err)
+		os.Exit(1)
+	}
+	if !users.CanEdit(role) {
+		fmt.Printf("User %s is a %s and can't track projects\n", username, role)
+		os.Exit(1)
+	}
+
+	mu := sync.Mutex{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Println("Error reading manifest:", err)
+			os.Exit(1)
+		}
+		if backup.Detect(path, data) {
+			restoreProjects(dbConn, &mu, username, path, data)
+			continue
+		}
+		doc, err := manifest.Parse(path, data)
+		if err != nil {
+			fmt.Println("Error parsing manifest:", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Resolving %d dependencies of %s\n", len(doc.Dependencies), doc.Name)
+		result, err := manifest.Import(dbConn, &mu, username, doc, manifest.Resolver{Upstream: upstream}, untrack)
+		if err != nil {
+			fmt.Println("Error importing dependencies:", err)
+			os.Exit(1)
 		}
+		for _, o := range result.Imported {
+			fmt.Printf("Tracking %s %s from %s\n", o.Source.Name, o.Dependency.Version, o.Source.URL)
+		}
+		for _, o := range result.Skipped {
+			fmt.Printf("Skipped %s: %v\n", o.Dependency.Name, o.Err)
+		}
+		for _, name := range result.Untracked {
+			fmt.Printf("Untracked %s\n", name)
+		}
+		fmt.Printf("Imported %d of %d dependencies of %s for %s\n", len(result.Imported), len(doc.Dependencies), doc.Name, username)
+	}
+	os.Exit(0)
+}
+
+// restoreProjects tracks the projects in a list from willow export or a feed
+// reader for the user
+func restoreProjects(dbConn *sql.DB, mu *sync.Mutex, username, path string, data []byte) {
+	list, err := backup.Decode(path, data)
+	if err != nil {
+		fmt.Println("Error reading project list:", err)
+		os.Exit(1)
+	}
+	result, err := backup.Restore(dbConn, mu, username, list)
+	if err != nil {
+		fmt.Println("Error restoring projects:", err)
+		os.Exit(1)
+	}
+	for _, s := range result.Skipped {
+		fmt.Printf("Skipped %s: %v\n", s.Name, s.Err)
+	}
+	fmt.Printf("Restored %d of %d projects from %s for %s\n", len(result.Restored), len(list.Projects), path, username)
+}
+
+// exportProjects is a CLI that writes the projects the user tracks to a file
+// in the given format, or the one its extension suggests
+func exportProjects(dbConn *sql.DB, username, format string, paths []string) {
+	if username == "" || len(paths) != 1 {
+		fmt.Println("Usage: willow export --user <username> [--format toml|json|opml] <file>")
+		os.Exit(1)
+	}
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(paths[0]), ".")
+	}
+	if _, err := users.GetRole(dbConn, username); err != nil {
+		fmt.Println("Error getting user:", err)
+		os.Exit(1)
+	}
+
+	list, err := backup.Export(dbConn, username)
+	if err != nil {
+		fmt.Println("Error listing projects:", err)
+		os.Exit(1)
+	}
+	var buf bytes.Buffer
+	if err := backup.Encode(&buf, list, format, "Projects "+username+" tracks in Willow"); err != nil {
+		fmt.Println("Error exporting projects:", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(paths[0], buf.Bytes(), 0o600); err != nil {
+		fmt.Println("Error writing export:", err)
+		os.Exit(1)
 	}
+	fmt.Printf("Exported %d projects %s tracks to %s\n", len(list.Projects), username, paths[0])
 	os.Exit(0)
 }
 
@@ -107,3 +401,309 @@ func checkAuthorised(dbConn *sql.DB, username string) {
 	}
 	os.Exit(0)
 }
+
+// userJSON and sessionJSON are what the --json flags of the user commands
+// print. Projects and releases are printed like the API returns them.
+type (
+	userJSON struct {
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+
+	sessionJSON struct {
+		ID        string `json:"id"`
+		IP        string `json:"ip"`
+		UserAgent string `json:"user_agent"`
+		CreatedAt string `json:"created_at"`
+		LastSeen  string `json:"last_seen"`
+		Expires   string `json:"expires"`
+	}
+)
+
+// printJSON prints v as indented JSON
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Println("Error encoding JSON:", err)
+		os.Exit(1)
+	}
+}
+
+// userRole returns the user's role, exiting when they don't exist
+func userRole(dbConn *sql.DB, username string) string {
+	role, err := users.GetRole(dbConn, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		fmt.Println("No user called", username)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Println("Error getting user:", err)
+		os.Exit(1)
+	}
+	return role
+}
+
+// requireEditor exits unless the user is allowed to change projects
+func requireEditor(dbConn *sql.DB, username string) {
+	if role := userRole(dbConn, username); !users.CanEdit(role) {
+		fmt.Printf("User %s is a %s and can't change projects\n", username, role)
+		os.Exit(1)
+	}
+}
+
+// findProject returns the project the user tracks with the given ID, URL, or
+// name, ignoring case, and exits when there isn't exactly one
+func findProject(dbConn *sql.DB, username, query string) project.Project {
+	p, err := project.Find(dbConn, username, query)
+	if errors.Is(err, sql.ErrNoRows) {
+		fmt.Printf("User %s doesn't track %s\n", username, query)
+		os.Exit(1)
+	} else if errors.Is(err, project.ErrAmbiguous) {
+		fmt.Printf("%s matches more than one project; give the ID from project list --json instead\n", query)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Println("Error retrieving projects from the database:", err)
+		os.Exit(1)
+	}
+	return p
+}
+
+// addProject is a CLI that tracks a project for the user. Its releases are
+// fetched the next time Willow refreshes.
+func addProject(dbConn *sql.DB, username, name, projectURL, forge, running string) {
+	requireEditor(dbConn, username)
+	if !project.ValidForge(forge) {
+		fmt.Printf("Unknown forge %s\n", forge)
+		os.Exit(1)
+	}
+	u, err := url.Parse(projectURL)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		fmt.Println("Invalid URL", projectURL)
+		os.Exit(1)
+	}
+	if name == "" {
+		name = path.Base(strings.TrimSuffix(u.Path, "/"))
+		if name == "." || name == "/" {
+			fmt.Println("Couldn't work out the project's name from its URL; give it with --name")
+			os.Exit(1)
+		}
+	}
+	if project.IsManaged(dbConn, username, project.GenProjectID(projectURL, name, forge)) {
+		fmt.Printf("%s is managed by the projects file\n", name)
+		os.Exit(1)
+	}
+
+	mu := sync.Mutex{}
+	project.Track(dbConn, &mu, nil, username, name, projectURL, forge, running)
+	fmt.Printf("Tracking %s %s from %s for %s\n", name, running, projectURL, username)
+	os.Exit(0)
+}
+
+// listProjects is a CLI that lists the projects the user tracks with the
+// releases stored for them, or only those that are outdated
+func listProjects(dbConn *sql.DB, username string, outdated, asJSON bool) {
+	userRole(dbConn, username)
+	projects, err := project.GetStoredProjectsWithReleases(dbConn, username)
+	if err != nil {
+		fmt.Println("Error retrieving projects from the database:", err)
+		os.Exit(1)
+	}
+	if outdated {
+		var filtered []project.Project
+		for _, p := range projects {
+			if p.Outdated() {
+				filtered = append(filtered, p)
+			}
+		}
+		projects = filtered
+	}
+
+	list := make([]api.Project, len(projects))
+	for i, p := range projects {
+		list[i] = api.FromProject(p)
+	}
+	if asJSON {
+		printJSON(list)
+	} else {
+		printProjects(list)
+	}
+	os.Exit(0)
+}
+
+// printProjects prints a line for each project, followed by the advisories
+// affecting it and its deployments
+func printProjects(projects []api.Project) {
+	if len(projects) == 0 {
+		fmt.Println("- No projects found")
+	}
+	for _, p := range projects {
+		line := fmt.Sprintf("- %s %s", p.Name, p.Running)
+		if p.Latest == "" {
+			line += ", no releases fetched yet"
+		} else if p.Latest != p.Running {
+			line += ", latest is " + p.Latest
+		}
+		if p.Managed {
+			line += " (managed)"
+		}
+		fmt.Println(line)
+		for _, a := range p.AffectedBy {
+			fmt.Printf("  ! %s: %s\n", a.ID, a.Summary)
+		}
+		for _, d := range p.Deployments {
+			fmt.Printf("  - %s runs %s (%s)\n", d.Name, d.Running, d.Owner)
+			for _, a := range d.AffectedBy {
+				fmt.Printf("    ! %s: %s\n", a.ID, a.Summary)
+			}
+		}
+	}
+}
+
+// removeProject is a CLI that untracks one of the user's projects or removes
+// one of its deployments
+func removeProject(dbConn *sql.DB, username, query, deployment string) {
+	requireEditor(dbConn, username)
+	p := findProject(dbConn, username, query)
+	mu := sync.Mutex{}
+
+	if deployment != "" {
+		d, ok, err := project.FindDeployment(dbConn, username, p.ID, deployment)
+		if err != nil {
+			fmt.Println("Error retrieving deployments from the database:", err)
+			os.Exit(1)
+		}
+		if !ok {
+			fmt.Printf("%s has no deployment called %s\n", p.Name, deployment)
+			os.Exit(1)
+		}
+		if err := project.DeleteDeployment(dbConn, &mu, d.ID); err != nil {
+			fmt.Println("Error deleting deployment:", err)
+			os.Exit(1)
+		}
+		audit.Record(dbConn, username, audit.DeleteDeployment, d.Name, "")
+		fmt.Printf("Removed deployment %s of %s\n", d.Name, p.Name)
+		os.Exit(0)
+	}
+
+	if p.Managed {
+		fmt.Printf("%s is managed by the projects file\n", p.Name)
+		os.Exit(1)
+	}
+	project.Untrack(dbConn, &mu, username, p.ID)
+	fmt.Printf("Untracked %s for %s\n", p.Name, username)
+	os.Exit(0)
+}
+
+// setRunning is a CLI that sets the version of a project the user or one of
+// its deployments is running. Deployments that don't exist yet are added and
+// owned by the user.
+func setRunning(dbConn *sql.DB, username, query, version, deployment, notes string) {
+	requireEditor(dbConn, username)
+	p := findProject(dbConn, username, query)
+	mu := sync.Mutex{}
+
+	err := project.SetRunning(dbConn, &mu, username, p, version, deployment, notes)
+	if errors.Is(err, project.ErrManaged) {
+		fmt.Printf("%s is managed by the projects file\n", p.Name)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Println("Error setting running version:", err)
+		os.Exit(1)
+	}
+	if deployment == "" {
+		fmt.Printf("User %s is now running %s %s\n", username, p.Name, version)
+	} else {
+		fmt.Printf("Deployment %s of %s is now running %s\n", deployment, p.Name, version)
+	}
+	os.Exit(0)
+}
+
+// refreshProjects is a CLI that fetches the latest releases of every project,
+// or of one the user tracks, the way the server does on each refresh.
+// Notifications about new releases are sent from here rather than the server,
+// which will already know about them.
+func refreshProjects(dbConn *sql.DB, username, query string) {
+	var projects []project.Project
+	if query != "" {
+		userRole(dbConn, username)
+		projects = []project.Project{findProject(dbConn, username, query)}
+	} else {
+		var err error
+		projects, err = project.GetProjects(dbConn)
+		if err != nil {
+			fmt.Println("Error retrieving projects from the database:", err)
+			os.Exit(1)
+		}
+	}
+
+	mu := sync.Mutex{}
+	n := notifiers()
+	projects = project.Refresh(dbConn, &mu, projects, n)
+	if sources := advisorySources(); query == "" && len(sources) > 0 {
+		advisory.Refresh(dbConn, &mu, sources, n)
+	}
+	for _, p := range projects {
+		if latest := p.Latest(); latest != "" {
+			fmt.Printf("- %s: latest is %s\n", p.Name, latest)
+		} else {
+			fmt.Printf("- %s: no releases found\n", p.Name)
+		}
+	}
+	os.Exit(0)
+}
+
+// listReleases is a CLI that lists the releases stored for one of the user's
+// projects, newest first
+func listReleases(dbConn *sql.DB, username, query string, asJSON bool) {
+	userRole(dbConn, username)
+	p, err := project.GetStoredReleases(dbConn, findProject(dbConn, username, query))
+	if err != nil {
+		fmt.Println("Error retrieving releases from the database:", err)
+		os.Exit(1)
+	}
+
+	if asJSON {
+		printJSON(api.FromReleases(p.Releases))
+		os.Exit(0)
+	}
+
+	if len(p.Releases) == 0 {
+		fmt.Println("- No releases fetched yet; fetch them with willow project refresh")
+	}
+	for _, r := range p.Releases {
+		line := "- " + r.Tag
+		if !r.Date.IsZero() {
+			line += " (" + r.Date.Format(time.DateOnly) + ")"
+		}
+		if r.Tag == p.Running {
+			line += ", running"
+		}
+		fmt.Println(line, r.URL)
+	}
+	os.Exit(0)
+}
+
+// apiToken is a CLI that gives the user a new API token and prints only the
+// token so scripts can capture it, or revokes the one they have
+func apiToken(dbConn *sql.DB, username string, revoke bool) {
+	userRole(dbConn, username)
+
+	if revoke {
+		if err := users.RevokeAPIToken(dbConn, username); err != nil {
+			fmt.Println("Error revoking API token:", err)
+			os.Exit(1)
+		}
+		audit.Record(dbConn, audit.CLI, audit.RevokeAPIToken, username, "")
+		fmt.Printf("API token of user %s revoked\n", username)
+		os.Exit(0)
+	}
+
+	token, err := users.GenerateAPIToken(dbConn, username)
+	if err != nil {
+		fmt.Println("Error generating API token:", err)
+		os.Exit(1)
+	}
+	audit.Record(dbConn, audit.CLI, audit.CreateAPIToken, username, "")
+	fmt.Println(token)
+	os.Exit(0)
+}
@@ -0,0 +1,293 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	flag "github.com/spf13/pflag"
+)
+
+const usageText = `Usage: willow [--config <file>] [command]
+
+Server:
+  serve                      Refresh releases and serve the web UI (the default)
+
+Users:
+  user add [--role <role>] <username>
+  user delete <username>
+  user list [--json]
+  user check <username>      Check whether a password is correct
+  user passwd <username>     Set a new password and log the user out everywhere;
+                             it's read from stdin when that isn't a terminal
+  user role <username> <role>
+  user reset-2fa <username>  Let the user log in with only their password
+  user sessions [--json] <username>
+  user logout <username>     Log the user out everywhere
+  user token [--revoke] <username>
+                             Print a new API token for the user, replacing theirs
+
+Projects:
+  project add --user <username> --forge <forge> [--name <name>] <url> <version>
+  project list --user <username> [--outdated] [--json]
+  project remove --user <username> [--deployment <name>] <project>
+  project set-running --user <username> [--deployment <name> [--notes <notes>]] <project> <version>
+  project refresh [--user <username> <project>]
+  releases --user <username> [--json] <project>
+  import --user <username> [--untrack] [--upstream] <file>...
+  export --user <username> [--format toml|json|opml] <file>
+  reconcile [--dry-run]      Apply the projects file from the config
+
+Database:
+  migrate status             Show the schema version and whether it's dirty
+  migrate up [--to <version>]
+                             Apply pending migrations, all of them by default
+  migrate down [--to <version>]
+                             Roll back migrations, one of them by default; stop
+                             the server first
+
+Remote, through the API of a server instead of the database:
+  remote [--config <file>] outdated [--json]
+                             List outdated projects; exits 1 if there are any
+  remote [--config <file>] set-running [--deployment <name> [--notes <notes>]] <project> <version>
+  remote [--config <file>] refresh
+                             Fetch new releases now
+
+Roles are admin, editor, and viewer. Forges are github, gitea, forgejo, gitlab,
+sourcehut, bitbucket, container, and other. Projects are given by their name,
+URL, or the ID from project list --json.
+
+The remote commands read the server's URL and an API token from URL and Token
+in willow/remote.toml in your config directory, or from WILLOW_URL and
+WILLOW_TOKEN, and exit 2 when something goes wrong.
+
+Flags:
+`
+
+func usage() {
+	fmt.Print(usageText)
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.PrintDefaults()
+}
+
+// newFlagSet returns the flags of a command, which prints its usage line when
+// they're wrong
+func newFlagSet(usageLine string) *flag.FlagSet {
+	fs := flag.NewFlagSet("willow "+usageLine, flag.ExitOnError)
+	fs.SetOutput(os.Stdout)
+	fs.Usage = func() {
+		fmt.Println("Usage: willow", usageLine)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses a command's flags and exits with its usage unless n
+// arguments are left, or at least one when n is -1
+func parseArgs(fs *flag.FlagSet, args []string, n int) []string {
+	// fs exits on errors by itself
+	_ = fs.Parse(args)
+	if (n >= 0 && fs.NArg() != n) || (n < 0 && fs.NArg() == 0) {
+		fs.Usage()
+		os.Exit(1)
+	}
+	return fs.Args()
+}
+
+// userFlag adds the --user flag that commands acting on a user's projects
+// need
+func userFlag(fs *flag.FlagSet) *string {
+	return fs.StringP("user", "u", "", "Username of the account whose projects to use")
+}
+
+// requireUser exits with the command's usage when --user wasn't given
+func requireUser(fs *flag.FlagSet, username string) {
+	if username == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+}
+
+// parseCommand parses the command line, exiting with the usage when it's
+// wrong, and returns the name of the command and a function that runs it once
+// the database is open
+func parseCommand(args []string) (string, func(dbConn *sql.DB)) {
+	if len(args) == 0 {
+		return "serve", serve
+	}
+
+	switch args[0] {
+	case "help":
+		usage()
+		os.Exit(0)
+	case "serve":
+		parseArgs(newFlagSet("serve"), args[1:], 0)
+		return "serve", serve
+	case "user":
+		if len(args) > 1 {
+			if run := parseUserCommand(args[1], args[2:]); run != nil {
+				return "user", run
+			}
+		}
+	case "project":
+		if len(args) > 1 {
+			if run := parseProjectCommand(args[1], args[2:]); run != nil {
+				return "project", run
+			}
+		}
+	case "releases":
+		fs := newFlagSet("releases --user <username> [--json] <project>")
+		username := userFlag(fs)
+		asJSON := fs.Bool("json", false, "Print the releases as JSON")
+		a := parseArgs(fs, args[1:], 1)
+		requireUser(fs, *username)
+		return "releases", func(dbConn *sql.DB) { listReleases(dbConn, *username, a[0], *asJSON) }
+	case "import":
+		fs := newFlagSet("import --user <username> [--untrack] [--upstream] <manifest, SBOM, or project list>...")
+		username := userFlag(fs)
+		untrack := fs.Bool("untrack", false, "Untrack projects whose dependencies disappeared since the files were last imported")
+		upstream := fs.Bool("upstream", false, "Track the source repos container images link to instead of the images")
+		a := parseArgs(fs, args[1:], -1)
+		return "import", func(dbConn *sql.DB) { importManifests(dbConn, *username, a, *untrack, *upstream) }
+	case "export":
+		fs := newFlagSet("export --user <username> [--format toml|json|opml] <file>")
+		username := userFlag(fs)
+		format := fs.String("format", "", "Format to export: toml, json, or opml; defaults to the file's extension")
+		a := parseArgs(fs, args[1:], 1)
+		return "export", func(dbConn *sql.DB) { exportProjects(dbConn, *username, *format, a) }
+	case "reconcile":
+		fs := newFlagSet("reconcile [--dry-run]")
+		dryRun := fs.Bool("dry-run", false, "Print the changes the projects file would make without making them")
+		parseArgs(fs, args[1:], 0)
+		return "reconcile", func(dbConn *sql.DB) { reconcileProjects(dbConn, config.ProjectsFile, *dryRun) }
+	case "migrate":
+		if len(args) > 1 {
+			if run := parseMigrateCommand(args[1], args[2:]); run != nil {
+				return "migrate", run
+			}
+		}
+	}
+
+	usage()
+	os.Exit(1)
+	return "", nil
+}
+
+// parseUserCommand parses the arguments of a user subcommand, returning nil if
+// there's no such subcommand
+func parseUserCommand(name string, args []string) func(dbConn *sql.DB) {
+	switch name {
+	case "add":
+		fs := newFlagSet("user add [--role admin|editor|viewer] <username>")
+		role := fs.StringP("role", "r", "", "Role of the new user; the first user is an admin and the rest are editors by default")
+		a := parseArgs(fs, args, 1)
+		return func(dbConn *sql.DB) { createUser(dbConn, a[0], *role) }
+	case "delete":
+		a := parseArgs(newFlagSet("user delete <username>"), args, 1)
+		return func(dbConn *sql.DB) { deleteUser(dbConn, a[0]) }
+	case "list":
+		fs := newFlagSet("user list [--json]")
+		asJSON := fs.Bool("json", false, "Print the users as JSON")
+		parseArgs(fs, args, 0)
+		return func(dbConn *sql.DB) { listUsers(dbConn, *asJSON) }
+	case "check":
+		a := parseArgs(newFlagSet("user check <username>"), args, 1)
+		return func(dbConn *sql.DB) { checkAuthorised(dbConn, a[0]) }
+	case "passwd":
+		a := parseArgs(newFlagSet("user passwd <username>"), args, 1)
+		return func(dbConn *sql.DB) { resetPassword(dbConn, a[0]) }
+	case "role":
+		a := parseArgs(newFlagSet("user role <username> admin|editor|viewer"), args, 2)
+		return func(dbConn *sql.DB) { setRole(dbConn, a[0], a[1]) }
+	case "reset-2fa":
+		a := parseArgs(newFlagSet("user reset-2fa <username>"), args, 1)
+		return func(dbConn *sql.DB) { reset2FA(dbConn, a[0]) }
+	case "sessions":
+		fs := newFlagSet("user sessions [--json] <username>")
+		asJSON := fs.Bool("json", false, "Print the sessions as JSON")
+		a := parseArgs(fs, args, 1)
+		return func(dbConn *sql.DB) { listSessions(dbConn, a[0], *asJSON) }
+	case "logout":
+		a := parseArgs(newFlagSet("user logout <username>"), args, 1)
+		return func(dbConn *sql.DB) { logoutEverywhere(dbConn, a[0]) }
+	case "token":
+		fs := newFlagSet("user token [--revoke] <username>")
+		revoke := fs.Bool("revoke", false, "Revoke the user's API token instead of replacing it")
+		a := parseArgs(fs, args, 1)
+		return func(dbConn *sql.DB) { apiToken(dbConn, a[0], *revoke) }
+	}
+	return nil
+}
+
+// parseProjectCommand parses the arguments of a project subcommand, returning
+// nil if there's no such subcommand
+func parseProjectCommand(name string, args []string) func(dbConn *sql.DB) {
+	switch name {
+	case "add":
+		fs := newFlagSet("project add --user <username> --forge <forge> [--name <name>] <url> <version>")
+		username := userFlag(fs)
+		forge := fs.String("forge", "", "Forge the project is on, which decides how its releases are fetched")
+		projectName := fs.String("name", "", "Name of the project; defaults to the last part of the URL")
+		a := parseArgs(fs, args, 2)
+		requireUser(fs, *username)
+		if *forge == "" {
+			fs.Usage()
+			os.Exit(1)
+		}
+		return func(dbConn *sql.DB) { addProject(dbConn, *username, *projectName, a[0], *forge, a[1]) }
+	case "list":
+		fs := newFlagSet("project list --user <username> [--outdated] [--json]")
+		username := userFlag(fs)
+		outdated := fs.Bool("outdated", false, "Only list projects where the user or a deployment isn't running the latest release")
+		asJSON := fs.Bool("json", false, "Print the projects as JSON")
+		parseArgs(fs, args, 0)
+		requireUser(fs, *username)
+		return func(dbConn *sql.DB) { listProjects(dbConn, *username, *outdated, *asJSON) }
+	case "remove":
+		fs := newFlagSet("project remove --user <username> [--deployment <name>] <project>")
+		username := userFlag(fs)
+		deployment := fs.String("deployment", "", "Remove only this deployment of the project")
+		a := parseArgs(fs, args, 1)
+		requireUser(fs, *username)
+		return func(dbConn *sql.DB) { removeProject(dbConn, *username, a[0], *deployment) }
+	case "set-running":
+		fs := newFlagSet("project set-running --user <username> [--deployment <name> [--notes <notes>]] <project> <version>")
+		username := userFlag(fs)
+		deployment := fs.String("deployment", "", "Set the version this deployment is running instead, adding it if it's new")
+		notes := fs.String("notes", "", "Notes about the deployment")
+		a := parseArgs(fs, args, 2)
+		requireUser(fs, *username)
+		return func(dbConn *sql.DB) { setRunning(dbConn, *username, a[0], a[1], *deployment, *notes) }
+	case "refresh":
+		fs := newFlagSet("project refresh [--user <username> <project>]")
+		username := userFlag(fs)
+		// fs exits on errors by itself
+		_ = fs.Parse(args)
+		if fs.NArg() > 1 || (fs.NArg() == 1) != (*username != "") {
+			fs.Usage()
+			os.Exit(1)
+		}
+		return func(dbConn *sql.DB) { refreshProjects(dbConn, *username, fs.Arg(0)) }
+	}
+	return nil
+}
+
+// parseMigrateCommand parses the arguments of a migrate subcommand, returning
+// nil if there's no such subcommand
+func parseMigrateCommand(name string, args []string) func(dbConn *sql.DB) {
+	switch name {
+	case "status":
+		parseArgs(newFlagSet("migrate status"), args, 0)
+		return migrateStatus
+	case "up", "down":
+		fs := newFlagSet("migrate " + name + " [--to <version>]")
+		to := fs.Int("to", 0, "Schema version to migrate to")
+		parseArgs(fs, args, 0)
+		return func(dbConn *sql.DB) { migrate(dbConn, name == "up", *to, fs.Changed("to")) }
+	}
+	return nil
+}
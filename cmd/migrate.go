@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	"git.sr.ht/~amolith/willow/db"
+)
+
+// migrateStatus is a CLI that prints the schema version of the database and
+// how it compares to the migrations this version of Willow has
+func migrateStatus(dbConn *sql.DB) {
+	version, dirty := db.SchemaStatus(dbConn)
+	latest := db.LatestVersion()
+	fmt.Println("Schema version:", version)
+	fmt.Println("Latest version:", latest)
+	fmt.Println("Dirty:", dirty)
+	if dirty {
+		fmt.Println("The last migration failed and was rolled back, so the schema is still at version", version)
+	}
+
+	switch pending := latest - version; {
+	case pending < 0:
+		fmt.Println("The schema is newer than this version of Willow understands")
+	case pending == 0:
+		fmt.Println("The schema is up to date")
+	case pending == 1:
+		fmt.Println("1 migration is pending; apply it with willow migrate up")
+	default:
+		fmt.Printf("%d migrations are pending; apply them with willow migrate up\n", pending)
+	}
+	os.Exit(0)
+}
+
+// migrate is a CLI that applies migrations up to the given version or rolls
+// them back down to it. Without a version, up applies all pending migrations
+// and down rolls back the latest one.
+func migrate(dbConn *sql.DB, up bool, target int, haveTarget bool) {
+	version, _ := db.SchemaStatus(dbConn)
+	if !haveTarget && up {
+		target = db.LatestVersion()
+	} else if !haveTarget {
+		target = version - 1
+	}
+
+	if up && target < version {
+		fmt.Printf("The schema is already at version %d; use willow migrate down to roll it back\n", version)
+		os.Exit(1)
+	}
+	if !up && target > version {
+		fmt.Printf("The schema is only at version %d; use willow migrate up to apply migrations\n", version)
+		os.Exit(1)
+	}
+	if target == version {
+		fmt.Println("The schema is already at version", version)
+		os.Exit(0)
+	}
+
+	if err := db.MigrateTo(dbConn, target); err != nil {
+		version, _ = db.SchemaStatus(dbConn)
+		fmt.Printf("Error migrating database schema: %v; it's now at version %d\n", err, version)
+		os.Exit(1)
+	}
+	fmt.Printf("Migrated the schema from version %d to %d\n", version, target)
+	os.Exit(0)
+}
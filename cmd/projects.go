@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/BurntSushi/toml"
+
+	"git.sr.ht/~amolith/willow/project"
+)
+
+// projectsFile is the declarative list of projects, written in the same style
+// as the config:
+//
+//	[[Projects]]
+//	Name = "Willow"
+//	URL = "https://git.sr.ht/~amolith/willow"
+//	Forge = "sourcehut"
+//	Running = "v0.0.1"
+//	Users = ["amolith"]
+type projectsFile struct {
+	Projects []project.Managed
+}
+
+func loadProjectsFile(path string) ([]project.Managed, error) {
+	var file projectsFile
+	md, err := toml.DecodeFile(path, &file)
+	if err != nil {
+		return nil, err
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown key %s", undecoded[0])
+	}
+	return file.Projects, nil
+}
+
+// applyProjectsFile reconciles the projects file into the database, printing
+// what changed
+func applyProjectsFile(dbConn *sql.DB, mu *sync.Mutex, path string) error {
+	projects, err := loadProjectsFile(path)
+	if err != nil {
+		return err
+	}
+	changes, err := project.Reconcile(dbConn, mu, projects, false)
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	if err != nil {
+		return err
+	}
+	if len(changes) > 0 {
+		// Fetch releases for new projects without waiting for the next
+		// refresh
+		project.RequestRefresh(&manualRefresh)
+	}
+	return nil
+}
+
+// reloadProjectsFile reconciles the projects file again whenever Willow gets
+// a SIGHUP. Mistakes in the file are printed and leave the projects as they
+// were.
+func reloadProjectsFile(dbConn *sql.DB, mu *sync.Mutex, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		fmt.Println("Reloading projects from", path)
+		if err := applyProjectsFile(dbConn, mu, path); err != nil {
+			fmt.Println("Error reconciling projects file:", err)
+		}
+	}
+}
+
+// reconcileProjects is a CLI that reconciles the projects file once or, in a
+// dry run, prints the changes it would make
+func reconcileProjects(dbConn *sql.DB, path string, dryRun bool) {
+	if path == "" {
+		fmt.Println("ProjectsFile isn't set in the config")
+		os.Exit(1)
+	}
+	projects, err := loadProjectsFile(path)
+	if err != nil {
+		fmt.Println("Error reading projects file:", err)
+		os.Exit(1)
+	}
+
+	mu := sync.Mutex{}
+	changes, err := project.Reconcile(dbConn, &mu, projects, dryRun)
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	if err != nil {
+		fmt.Println("Error reconciling projects file:", err)
+		os.Exit(1)
+	}
+	if len(changes) == 0 {
+		fmt.Println("Projects already match", path)
+	} else if dryRun && len(changes) == 1 {
+		fmt.Println("1 change would be made; run without --dry-run to make it")
+	} else if dryRun {
+		fmt.Printf("%d changes would be made; run without --dry-run to make them\n", len(changes))
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,170 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	flag "github.com/spf13/pflag"
+
+	"git.sr.ht/~amolith/willow/api"
+)
+
+// remoteConfig is the server the remote commands talk to:
+//
+//	URL = "https://willow.example.com"
+//	Token = "..."
+//
+// The WILLOW_URL and WILLOW_TOKEN environment variables override it.
+type remoteConfig struct {
+	URL   string
+	Token string
+}
+
+// Exit codes of the remote commands. Outdated is only used by the outdated
+// command, so scripts can tell projects needing updates from failures.
+const (
+	remoteOutdated = 1
+	remoteFailed   = 2
+)
+
+// remote runs one of the remote commands, which use the API of a running
+// server instead of opening the database
+func remote(args []string) {
+	flags := remoteFlagSet("remote [--config <file>] outdated|set-running|refresh")
+	flags.SetInterspersed(false)
+	path := flags.String("config", "", "Path to the remote config file; defaults to willow/remote.toml in your config directory")
+	parseRemoteArgs(flags, args, -1)
+
+	client, err := remoteClient(*path)
+	if err != nil {
+		fmt.Println("Error reading remote config:", err)
+		os.Exit(remoteFailed)
+	}
+
+	args = flags.Args()[1:]
+	switch flags.Arg(0) {
+	case "outdated":
+		fs := remoteFlagSet("remote outdated [--json]")
+		asJSON := fs.Bool("json", false, "Print the projects as JSON")
+		parseRemoteArgs(fs, args, 0)
+		remoteListOutdated(client, *asJSON)
+	case "set-running":
+		fs := remoteFlagSet("remote set-running [--deployment <name> [--notes <notes>]] <project> <version>")
+		deployment := fs.String("deployment", "", "Set the version this deployment is running instead, adding it if it's new")
+		notes := fs.String("notes", "", "Notes about the deployment")
+		a := parseRemoteArgs(fs, args, 2)
+		remoteSetRunning(client, api.SetRunning{Project: a[0], Version: a[1], Deployment: *deployment, Notes: *notes})
+	case "refresh":
+		parseRemoteArgs(remoteFlagSet("remote refresh"), args, 0)
+		if err := client.Refresh(); err != nil {
+			fmt.Println("Error refreshing:", err)
+			os.Exit(remoteFailed)
+		}
+		fmt.Println("Willow is fetching new releases")
+		os.Exit(0)
+	}
+
+	flags.Usage()
+	os.Exit(remoteFailed)
+}
+
+// remoteFlagSet is newFlagSet for remote commands, which exit with
+// remoteFailed rather than 1 when they're used wrong
+func remoteFlagSet(usageLine string) *flag.FlagSet {
+	fs := newFlagSet(usageLine)
+	fs.Init("willow "+usageLine, flag.ContinueOnError)
+	return fs
+}
+
+// parseRemoteArgs is parseArgs for remote commands
+func parseRemoteArgs(fs *flag.FlagSet, args []string, n int) []string {
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil || (n >= 0 && fs.NArg() != n) || (n < 0 && fs.NArg() == 0) {
+		if err == nil {
+			fs.Usage()
+		}
+		os.Exit(remoteFailed)
+	}
+	return fs.Args()
+}
+
+// remoteClient returns a client for the server in the remote config file, if
+// there is one, and the environment
+func remoteClient(path string) (api.Client, error) {
+	var config remoteConfig
+	explicit := path != ""
+	if !explicit {
+		dir, err := os.UserConfigDir()
+		if err == nil {
+			path = filepath.Join(dir, "willow", "remote.toml")
+		}
+	}
+	if path != "" {
+		_, err := toml.DecodeFile(path, &config)
+		if err != nil && (explicit || !errors.Is(err, os.ErrNotExist)) {
+			return api.Client{}, err
+		}
+	}
+	if url := os.Getenv("WILLOW_URL"); url != "" {
+		config.URL = url
+	}
+	if token := os.Getenv("WILLOW_TOKEN"); token != "" {
+		config.Token = token
+	}
+
+	if config.URL == "" || config.Token == "" {
+		return api.Client{}, errors.New("the server's URL and an API token are needed, from the config file or WILLOW_URL and WILLOW_TOKEN")
+	}
+	return api.Client{
+		HTTP:  &http.Client{Timeout: time.Minute},
+		URL:   config.URL,
+		Token: config.Token,
+	}, nil
+}
+
+// remoteListOutdated is a CLI that lists the user's outdated projects and
+// exits with remoteOutdated if there are any
+func remoteListOutdated(client api.Client, asJSON bool) {
+	projects, err := client.Projects(true)
+	if err != nil {
+		fmt.Println("Error listing projects:", err)
+		os.Exit(remoteFailed)
+	}
+
+	if asJSON {
+		printJSON(projects)
+	} else if len(projects) == 0 {
+		fmt.Println("Everything is up to date")
+	} else {
+		printProjects(projects)
+	}
+	if len(projects) > 0 {
+		os.Exit(remoteOutdated)
+	}
+	os.Exit(0)
+}
+
+// remoteSetRunning is a CLI that sets the version the user or one of their
+// deployments is running, such as after deploying it
+func remoteSetRunning(client api.Client, req api.SetRunning) {
+	if err := client.SetRunning(req); err != nil {
+		fmt.Println("Error setting running version:", err)
+		os.Exit(remoteFailed)
+	}
+	if req.Deployment == "" {
+		fmt.Printf("Now running %s %s\n", req.Project, req.Version)
+	} else {
+		fmt.Printf("Deployment %s of %s is now running %s\n", req.Deployment, req.Project, req.Version)
+	}
+	os.Exit(0)
+}
@@ -5,19 +5,31 @@
 package main
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"git.sr.ht/~amolith/willow/advisory"
+	"git.sr.ht/~amolith/willow/api"
 	"git.sr.ht/~amolith/willow/db"
+	"git.sr.ht/~amolith/willow/email"
+	"git.sr.ht/~amolith/willow/notify"
+	"git.sr.ht/~amolith/willow/oidc"
 	"git.sr.ht/~amolith/willow/project"
+	"git.sr.ht/~amolith/willow/users"
 	"git.sr.ht/~amolith/willow/ws"
 
 	"github.com/BurntSushi/toml"
+	"github.com/go-webauthn/webauthn/webauthn"
 	flag "github.com/spf13/pflag"
 )
 
@@ -28,34 +40,123 @@ type (
 		// TODO: Make cache location configurable
 		// CacheLocation string
 		FetchInterval int
+		// ProjectsFile lists projects that are tracked declaratively
+		ProjectsFile  string
+		Notifications notifications
+		Advisories    advisories
+		OIDC          oidcConfig
+		ProxyAuth     proxyAuth
+		SMTP          smtpConfig
+		Passwords     passwords
 	}
 
 	server struct {
-		Listen string
+		Listen          string
+		BaseURL         string
+		TrustedProxies  []string
+		SessionLifetime int
+	}
+
+	notifications struct {
+		Matrix matrix
+		XMPP   xmpp
+	}
+
+	matrix struct {
+		Homeserver  string
+		AccessToken string
+		RoomID      string
+	}
+
+	advisories struct {
+		// Directory holds an offline copy of an OSV database
+		Directory   string
+		OSV         bool
+		OSVURL      string
+		GitHub      bool
+		GitHubToken string
+	}
+
+	oidcConfig struct {
+		Issuer        string
+		ClientID      string
+		ClientSecret  string
+		Scopes        []string
+		UsernameClaim string
+		DefaultRole   string
+	}
+
+	proxyAuth struct {
+		Header      string
+		DefaultRole string
+	}
+
+	// passwords are the argon2id parameters; Memory is in KiB
+	passwords struct {
+		Time    uint32
+		Memory  uint32
+		Threads uint8
+	}
+
+	smtpConfig struct {
+		Host     string
+		Port     int
+		Username string
+		Password string
+		From     string
+	}
+
+	xmpp struct {
+		JID       string
+		Password  string
+		Server    string
+		Recipient string
+		MUC       bool
+		Nick      string
 	}
 )
 
 var (
-	flagConfig          = flag.StringP("config", "c", "config.toml", "Path to config file")
-	flagAddUser         = flag.StringP("add", "a", "", "Username of account to add")
-	flagDeleteUser      = flag.StringP("deleteuser", "d", "", "Username of account to delete")
-	flagCheckAuthorised = flag.StringP("validatecredentials", "v", "", "Username of account to check")
-	flagListUsers       = flag.BoolP("listusers", "l", false, "List all users")
-	config              Config
-	req                 = make(chan struct{})
-	res                 = make(chan []project.Project)
-	manualRefresh       = make(chan struct{})
+	flagConfig    = flag.StringP("config", "c", "config.toml", "Path to config file")
+	config        Config
+	req           = make(chan struct{})
+	res           = make(chan []project.Project)
+	manualRefresh = make(chan struct{}, 1)
 )
 
 func main() {
+	// Flags after the command belong to it
+	flag.CommandLine.SetInterspersed(false)
+	flag.Usage = usage
 	flag.Parse()
 
+	// Remote commands don't need the config or database
+	if flag.Arg(0) == "remote" {
+		remote(flag.Args()[1:])
+	}
+
+	command, run := parseCommand(flag.Args())
+
 	err := checkConfig()
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	fmt.Println("Opening database at", config.DBConn)
+	err = users.SetHashParams(users.HashParams{
+		Time:    config.Passwords.Time,
+		Memory:  config.Passwords.Memory,
+		Threads: config.Passwords.Threads,
+	})
+	if err != nil {
+		log.Fatalln("Invalid [Passwords] config:", err)
+	}
+
+	// Only the server says what it's doing while starting so the output of
+	// other commands can be read by scripts
+	serving := command == "serve"
+	if serving {
+		fmt.Println("Opening database at", config.DBConn)
+	}
 
 	dbConn, err := db.Open(config.DBConn)
 	if err != nil {
@@ -63,56 +164,152 @@ func main() {
 		os.Exit(1)
 	}
 
-	fmt.Println("Checking whether database needs initialising")
+	if serving {
+		fmt.Println("Checking whether database needs initialising")
+	}
 	err = db.InitialiseDatabase(dbConn)
 	if err != nil {
 		fmt.Println("Error initialising database:", err)
 		os.Exit(1)
 	}
-	fmt.Println("Checking whether there are pending migrations")
-	err = db.Migrate(dbConn)
-	if err != nil {
-		fmt.Println("Error migrating database schema:", err)
-		os.Exit(1)
+	// The migrate command applies migrations itself, and can still show the
+	// status of a schema that's too new
+	if command != "migrate" {
+		if serving {
+			fmt.Println("Checking whether there are pending migrations")
+		}
+		err = db.Migrate(dbConn)
+		if errors.Is(err, db.ErrSchemaTooNew) {
+			fmt.Println("Error migrating database schema:", err)
+			fmt.Println("Upgrade Willow, or roll the schema back with willow migrate down from the version that migrated it")
+			os.Exit(1)
+		} else if err != nil {
+			fmt.Println("Error migrating database schema:", err)
+			os.Exit(1)
+		}
 	}
 
-	if len(*flagAddUser) > 0 && len(*flagDeleteUser) == 0 && !*flagListUsers && len(*flagCheckAuthorised) == 0 {
-		createUser(dbConn, *flagAddUser)
-		os.Exit(0)
-	} else if len(*flagAddUser) == 0 && len(*flagDeleteUser) > 0 && !*flagListUsers && len(*flagCheckAuthorised) == 0 {
-		deleteUser(dbConn, *flagDeleteUser)
-		os.Exit(0)
-	} else if len(*flagAddUser) == 0 && len(*flagDeleteUser) == 0 && *flagListUsers && len(*flagCheckAuthorised) == 0 {
-		listUsers(dbConn)
-		os.Exit(0)
-	} else if len(*flagAddUser) == 0 && len(*flagDeleteUser) == 0 && !*flagListUsers && len(*flagCheckAuthorised) > 0 {
-		checkAuthorised(dbConn, *flagCheckAuthorised)
-		os.Exit(0)
-	}
+	run(dbConn)
+}
 
+// serve runs the refresh loop and the web server
+func serve(dbConn *sql.DB) {
 	mu := sync.Mutex{}
 
+	if config.ProjectsFile != "" {
+		fmt.Println("Reconciling projects from", config.ProjectsFile)
+		if err := applyProjectsFile(dbConn, &mu, config.ProjectsFile); err != nil {
+			fmt.Println("Error reconciling projects file:", err)
+			os.Exit(1)
+		}
+		go reloadProjectsFile(dbConn, &mu, config.ProjectsFile)
+	}
+
+	n, s := notifiers(), advisorySources()
+	logSources(n, s)
 	fmt.Println("Starting refresh loop")
-	go project.RefreshLoop(dbConn, &mu, config.FetchInterval, &manualRefresh, &req, &res)
+	go project.RefreshLoop(dbConn, &mu, config.FetchInterval, &manualRefresh, &req, &res, n, s)
+
+	fmt.Println("Starting expired session cleanup")
+	go users.PurgeSessionsLoop(dbConn, time.Hour)
 
 	wsHandler := ws.Handler{
-		DbConn:        dbConn,
-		Req:           &req,
-		Res:           &res,
-		ManualRefresh: &manualRefresh,
-		Mu:            &mu,
+		DbConn:          dbConn,
+		Req:             &req,
+		Res:             &res,
+		ManualRefresh:   &manualRefresh,
+		Mu:              &mu,
+		BaseURL:         config.Server.BaseURL,
+		Logins:          users.NewLoginLimiter(),
+		SessionLifetime: time.Duration(config.Server.SessionLifetime) * time.Hour,
+	}
+
+	for _, proxy := range config.Server.TrustedProxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			fmt.Println("Error parsing trusted proxy:", err)
+			os.Exit(1)
+		}
+		wsHandler.TrustedProxies = append(wsHandler.TrustedProxies, prefix)
+	}
+
+	if config.Server.BaseURL != "" {
+		var err error
+		wsHandler.WebAuthn, err = webAuthn(config.Server.BaseURL)
+		if err != nil {
+			fmt.Println("Error configuring passkeys:", err)
+			os.Exit(1)
+		}
+	} else {
+		fmt.Println("Passkeys are disabled because Server.BaseURL isn't set")
+	}
+
+	if config.SMTP.Host != "" {
+		fmt.Println("Sending password reset links through", config.SMTP.Host)
+		wsHandler.Email = &email.Sender{
+			Host:     config.SMTP.Host,
+			Port:     config.SMTP.Port,
+			Username: config.SMTP.Username,
+			Password: config.SMTP.Password,
+			From:     config.SMTP.From,
+		}
+	}
+
+	if config.OIDC.Issuer != "" {
+		fmt.Println("Allowing single sign-on through", config.OIDC.Issuer)
+		wsHandler.OIDC = &oidc.Provider{
+			Issuer:        config.OIDC.Issuer,
+			ClientID:      config.OIDC.ClientID,
+			ClientSecret:  config.OIDC.ClientSecret,
+			RedirectURL:   strings.TrimSuffix(config.Server.BaseURL, "/") + "/login/oidc/callback",
+			Scopes:        config.OIDC.Scopes,
+			UsernameClaim: config.OIDC.UsernameClaim,
+		}
+		wsHandler.OIDCDefaultRole = config.OIDC.DefaultRole
+	}
+
+	if config.ProxyAuth.Header != "" {
+		fmt.Println("Trusting the", config.ProxyAuth.Header, "header from", strings.Join(config.Server.TrustedProxies, ", "), "for logins")
+		wsHandler.ProxyAuthHeader = config.ProxyAuth.Header
+		wsHandler.ProxyAuthDefaultRole = config.ProxyAuth.DefaultRole
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/static/", ws.StaticHandler)
 	mux.HandleFunc("/new", wsHandler.NewHandler)
+	mux.HandleFunc("/import", wsHandler.ImportHandler)
+	mux.HandleFunc("/backup", wsHandler.BackupHandler)
+	mux.HandleFunc("/export", wsHandler.ExportHandler)
 	mux.HandleFunc("/login", wsHandler.LoginHandler)
 	mux.HandleFunc("/logout", wsHandler.LogoutHandler)
+	// The reverse proxy takes care of all of these when it handles logins
+	if config.ProxyAuth.Header == "" {
+		mux.HandleFunc("/login/oidc", wsHandler.OIDCLoginHandler)
+		mux.HandleFunc("/login/oidc/callback", wsHandler.OIDCCallbackHandler)
+		mux.HandleFunc("/login/totp", wsHandler.TOTPLoginHandler)
+		mux.HandleFunc("/account/totp", wsHandler.AccountTOTPHandler)
+		mux.HandleFunc("/login/passkey/begin", wsHandler.PasskeyLoginBeginHandler)
+		mux.HandleFunc("/login/passkey/finish", wsHandler.PasskeyLoginFinishHandler)
+		mux.HandleFunc("/account/passkeys", wsHandler.PasskeysHandler)
+		mux.HandleFunc("/account/passkeys/begin", wsHandler.PasskeyRegisterBeginHandler)
+		mux.HandleFunc("/account/passkeys/finish", wsHandler.PasskeyRegisterFinishHandler)
+		mux.HandleFunc("/login/forgot", wsHandler.ForgotPasswordHandler)
+		mux.HandleFunc("/login/reset", wsHandler.ResetPasswordHandler)
+		mux.HandleFunc("/account", wsHandler.AccountHandler)
+		mux.HandleFunc("/account/sessions", wsHandler.SessionsHandler)
+	}
+	mux.HandleFunc("/feeds", wsHandler.FeedsHandler)
+	mux.HandleFunc("/feeds/", wsHandler.FeedHandler)
+	mux.HandleFunc("/api", wsHandler.APITokenHandler)
+	mux.HandleFunc(api.Prefix+"/", wsHandler.APIHandler)
+	mux.HandleFunc("/admin/users", wsHandler.AdminUsersHandler)
+	mux.HandleFunc("/admin/audit", wsHandler.AdminAuditHandler)
+	mux.HandleFunc("/admin/audit.jsonl", wsHandler.AdminAuditHandler)
 	mux.HandleFunc("/", wsHandler.RootHandler)
 
 	httpServer := &http.Server{
 		Addr:    config.Server.Listen,
-		Handler: mux,
+		Handler: wsHandler.CSRF(mux),
 	}
 
 	fmt.Println("Starting web server on", config.Server.Listen)
@@ -129,16 +326,99 @@ func checkConfig() error {
 	defaultDBConn := "willow.sqlite"
 	defaultFetchInterval := 3600
 	defaultListen := "127.0.0.1:1313"
+	defaultSessionLifetime := int(users.DefaultSessionLifetime.Hours())
 
 	defaultConfig := fmt.Sprintf(`# Path to SQLite database
 DBConn = "%s"
 # How often to fetch new releases in seconds
 ## Minimum is %ds to avoid rate limits and unintentional abuse
 FetchInterval = %d
+# Optional TOML file of projects to track, applied on startup and on SIGHUP
+## Projects in it can't be changed from the web UI
+ProjectsFile = ""
 
 [Server]
 # Address to listen on
-Listen = "%s"`, defaultDBConn, defaultFetchInterval, defaultFetchInterval, defaultListen)
+Listen = "%s"
+# Public URL Willow is reachable at, used for links in feeds and passkeys
+## Guessed from each request if empty, but passkeys need it set
+BaseURL = ""
+# Reverse proxies whose X-Forwarded-For header is trusted for client IPs, which
+# are used to slow down password guessing
+TrustedProxies = ["127.0.0.1/8", "::1/128"]
+# Hours a session lasts without being used before the user has to log in again
+SessionLifetime = %d
+
+# Release announcements are sent to every backend that's configured
+[Notifications.Matrix]
+# Homeserver = "https://matrix.example.com"
+# AccessToken = ""
+# RoomID = "!room:example.com"
+
+[Notifications.XMPP]
+# JID = "willow@example.com"
+# Password = ""
+## Optional host:port, otherwise SRV records are used
+# Server = ""
+# Recipient = "releases@muc.example.com"
+# MUC = true
+# Nick = "Willow"
+
+# Security advisories in the OSV format are checked against the versions
+# everyone runs on each refresh
+[Advisories]
+## Directory with an offline OSV database: .json files or the all.zip files
+## from https://storage.googleapis.com/osv-vulnerabilities/index.html
+# Directory = ""
+## Query the OSV API with each project's repo URL and running versions
+# OSV = false
+# OSVURL = "https://api.osv.dev"
+## Fetch the security advisories published in the repos of GitHub projects
+# GitHub = false
+## Optional, but raises GitHub's rate limit
+# GitHubToken = ""
+
+# argon2id parameters for hashing passwords. Existing hashes keep working when
+# these change and are upgraded the next time their user logs in.
+[Passwords]
+# Time = %d
+## In KiB
+# Memory = %d
+# Threads = %d
+
+# Lets users who've set an email address reset their password; BaseURL must
+# be set
+[SMTP]
+# Host = "mail.example.com"
+## STARTTLS is used when the server offers it
+# Port = 587
+# Username = "willow@example.com"
+# Password = ""
+# From = "Willow <willow@example.com>"
+
+# Single sign-on through an OpenID Connect identity provider
+## Register Willow with the provider using BaseURL + /login/oidc/callback as
+## the redirect URI; BaseURL must be set and the issuer must use https
+[OIDC]
+# Issuer = "https://id.example.com"
+# ClientID = "willow"
+# ClientSecret = ""
+## Requested in addition to openid
+# Scopes = ["profile", "email"]
+## Names new accounts; defaults to preferred_username, then email, then sub.
+## Later logins find the account by sub, whatever this claim says then
+# UsernameClaim = ""
+## Role given to users on their first login: admin, editor, or viewer
+# DefaultRole = "viewer"
+
+# Let a reverse proxy such as Authelia or oauth2-proxy handle logins instead
+## The header is only believed on requests straight from TrustedProxies, so
+## make sure the proxy strips it from the requests it forwards
+[ProxyAuth]
+# Header = "Remote-User"
+## Role given to users on their first request: admin, editor, or viewer
+# DefaultRole = "viewer"`, defaultDBConn, defaultFetchInterval, defaultFetchInterval, defaultListen, defaultSessionLifetime,
+		users.DefaultHashParams.Time, users.DefaultHashParams.Memory, users.DefaultHashParams.Threads)
 
 	file, err := os.Open(*flagConfig)
 	if err != nil {
@@ -178,10 +458,143 @@ Listen = "%s"`, defaultDBConn, defaultFetchInterval, defaultFetchInterval, defau
 		config.Server.Listen = defaultListen
 	}
 
+	if config.Server.SessionLifetime <= 0 {
+		config.Server.SessionLifetime = defaultSessionLifetime
+	}
+
 	if config.DBConn == "" {
 		fmt.Println("No SQLite path specified, using \"" + defaultDBConn + "\"")
 		config.DBConn = defaultDBConn
 	}
 
+	if config.SMTP.Host != "" {
+		if config.SMTP.From == "" {
+			return errors.New("SMTP.From is required when SMTP.Host is set")
+		}
+		if config.Server.BaseURL == "" {
+			return errors.New("Server.BaseURL is required when SMTP.Host is set")
+		}
+		if config.SMTP.Port == 0 {
+			config.SMTP.Port = 587
+		}
+	}
+
+	if config.OIDC.Issuer != "" {
+		if config.OIDC.ClientID == "" {
+			return errors.New("OIDC.ClientID is required when OIDC.Issuer is set")
+		}
+		if config.Server.BaseURL == "" {
+			return errors.New("Server.BaseURL is required when OIDC.Issuer is set")
+		}
+		if config.OIDC.DefaultRole == "" {
+			config.OIDC.DefaultRole = users.RoleViewer
+		}
+		if !users.ValidRole(config.OIDC.DefaultRole) {
+			return fmt.Errorf("OIDC.DefaultRole must be %s, %s, or %s", users.RoleAdmin, users.RoleEditor, users.RoleViewer)
+		}
+	}
+
+	if config.ProxyAuth.Header != "" {
+		if config.OIDC.Issuer != "" {
+			return errors.New("OIDC and ProxyAuth can't both be enabled")
+		}
+		if len(config.Server.TrustedProxies) == 0 {
+			return errors.New("Server.TrustedProxies is required when ProxyAuth.Header is set")
+		}
+		if config.ProxyAuth.DefaultRole == "" {
+			config.ProxyAuth.DefaultRole = users.RoleViewer
+		}
+		if !users.ValidRole(config.ProxyAuth.DefaultRole) {
+			return fmt.Errorf("ProxyAuth.DefaultRole must be %s, %s, or %s", users.RoleAdmin, users.RoleEditor, users.RoleViewer)
+		}
+	}
+
 	return nil
 }
+
+// webAuthn returns the relying party for passkeys, which are bound to the
+// host Willow is served from
+func webAuthn(baseURL string) (*webauthn.WebAuthn, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("Server.BaseURL %q must include the scheme and host", baseURL)
+	}
+	return users.NewWebAuthn(u.Hostname(), u.Scheme+"://"+u.Host)
+}
+
+// notifiers returns a notifier for each backend that's configured
+func notifiers() []notify.Notifier {
+	var n []notify.Notifier
+
+	m := config.Notifications.Matrix
+	if m.Homeserver != "" && m.AccessToken != "" && m.RoomID != "" {
+		n = append(n, notify.Matrix{
+			Homeserver:  m.Homeserver,
+			AccessToken: m.AccessToken,
+			RoomID:      m.RoomID,
+		})
+	}
+
+	x := config.Notifications.XMPP
+	if x.JID != "" && x.Password != "" && x.Recipient != "" {
+		n = append(n, notify.XMPP{
+			JID:       x.JID,
+			Password:  x.Password,
+			Server:    x.Server,
+			Recipient: x.Recipient,
+			MUC:       x.MUC,
+			Nick:      x.Nick,
+		})
+	}
+
+	return n
+}
+
+// logSources prints where release announcements are sent and where advisories
+// come from, so whoever starts the server can see what's configured
+func logSources(n []notify.Notifier, s []advisory.Source) {
+	for _, notifier := range n {
+		switch notifier := notifier.(type) {
+		case notify.Matrix:
+			fmt.Println("Sending release announcements to Matrix room", notifier.RoomID)
+		case notify.XMPP:
+			fmt.Println("Sending release announcements to XMPP address", notifier.Recipient)
+		}
+	}
+	for _, source := range s {
+		switch source := source.(type) {
+		case advisory.Dir:
+			fmt.Println("Checking projects against advisories in", source.Path)
+		case advisory.API:
+			fmt.Println("Checking projects against advisories from", source.URL)
+		case advisory.GitHub:
+			fmt.Println("Fetching security advisories from GitHub repos")
+		}
+	}
+}
+
+// advisorySources returns each source of security advisories that's
+// configured
+func advisorySources() []advisory.Source {
+	var s []advisory.Source
+
+	a := config.Advisories
+	if a.Directory != "" {
+		s = append(s, advisory.Dir{Path: a.Directory})
+	}
+	if a.OSV {
+		api := advisory.API{URL: a.OSVURL}
+		if api.URL == "" {
+			api.URL = advisory.DefaultOSVAPI
+		}
+		s = append(s, api)
+	}
+	if a.GitHub {
+		s = append(s, advisory.GitHub{Token: a.GitHubToken})
+	}
+
+	return s
+}
@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package db
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// UpsertAdvisory adds or updates a project's security advisory in the database
+func UpsertAdvisory(db *sql.DB, mu *sync.Mutex, id, projectID, summary, severity, url, affected, modified string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	_, err := db.Exec(`INSERT INTO advisories (id, project_id, summary, severity, url, affected, modified)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id, project_id) DO
+			UPDATE SET
+				summary = excluded.summary,
+				severity = excluded.severity,
+				url = excluded.url,
+				affected = excluded.affected,
+				modified = excluded.modified;`, id, projectID, summary, severity, url, affected, modified)
+	return err
+}
+
+// DeleteAdvisory deletes a project's security advisory from the database
+func DeleteAdvisory(db *sql.DB, mu *sync.Mutex, id, projectID string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	_, err := db.Exec("DELETE FROM advisories WHERE id = ? AND project_id = ?", id, projectID)
+	return err
+}
+
+// GetAdvisories returns all security advisories for a project with a given ID
+// from the database
+func GetAdvisories(db *sql.DB, projectID string) ([]map[string]string, error) {
+	rows, err := db.Query(`SELECT id, summary, severity, url, affected, modified FROM advisories WHERE project_id = ? ORDER BY id`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	advisories := make([]map[string]string, 0)
+	for rows.Next() {
+		var id, summary, severity, url, affected, modified string
+		err := rows.Scan(&id, &summary, &severity, &url, &affected, &modified)
+		if err != nil {
+			return nil, err
+		}
+		advisories = append(advisories, map[string]string{
+			"id":         id,
+			"project_id": projectID,
+			"summary":    summary,
+			"severity":   severity,
+			"url":        url,
+			"affected":   affected,
+			"modified":   modified,
+		})
+	}
+	return advisories, rows.Err()
+}
+
+// GetProjectVersions returns every version of a project that a user or one of
+// its deployments runs
+func GetProjectVersions(db *sql.DB, projectID string) ([]string, error) {
+	rows, err := db.Query(`SELECT version FROM user_projects WHERE project_id = ?
+		UNION
+		SELECT version FROM deployments WHERE project_id = ?`, projectID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]string, 0)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package db
+
+import "database/sql"
+
+// GetAPIToken returns when a user's API token was created and last used
+func GetAPIToken(db *sql.DB, username string) (map[string]string, error) {
+	var createdAt, lastUsed string
+	err := db.QueryRow("SELECT created_at, COALESCE(last_used, '') FROM api_tokens WHERE username = ?", username).Scan(&createdAt, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"created_at": createdAt,
+		"last_used":  lastUsed,
+	}, nil
+}
+
+// GetAPITokenUser returns the username associated with the hash of an API
+// token
+func GetAPITokenUser(db *sql.DB, tokenHash string) (string, error) {
+	var username string
+	err := db.QueryRow("SELECT username FROM api_tokens WHERE token_hash = ?", tokenHash).Scan(&username)
+	return username, err
+}
+
+// UpsertAPIToken sets the hash of a user's API token, replacing any existing
+// one
+func UpsertAPIToken(db *sql.DB, username, tokenHash string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec(`INSERT INTO api_tokens (username, token_hash)
+		VALUES (?, ?)
+		ON CONFLICT(username) DO
+			UPDATE SET
+				token_hash = excluded.token_hash,
+				created_at = CURRENT_TIMESTAMP,
+				last_used = NULL;`, username, tokenHash)
+	return err
+}
+
+// TouchAPIToken records that an API token was just used
+func TouchAPIToken(db *sql.DB, tokenHash string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("UPDATE api_tokens SET last_used = CURRENT_TIMESTAMP WHERE token_hash = ?", tokenHash)
+	return err
+}
+
+// DeleteAPIToken deletes a user's API token
+func DeleteAPIToken(db *sql.DB, username string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("DELETE FROM api_tokens WHERE username = ?", username)
+	return err
+}
@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package db
+
+import (
+	"database/sql"
+	"strconv"
+)
+
+// CreateAuditEntry records that actor did something to target
+func CreateAuditEntry(db *sql.DB, actor, action, target, details string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("INSERT INTO audit_log (actor, action, target, details) VALUES (?, ?, ?, ?)", actor, action, target, details)
+	return err
+}
+
+// GetAuditEntries returns audit log entries, newest first. A limit of zero
+// returns all of them.
+func GetAuditEntries(db *sql.DB, limit int) ([]map[string]string, error) {
+	query := "SELECT id, actor, action, target, details, created_at FROM audit_log ORDER BY id DESC"
+	if limit > 0 {
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []map[string]string
+	for rows.Next() {
+		var id, actor, action, target, details, createdAt string
+		err = rows.Scan(&id, &actor, &action, &target, &details, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, map[string]string{
+			"id":         id,
+			"actor":      actor,
+			"action":     action,
+			"target":     target,
+			"details":    details,
+			"created_at": createdAt,
+		})
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package db
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// UpsertDeployment adds or updates a deployment of a project in the database
+func UpsertDeployment(db *sql.DB, mu *sync.Mutex, id, projectID, name, version, notes, owner string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	_, err := db.Exec(`INSERT INTO deployments (id, project_id, name, version, notes, owner)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO
+			UPDATE SET
+				name = excluded.name,
+				version = excluded.version,
+				notes = excluded.notes,
+				owner = excluded.owner;`, id, projectID, name, version, notes, owner)
+	return err
+}
+
+// DeleteDeployment deletes a deployment from the database
+func DeleteDeployment(db *sql.DB, mu *sync.Mutex, id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	_, err := db.Exec("DELETE FROM deployments WHERE id = ?", id)
+	return err
+}
+
+// GetDeployment returns a deployment from the database
+func GetDeployment(db *sql.DB, id string) (map[string]string, error) {
+	var projectID, name, version, notes, owner string
+	err := db.QueryRow("SELECT project_id, name, version, notes, owner FROM deployments WHERE id = ?", id).Scan(&projectID, &name, &version, &notes, &owner)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"id":         id,
+		"project_id": projectID,
+		"name":       name,
+		"version":    version,
+		"notes":      notes,
+		"owner":      owner,
+	}, nil
+}
+
+// GetDeployments returns all deployments of a project with a given ID from the
+// database
+func GetDeployments(db *sql.DB, projectID string) ([]map[string]string, error) {
+	rows, err := db.Query(`SELECT id, name, version, notes, owner FROM deployments WHERE project_id = ? ORDER BY created_at`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deployments := make([]map[string]string, 0)
+	for rows.Next() {
+		var id, name, version, notes, owner string
+		err := rows.Scan(&id, &name, &version, &notes, &owner)
+		if err != nil {
+			return nil, err
+		}
+		deployments = append(deployments, map[string]string{
+			"id":         id,
+			"project_id": projectID,
+			"name":       name,
+			"version":    version,
+			"notes":      notes,
+			"owner":      owner,
+		})
+	}
+	return deployments, nil
+}
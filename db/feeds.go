@@ -0,0 +1,36 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package db
+
+import "database/sql"
+
+// GetFeedToken returns when a user's feed token was created
+func GetFeedToken(db *sql.DB, username string) (string, error) {
+	var createdAt string
+	err := db.QueryRow("SELECT created_at FROM feed_tokens WHERE username = ?", username).Scan(&createdAt)
+	return createdAt, err
+}
+
+// GetFeedTokenUser returns the username associated with the hash of a feed
+// token
+func GetFeedTokenUser(db *sql.DB, tokenHash string) (string, error) {
+	var username string
+	err := db.QueryRow("SELECT username FROM feed_tokens WHERE token_hash = ?", tokenHash).Scan(&username)
+	return username, err
+}
+
+// UpsertFeedToken sets the hash of a user's feed token, replacing any existing
+// one
+func UpsertFeedToken(db *sql.DB, username, tokenHash string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec(`INSERT INTO feed_tokens (username, token_hash)
+		VALUES (?, ?)
+		ON CONFLICT(username) DO
+			UPDATE SET
+				token_hash = excluded.token_hash,
+				created_at = CURRENT_TIMESTAMP;`, username, tokenHash)
+	return err
+}
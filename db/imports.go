@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package db
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// UpsertImport records that a dependency in an imported document made the
+// user track a project
+func UpsertImport(db *sql.DB, mu *sync.Mutex, username, source, dependency, projectID string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	_, err := db.Exec(`INSERT INTO imports (username, source, dependency, project_id)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(username, source, dependency) DO
+			UPDATE SET
+				project_id = excluded.project_id;`, username, source, dependency, projectID)
+	return err
+}
+
+// DeleteImport forgets a dependency of an imported document
+func DeleteImport(db *sql.DB, mu *sync.Mutex, username, source, dependency string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	_, err := db.Exec("DELETE FROM imports WHERE username = ? AND source = ? AND dependency = ?", username, source, dependency)
+	return err
+}
+
+// GetImports returns the dependencies recorded the last time the user
+// imported a document, along with the projects they point at
+func GetImports(db *sql.DB, username, source string) ([]map[string]string, error) {
+	rows, err := db.Query(`SELECT dependency, project_id FROM imports WHERE username = ? AND source = ? ORDER BY dependency`, username, source)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	imports := make([]map[string]string, 0)
+	for rows.Next() {
+		var dependency, projectID string
+		if err := rows.Scan(&dependency, &projectID); err != nil {
+			return nil, err
+		}
+		imports = append(imports, map[string]string{
+			"dependency": dependency,
+			"project_id": projectID,
+		})
+	}
+	return imports, rows.Err()
+}
+
+// CountProjectImports returns how many dependencies across all of the user's
+// imported documents point at a project
+func CountProjectImports(db *sql.DB, username, projectID string) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM imports WHERE username = ? AND project_id = ?", username, projectID).Scan(&count)
+	return count, err
+}
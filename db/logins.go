@@ -0,0 +1,15 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package db
+
+import "database/sql"
+
+// CreateFailedLogin records a failed login attempt
+func CreateFailedLogin(db *sql.DB, username, ip, reason string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("INSERT INTO failed_logins (username, ip, reason) VALUES (?, ?, ?)", username, ip, reason)
+	return err
+}
@@ -8,6 +8,7 @@ import (
 	"context"
 	"database/sql"
 	_ "embed"
+	"errors"
 	"fmt"
 )
 
@@ -26,6 +27,74 @@ var (
 	migration2Up string
 	//go:embed sql/2_swap_project_url_for_id.down.sql
 	migration2Down string
+	//go:embed sql/4_add_feed_tokens.up.sql
+	migration4Up string
+	//go:embed sql/4_add_feed_tokens.down.sql
+	migration4Down string
+	//go:embed sql/5_add_user_projects.up.sql
+	migration5Up string
+	//go:embed sql/5_add_user_projects.down.sql
+	migration5Down string
+	//go:embed sql/6_add_deployments.up.sql
+	migration6Up string
+	//go:embed sql/6_add_deployments.down.sql
+	migration6Down string
+	//go:embed sql/7_add_user_roles.up.sql
+	migration7Up string
+	//go:embed sql/7_add_user_roles.down.sql
+	migration7Down string
+	//go:embed sql/8_add_totp.up.sql
+	migration8Up string
+	//go:embed sql/8_add_totp.down.sql
+	migration8Down string
+	//go:embed sql/9_add_webauthn.up.sql
+	migration9Up string
+	//go:embed sql/9_add_webauthn.down.sql
+	migration9Down string
+	//go:embed sql/10_add_failed_logins.up.sql
+	migration10Up string
+	//go:embed sql/10_add_failed_logins.down.sql
+	migration10Down string
+	//go:embed sql/11_add_session_csrf.up.sql
+	migration11Up string
+	//go:embed sql/11_add_session_csrf.down.sql
+	migration11Down string
+	//go:embed sql/12_add_session_details.up.sql
+	migration12Up string
+	//go:embed sql/12_add_session_details.down.sql
+	migration12Down string
+	//go:embed sql/13_add_password_resets.up.sql
+	migration13Up string
+	//go:embed sql/13_add_password_resets.down.sql
+	migration13Down string
+	//go:embed sql/14_add_audit_log.up.sql
+	migration14Up string
+	//go:embed sql/14_add_audit_log.down.sql
+	migration14Down string
+	//go:embed sql/15_add_advisories.up.sql
+	migration15Up string
+	//go:embed sql/15_add_advisories.down.sql
+	migration15Down string
+	//go:embed sql/16_add_imports.up.sql
+	migration16Up string
+	//go:embed sql/16_add_imports.down.sql
+	migration16Down string
+	//go:embed sql/17_add_managed_projects.up.sql
+	migration17Up string
+	//go:embed sql/17_add_managed_projects.down.sql
+	migration17Down string
+	//go:embed sql/18_add_api_tokens.up.sql
+	migration18Up string
+	//go:embed sql/18_add_api_tokens.down.sql
+	migration18Down string
+	//go:embed sql/19_add_oidc_identities.up.sql
+	migration19Up string
+	//go:embed sql/19_add_oidc_identities.down.sql
+	migration19Down string
+	//go:embed sql/20_hash_feed_tokens.up.sql
+	migration20Up string
+	//go:embed sql/20_hash_feed_tokens.down.sql
+	migration20Down string
 )
 
 var migrations = [...]migration{
@@ -46,12 +115,121 @@ var migrations = [...]migration{
 	3: {
 		postHook: correctProjectIDs,
 	},
+	4: {
+		upQuery:   migration4Up,
+		downQuery: migration4Down,
+	},
+	5: {
+		upQuery:   migration5Up,
+		downQuery: migration5Down,
+	},
+	6: {
+		upQuery:   migration6Up,
+		downQuery: migration6Down,
+	},
+	7: {
+		upQuery:   migration7Up,
+		downQuery: migration7Down,
+	},
+	8: {
+		upQuery:   migration8Up,
+		downQuery: migration8Down,
+	},
+	9: {
+		upQuery:   migration9Up,
+		downQuery: migration9Down,
+	},
+	10: {
+		upQuery:   migration10Up,
+		downQuery: migration10Down,
+	},
+	11: {
+		upQuery:   migration11Up,
+		downQuery: migration11Down,
+	},
+	12: {
+		upQuery:   migration12Up,
+		downQuery: migration12Down,
+	},
+	13: {
+		upQuery:   migration13Up,
+		downQuery: migration13Down,
+	},
+	14: {
+		upQuery:   migration14Up,
+		downQuery: migration14Down,
+	},
+	15: {
+		upQuery:   migration15Up,
+		downQuery: migration15Down,
+	},
+	16: {
+		upQuery:   migration16Up,
+		downQuery: migration16Down,
+	},
+	17: {
+		upQuery:   migration17Up,
+		downQuery: migration17Down,
+	},
+	18: {
+		upQuery:   migration18Up,
+		downQuery: migration18Down,
+	},
+	19: {
+		upQuery:   migration19Up,
+		downQuery: migration19Down,
+	},
+	20: {
+		upQuery:   migration20Up,
+		downQuery: migration20Down,
+		postHook:  hashFeedTokens,
+	},
+}
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer
+// version of Willow than this one
+var ErrSchemaTooNew = errors.New("database schema is newer than this version of Willow understands")
+
+// LatestVersion returns the schema version this version of Willow migrates
+// databases to
+func LatestVersion() int {
+	return len(migrations) - 1
+}
+
+// SchemaStatus returns the database's schema version, or -1 if it hasn't been
+// migrated at all yet, and whether it's marked dirty. Migrations run in
+// transactions, so a dirty schema is still at the version it claims; it only
+// means the last migration or rollback failed and was undone.
+func SchemaStatus(db *sql.DB) (int, bool) {
+	row := db.QueryRowContext(context.Background(), `SELECT version, dirty FROM schema_migrations LIMIT 1;`)
+	var (
+		version int
+		dirty   bool
+	)
+	if err := row.Scan(&version, &dirty); err != nil {
+		return -1, false
+	}
+	return version, dirty
 }
 
 // Migrate runs all pending migrations
 func Migrate(db *sql.DB) error {
+	return MigrateTo(db, LatestVersion())
+}
+
+// MigrateTo applies or undoes migrations one at a time until the schema is at
+// the target version. Each migration runs in its own transaction, so when one
+// fails, the schema is left at the version before it.
+func MigrateTo(db *sql.DB, target int) error {
 	version := getSchemaVersion(db)
-	for nextMigration := version + 1; nextMigration < len(migrations); nextMigration++ {
+	if version > LatestVersion() {
+		return fmt.Errorf("%w: it's at version %d, but this version only goes up to %d", ErrSchemaTooNew, version, LatestVersion())
+	}
+	if target < 0 || target > LatestVersion() {
+		return fmt.Errorf("there's no schema version %d, only 0 to %d", target, LatestVersion())
+	}
+
+	for nextMigration := version + 1; nextMigration <= target; nextMigration++ {
 		if err := runMigration(db, nextMigration); err != nil {
 			return fmt.Errorf("migrations failed: %w", err)
 		}
@@ -59,6 +237,14 @@ func Migrate(db *sql.DB) error {
 			return fmt.Errorf("migration did not update version (expected %d, got %d)", nextMigration, version)
 		}
 	}
+	for lastMigration := version; lastMigration > target; lastMigration-- {
+		if err := undoMigration(db, lastMigration); err != nil {
+			return fmt.Errorf("rolling back failed: %w", err)
+		}
+		if version := getSchemaVersion(db); version != lastMigration-1 {
+			return fmt.Errorf("rolling back did not update version (expected %d, got %d)", lastMigration-1, version)
+		}
+	}
 	return nil
 }
 
@@ -67,6 +253,12 @@ func Migrate(db *sql.DB) error {
 // transaction if unsuccessful.
 func runMigration(db *sql.DB, migrationIdx int) (err error) {
 	current := migrations[migrationIdx]
+	// The first migration creates the table the flag is kept in
+	if migrationIdx > 0 {
+		if err := markDirty(db); err != nil {
+			return fmt.Errorf("failed marking schema dirty for migration %d: %w", migrationIdx, err)
+		}
+	}
 	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{})
 	if err != nil {
 		return fmt.Errorf("failed opening transaction for migration %d: %w", migrationIdx, err)
@@ -97,10 +289,11 @@ func runMigration(db *sql.DB, migrationIdx int) (err error) {
 // undoMigration rolls the single most recent migration back inside a
 // transaction, updates the schema version and commits the transaction if
 // successful, and rolls back the transaction if unsuccessful.
-//
-//lint:ignore U1000 Will be used when #34 is implemented (https://todo.sr.ht/~amolith/willow/34)
 func undoMigration(db *sql.DB, migrationIdx int) (err error) {
 	current := migrations[migrationIdx]
+	if err := markDirty(db); err != nil {
+		return fmt.Errorf("failed marking schema dirty for undoing migration %d: %w", migrationIdx, err)
+	}
 	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{})
 	if err != nil {
 		return fmt.Errorf("failed opening undo transaction for migration %d: %w", migrationIdx, err)
@@ -133,12 +326,21 @@ func getSchemaVersion(db *sql.DB) int {
 	return version
 }
 
-// updateSchemaVersion sets the version to the provided int
+// markDirty flags the schema as being migrated until updateSchemaVersion
+// clears the flag in the same transaction as the migration, so it stays set
+// when the migration fails
+func markDirty(db *sql.DB) error {
+	_, err := db.ExecContext(context.Background(), `UPDATE schema_migrations SET dirty = 1;`)
+	return err
+}
+
+// updateSchemaVersion sets the version to the provided int and clears the
+// dirty flag
 func updateSchemaVersion(tx *sql.Tx, version int) error {
 	if version < 0 {
 		// Do not try to use the schema_migrations table in a schema version where it doesn't exist
 		return nil
 	}
-	_, err := tx.Exec(`UPDATE schema_migrations SET version = @version;`, sql.Named("version", version))
+	_, err := tx.Exec(`UPDATE schema_migrations SET version = @version, dirty = 0;`, sql.Named("version", version))
 	return err
 }
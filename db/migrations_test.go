@@ -0,0 +1,168 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package db
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateTo(t *testing.T) {
+	dbConn, err := Open(filepath.Join(t.TempDir(), "willow.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbConn.Close()
+	if err := InitialiseDatabase(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := SchemaStatus(dbConn); version != -1 {
+		t.Errorf("new database is at version %d, want -1", version)
+	}
+
+	if err := Migrate(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	if version, dirty := SchemaStatus(dbConn); version != LatestVersion() || dirty {
+		t.Errorf("SchemaStatus() = %d, %t; want %d, false", version, dirty, LatestVersion())
+	}
+
+	// Every migration can be rolled back and applied again
+	if err := MigrateTo(dbConn, 0); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := SchemaStatus(dbConn); version != 0 {
+		t.Errorf("rolled back to version %d, want 0", version)
+	}
+	if err := MigrateTo(dbConn, LatestVersion()); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := SchemaStatus(dbConn); version != LatestVersion() {
+		t.Errorf("migrated to version %d, want %d", version, LatestVersion())
+	}
+
+	// A failed migration is rolled back and leaves the schema marked dirty
+	// until one succeeds
+	if err := MigrateTo(dbConn, LatestVersion()-1); err != nil {
+		t.Fatal(err)
+	}
+	upQuery := migrations[LatestVersion()].upQuery
+	migrations[LatestVersion()].upQuery = "NOT SQL;"
+	err = MigrateTo(dbConn, LatestVersion())
+	migrations[LatestVersion()].upQuery = upQuery
+	if err == nil {
+		t.Fatal("MigrateTo() succeeded with a broken migration")
+	}
+	if version, dirty := SchemaStatus(dbConn); version != LatestVersion()-1 || !dirty {
+		t.Errorf("SchemaStatus() after a failed migration = %d, %t; want %d, true", version, dirty, LatestVersion()-1)
+	}
+	if err := Migrate(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	if version, dirty := SchemaStatus(dbConn); version != LatestVersion() || dirty {
+		t.Errorf("SchemaStatus() after retrying = %d, %t; want %d, false", version, dirty, LatestVersion())
+	}
+
+	if err := MigrateTo(dbConn, LatestVersion()+1); err == nil {
+		t.Error("MigrateTo() accepted a version with no migration")
+	}
+
+	if _, err := dbConn.Exec(`UPDATE schema_migrations SET version = ?`, LatestVersion()+1); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(dbConn); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Migrate() on a newer schema = %v, want ErrSchemaTooNew", err)
+	}
+}
+
+func TestMigrationsKeepData(t *testing.T) {
+	dbConn, err := Open(filepath.Join(t.TempDir(), "willow.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbConn.Close()
+	if err := InitialiseDatabase(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateTo(dbConn, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = dbConn.Exec(`INSERT INTO users (username, hash, salt) VALUES ('alice', 'hash', 'salt');
+		INSERT INTO projects (id, url, name, forge, version) VALUES ('old-id', 'https://git.sr.ht/~amolith/willow', 'Willow', 'sourcehut', 'v0.0.1');
+		INSERT INTO releases (id, project_url, release_url, tag, content, date)
+		VALUES ('release', 'https://git.sr.ht/~amolith/willow', 'https://git.sr.ht/~amolith/willow/refs/v0.0.2', 'v0.0.2', 'Notes', '2024-01-01T00:00:00Z');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// checkLatest makes sure the project, its release, and alice's running
+	// version are all still connected at the latest schema
+	checkLatest := func(when string) {
+		t.Helper()
+		var tag, version string
+		err := dbConn.QueryRow(`SELECT r.tag, up.version FROM releases r
+			JOIN projects p ON r.project_id = p.id
+			JOIN user_projects up ON up.project_id = p.id AND up.username = 'alice'`).Scan(&tag, &version)
+		if err != nil {
+			t.Fatalf("%s: finding the seeded rows: %v", when, err)
+		}
+		if tag != "v0.0.2" || version != "v0.0.1" {
+			t.Errorf("%s: got release %q and running version %q, want v0.0.2 and v0.0.1", when, tag, version)
+		}
+	}
+
+	if err := Migrate(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	checkLatest("after migrating up")
+
+	if err := MigrateTo(dbConn, 0); err != nil {
+		t.Fatal(err)
+	}
+	var tag, version string
+	err = dbConn.QueryRow(`SELECT r.tag, p.version FROM releases r
+		JOIN projects p ON r.project_url = p.url`).Scan(&tag, &version)
+	if err != nil {
+		t.Fatalf("after rolling back: finding the seeded rows: %v", err)
+	}
+	if tag != "v0.0.2" || version != "v0.0.1" {
+		t.Errorf("after rolling back: got release %q and running version %q, want v0.0.2 and v0.0.1", tag, version)
+	}
+
+	if err := Migrate(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	checkLatest("after migrating up again")
+}
+
+func TestHashFeedTokens(t *testing.T) {
+	dbConn, err := Open(filepath.Join(t.TempDir(), "willow.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbConn.Close()
+	if err := InitialiseDatabase(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateTo(dbConn, 19); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbConn.Exec(`INSERT INTO feed_tokens (username, token) VALUES ('alice', 'secret')`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Feed readers already subscribed keep working because only the
+	// token's hash changes
+	if err := MigrateTo(dbConn, 20); err != nil {
+		t.Fatal(err)
+	}
+	if username, err := GetFeedTokenUser(dbConn, fmt.Sprintf("%x", sha256.Sum256([]byte("secret")))); err != nil || username != "alice" {
+		t.Errorf("GetFeedTokenUser() after hashing = %q, %v; want alice", username, err)
+	}
+}
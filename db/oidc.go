@@ -0,0 +1,31 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package db
+
+import "database/sql"
+
+// GetOIDCIdentityUser returns the username of the account an identity
+// provider's subject is tied to
+func GetOIDCIdentityUser(db *sql.DB, issuer, subject string) (string, error) {
+	var username string
+	err := db.QueryRow("SELECT username FROM oidc_identities WHERE issuer = ? AND subject = ?", issuer, subject).Scan(&username)
+	return username, err
+}
+
+// HasOIDCIdentity reports whether an account is tied to any identity
+// provider's subject
+func HasOIDCIdentity(db *sql.DB, username string) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM oidc_identities WHERE username = ?", username).Scan(&n)
+	return n > 0, err
+}
+
+// InsertOIDCIdentity ties an account to an identity provider's subject
+func InsertOIDCIdentity(db *sql.DB, issuer, subject, username string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("INSERT INTO oidc_identities (issuer, subject, username) VALUES (?, ?, ?)", issuer, subject, username)
+	return err
+}
@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// CreatePasswordReset stores the hash of a password reset token, replacing
+// any earlier ones for the same user
+func CreatePasswordReset(db *sql.DB, tokenHash, username string, expiry time.Time) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM password_resets WHERE username = ?", username); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO password_resets (token, username, expires) VALUES (?, ?, ?)", tokenHash, username, expiry.Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetPasswordReset returns the username and expiry of a password reset token
+func GetPasswordReset(db *sql.DB, tokenHash string) (string, time.Time, error) {
+	var username, expiresString string
+	err := db.QueryRow("SELECT username, expires FROM password_resets WHERE token = ?", tokenHash).Scan(&username, &expiresString)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires, err := time.Parse(time.RFC3339, expiresString)
+	return username, expires, err
+}
+
+// DeleteUserPasswordResets deletes all of a user's password reset tokens
+func DeleteUserPasswordResets(db *sql.DB, username string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("DELETE FROM password_resets WHERE username = ?", username)
+	return err
+}
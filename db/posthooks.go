@@ -83,7 +83,49 @@ func correctProjectIDs(tx *sql.Tx) error {
 		if err != nil {
 			return fmt.Errorf("failed to insert project into projects: %w", err)
 		}
+		_, err = tx.Exec(
+			"UPDATE releases SET project_id = @id WHERE project_id = @old_id",
+			sql.Named("id", id),
+			sql.Named("old_id", old_id),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update releases for project: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// hashFeedTokens runs during migration 20 and replaces each feed token with its
+// SHA-256 hash, which is what feed requests are now checked against.
+func hashFeedTokens(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT username, token_hash FROM feed_tokens")
+	if err != nil {
+		return fmt.Errorf("failed to list feed tokens: %w", err)
+	}
+	tokens := make(map[string]string)
+	for rows.Next() {
+		var username, token string
+		if err := rows.Scan(&username, &token); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan row from feed_tokens: %w", err)
+		}
+		tokens[username] = token
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list feed tokens: %w", err)
 	}
 
+	for username, token := range tokens {
+		_, err := tx.Exec(
+			"UPDATE feed_tokens SET token_hash = @hash WHERE username = @username",
+			sql.Named("hash", fmt.Sprintf("%x", sha256.Sum256([]byte(token)))),
+			sql.Named("username", username),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to hash feed token: %w", err)
+		}
+	}
 	return nil
 }
@@ -6,10 +6,12 @@ package db
 
 import (
 	"database/sql"
+	"strconv"
 	"sync"
 )
 
-// DeleteProject deletes a project from the database
+// DeleteProject deletes a project, its releases, deployments, and advisories,
+// and every user's tracking and imports of it from the database
 func DeleteProject(db *sql.DB, mu *sync.Mutex, id string) error {
 	mu.Lock()
 	defer mu.Unlock()
@@ -17,14 +19,71 @@ func DeleteProject(db *sql.DB, mu *sync.Mutex, id string) error {
 	if err != nil {
 		return err
 	}
+	_, err = db.Exec("DELETE FROM user_projects WHERE project_id = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM deployments WHERE project_id = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM advisories WHERE project_id = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM imports WHERE project_id = ?", id)
+	if err != nil {
+		return err
+	}
 	_, err = db.Exec("DELETE FROM releases WHERE project_id = ?", id)
 	return err
 }
 
+// DeleteUserProject stops a user tracking a project and forgets which
+// imports it came from
+func DeleteUserProject(db *sql.DB, mu *sync.Mutex, username, id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	_, err := db.Exec("DELETE FROM user_projects WHERE username = ? AND project_id = ?", username, id)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM imports WHERE username = ? AND project_id = ?", username, id)
+	return err
+}
+
+// CountProjectUsers returns the number of users tracking a project
+func CountProjectUsers(db *sql.DB, id string) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM user_projects WHERE project_id = ?", id).Scan(&count)
+	return count, err
+}
+
 // GetProject returns a project from the database
 func GetProject(db *sql.DB, id string) (map[string]string, error) {
+	var name, forge, url string
+	err := db.QueryRow("SELECT name, forge, url FROM projects WHERE id = ?", id).Scan(&name, &forge, &url)
+	if err != nil {
+		return nil, err
+	}
+	project := map[string]string{
+		"id":    id,
+		"name":  name,
+		"url":   url,
+		"forge": forge,
+	}
+	return project, nil
+}
+
+// GetUserProject returns a project from the database along with the version
+// the user is running
+func GetUserProject(db *sql.DB, username, id string) (map[string]string, error) {
 	var name, forge, url, version string
-	err := db.QueryRow("SELECT name, forge, url, version FROM projects WHERE id = ?", id).Scan(&name, &forge, &url, &version)
+	var managed bool
+	err := db.QueryRow(`SELECT p.name, p.forge, p.url, up.version, up.managed
+		FROM projects p
+		JOIN user_projects up ON up.project_id = p.id
+		WHERE up.username = ? AND p.id = ?`, username, id).Scan(&name, &forge, &url, &version, &managed)
 	if err != nil {
 		return nil, err
 	}
@@ -34,27 +93,71 @@ func GetProject(db *sql.DB, id string) (map[string]string, error) {
 		"url":     url,
 		"forge":   forge,
 		"version": version,
+		"managed": strconv.FormatBool(managed),
 	}
 	return project, nil
 }
 
 // UpsertProject adds or updates a project in the database
-func UpsertProject(db *sql.DB, mu *sync.Mutex, id, url, name, forge, running string) error {
+func UpsertProject(db *sql.DB, mu *sync.Mutex, id, url, name, forge string) error {
 	mu.Lock()
 	defer mu.Unlock()
-	_, err := db.Exec(`INSERT INTO projects (id, url, name, forge, version)
-		VALUES (?, ?, ?, ?, ?)
-		ON CONFLICT(id) DO 
+	_, err := db.Exec(`INSERT INTO projects (id, url, name, forge)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(id) DO
 			UPDATE SET
 				name = excluded.name,
-				forge = excluded.forge,
-				version = excluded.version;`, id, url, name, forge, running)
+				forge = excluded.forge;`, id, url, name, forge)
+	return err
+}
+
+// UpsertUserProject adds a project to a user's list or updates the version
+// they're running
+func UpsertUserProject(db *sql.DB, mu *sync.Mutex, username, id, running string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	_, err := db.Exec(`INSERT INTO user_projects (username, project_id, version)
+		VALUES (?, ?, ?)
+		ON CONFLICT(username, project_id) DO
+			UPDATE SET
+				version = excluded.version;`, username, id, running)
 	return err
 }
 
-// GetProjects returns a list of all projects in the database
+// GetProjects returns a list of all projects tracked by at least one user
 func GetProjects(db *sql.DB) ([]map[string]string, error) {
-	rows, err := db.Query("SELECT id, name, url, forge, version FROM projects")
+	rows, err := db.Query(`SELECT id, name, url, forge FROM projects
+		WHERE id IN (SELECT project_id FROM user_projects)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []map[string]string
+	for rows.Next() {
+		var id, name, url, forge string
+		err = rows.Scan(&id, &name, &url, &forge)
+		if err != nil {
+			return nil, err
+		}
+		project := map[string]string{
+			"id":    id,
+			"name":  name,
+			"url":   url,
+			"forge": forge,
+		}
+		projects = append(projects, project)
+	}
+	return projects, nil
+}
+
+// GetUserProjects returns a list of all projects a user tracks along with the
+// versions they're running
+func GetUserProjects(db *sql.DB, username string) ([]map[string]string, error) {
+	rows, err := db.Query(`SELECT p.id, p.name, p.url, p.forge, up.version, up.managed
+		FROM projects p
+		JOIN user_projects up ON up.project_id = p.id
+		WHERE up.username = ?`, username)
 	if err != nil {
 		return nil, err
 	}
@@ -63,7 +166,8 @@ func GetProjects(db *sql.DB) ([]map[string]string, error) {
 	var projects []map[string]string
 	for rows.Next() {
 		var id, name, url, forge, version string
-		err = rows.Scan(&id, &name, &url, &forge, &version)
+		var managed bool
+		err = rows.Scan(&id, &name, &url, &forge, &version, &managed)
 		if err != nil {
 			return nil, err
 		}
@@ -73,8 +177,46 @@ func GetProjects(db *sql.DB) ([]map[string]string, error) {
 			"url":     url,
 			"forge":   forge,
 			"version": version,
+			"managed": strconv.FormatBool(managed),
 		}
 		projects = append(projects, project)
 	}
 	return projects, nil
 }
+
+// SetManaged marks whether the user's project is managed by the projects file
+func SetManaged(db *sql.DB, mu *sync.Mutex, username, id string, managed bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+	_, err := db.Exec("UPDATE user_projects SET managed = ? WHERE username = ? AND project_id = ?", managed, username, id)
+	return err
+}
+
+// GetManagedProjects returns every user's projects that are managed by the
+// projects file along with the versions they're running
+func GetManagedProjects(db *sql.DB) ([]map[string]string, error) {
+	rows, err := db.Query(`SELECT up.username, p.id, p.name, up.version
+		FROM projects p
+		JOIN user_projects up ON up.project_id = p.id
+		WHERE up.managed = 1
+		ORDER BY up.username, p.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []map[string]string
+	for rows.Next() {
+		var username, id, name, version string
+		if err := rows.Scan(&username, &id, &name, &version); err != nil {
+			return nil, err
+		}
+		projects = append(projects, map[string]string{
+			"username": username,
+			"id":       id,
+			"name":     name,
+			"version":  version,
+		})
+	}
+	return projects, rows.Err()
+}
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// GetUserSessions returns all of a user's sessions, most recently used first
+func GetUserSessions(db *sql.DB, username string) ([]map[string]string, error) {
+	rows, err := db.Query(`SELECT token, ip, user_agent, COALESCE(last_seen, ''), expires, created_at
+		FROM sessions
+		WHERE username = ?
+		ORDER BY last_seen DESC`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []map[string]string
+	for rows.Next() {
+		var token, ip, userAgent, lastSeen, expires, createdAt string
+		err = rows.Scan(&token, &ip, &userAgent, &lastSeen, &expires, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, map[string]string{
+			"token":      token,
+			"ip":         ip,
+			"user_agent": userAgent,
+			"last_seen":  lastSeen,
+			"expires":    expires,
+			"created_at": createdAt,
+		})
+	}
+	return sessions, rows.Err()
+}
+
+// TouchSession records that a session was just used and pushes its expiry
+// back. It does nothing if the session was already seen since staleBefore, so
+// it doesn't write to the database on every request.
+func TouchSession(db *sql.DB, session, ip, userAgent string, staleBefore, expiry time.Time) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := db.Exec(`UPDATE sessions SET ip = ?, user_agent = ?, last_seen = ?, expires = ?
+		WHERE token = ? AND (last_seen IS NULL OR last_seen < ?)`,
+		ip, userAgent, now, expiry.Format(time.RFC3339), session, staleBefore.UTC().Format(time.RFC3339))
+	return err
+}
+
+// DeleteSession deletes a single session
+func DeleteSession(db *sql.DB, session string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("DELETE FROM sessions WHERE token = ?", session)
+	return err
+}
+
+// DeleteUserSessions deletes all of a user's sessions
+func DeleteUserSessions(db *sql.DB, username string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("DELETE FROM sessions WHERE username = ?", username)
+	return err
+}
+
+// DeleteExpiredSessions deletes every session that expired before now and
+// returns how many there were
+func DeleteExpiredSessions(db *sql.DB, now time.Time) (int, error) {
+	rows, err := db.Query("SELECT token, expires FROM sessions")
+	if err != nil {
+		return 0, err
+	}
+	var expired []string
+	for rows.Next() {
+		var token, expiresString string
+		if err := rows.Scan(&token, &expiresString); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		expires, err := time.Parse(time.RFC3339, expiresString)
+		if err != nil || expires.Before(now) {
+			expired = append(expired, token)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, token := range expired {
+		if _, err := db.Exec("DELETE FROM sessions WHERE token = ?", token); err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), nil
+}
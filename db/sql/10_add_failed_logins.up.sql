@@ -0,0 +1,12 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+CREATE TABLE failed_logins
+(
+    id         INTEGER   NOT NULL PRIMARY KEY AUTOINCREMENT,
+    username   TEXT      NOT NULL,
+    ip         TEXT      NOT NULL,
+    reason     TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
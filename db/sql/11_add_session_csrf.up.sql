@@ -0,0 +1,7 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+-- Token that must accompany every state-changing request made with the session
+ALTER TABLE sessions ADD COLUMN csrf TEXT NOT NULL DEFAULT '';
+UPDATE sessions SET csrf = lower(hex(randomblob(32)));
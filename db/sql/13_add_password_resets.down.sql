@@ -0,0 +1,6 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+DROP TABLE password_resets;
+ALTER TABLE users DROP COLUMN email;
@@ -0,0 +1,15 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+-- Where password reset links are sent
+ALTER TABLE users ADD COLUMN email TEXT NOT NULL DEFAULT '';
+
+-- token is the SHA-256 hash of the token in the reset link
+CREATE TABLE password_resets
+(
+    token      TEXT      NOT NULL PRIMARY KEY,
+    username   TEXT      NOT NULL,
+    expires    TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
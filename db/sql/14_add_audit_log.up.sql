@@ -0,0 +1,14 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+-- actor is the username that did something, or "cli" for the command line
+CREATE TABLE audit_log
+(
+    id         INTEGER   NOT NULL PRIMARY KEY AUTOINCREMENT,
+    actor      TEXT      NOT NULL,
+    action     TEXT      NOT NULL,
+    target     TEXT      NOT NULL,
+    details    TEXT      NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
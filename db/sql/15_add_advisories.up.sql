@@ -0,0 +1,19 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+-- Security advisories that mention a tracked project. affected is the
+-- JSON-encoded OSV affected entries matching the project, which running
+-- versions are checked against.
+CREATE TABLE advisories
+(
+    id         TEXT      NOT NULL,
+    project_id TEXT      NOT NULL,
+    summary    TEXT      NOT NULL DEFAULT '',
+    severity   TEXT      NOT NULL DEFAULT '',
+    url        TEXT      NOT NULL DEFAULT '',
+    affected   TEXT      NOT NULL,
+    modified   TEXT      NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id, project_id)
+);
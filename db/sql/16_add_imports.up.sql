@@ -0,0 +1,15 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+-- Which projects a user tracks because a dependency in an imported manifest or
+-- SBOM pointed at them. source names the document and dependency identifies
+-- the entry in it, so re-importing the document can tell what disappeared.
+CREATE TABLE imports
+(
+    username   TEXT NOT NULL,
+    source     TEXT NOT NULL,
+    dependency TEXT NOT NULL,
+    project_id TEXT NOT NULL,
+    PRIMARY KEY (username, source, dependency)
+);
@@ -0,0 +1,7 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+-- Projects listed in the projects file are managed by it and can't be changed
+-- from the web UI
+ALTER TABLE user_projects ADD COLUMN managed INTEGER NOT NULL DEFAULT 0;
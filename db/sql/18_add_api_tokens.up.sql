@@ -0,0 +1,12 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+-- Only a hash of each token is kept because they can change projects
+CREATE TABLE api_tokens
+(
+    username   TEXT      NOT NULL PRIMARY KEY,
+    token_hash TEXT      NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used  TIMESTAMP
+);
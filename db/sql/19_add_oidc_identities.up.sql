@@ -0,0 +1,14 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+-- Ties accounts to the identity provider's subject, which unlike usernames
+-- and email addresses can't be changed by its users
+CREATE TABLE oidc_identities
+(
+    issuer     TEXT      NOT NULL,
+    subject    TEXT      NOT NULL,
+    username   TEXT      NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (issuer, subject)
+);
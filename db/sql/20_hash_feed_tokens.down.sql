@@ -0,0 +1,8 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+-- Hashes can't be turned back into tokens, so everyone gets new feed URLs
+DELETE FROM feed_tokens;
+
+ALTER TABLE feed_tokens RENAME COLUMN token_hash TO token;
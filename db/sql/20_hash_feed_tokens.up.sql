@@ -0,0 +1,7 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+-- Only a hash of each token is kept, like API tokens. The post hook hashes
+-- existing tokens so feed readers subscribed with them keep working.
+ALTER TABLE feed_tokens RENAME COLUMN token TO token_hash;
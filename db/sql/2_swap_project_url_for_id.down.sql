@@ -23,6 +23,6 @@ SELECT
     r.content,
     r.date
 FROM releases_tmp r
-JOIN projects p ON r.project_url = p.url;
+JOIN projects p ON r.project_id = p.id;
 
 DROP TABLE releases_tmp;
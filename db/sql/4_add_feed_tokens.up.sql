@@ -0,0 +1,10 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+CREATE TABLE feed_tokens
+(
+    username   TEXT      NOT NULL PRIMARY KEY,
+    token      TEXT      NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,36 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+ALTER TABLE projects RENAME TO projects_tmp;
+
+CREATE TABLE projects
+(
+    id         TEXT      NOT NULL PRIMARY KEY,
+    url        TEXT      NOT NULL,
+    name       TEXT      NOT NULL,
+    forge      TEXT      NOT NULL,
+    version    TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Running versions are per-user now, so the earliest user's version is kept
+INSERT INTO projects (id, url, name, forge, version, created_at)
+SELECT
+    p.id,
+    p.url,
+    p.name,
+    p.forge,
+    COALESCE((
+        SELECT up.version
+        FROM user_projects up
+        WHERE up.project_id = p.id
+        ORDER BY up.created_at
+        LIMIT 1
+    ), ''),
+    p.created_at
+FROM projects_tmp p;
+
+DROP TABLE projects_tmp;
+
+DROP TABLE user_projects;
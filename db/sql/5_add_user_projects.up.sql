@@ -0,0 +1,43 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+CREATE TABLE user_projects
+(
+    username   TEXT      NOT NULL,
+    project_id TEXT      NOT NULL,
+    version    TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (username, project_id)
+);
+
+INSERT INTO user_projects (username, project_id, version, created_at)
+SELECT
+    u.username,
+    p.id,
+    p.version,
+    p.created_at
+FROM users u
+CROSS JOIN projects p;
+
+ALTER TABLE projects RENAME TO projects_tmp;
+
+CREATE TABLE projects
+(
+    id         TEXT      NOT NULL PRIMARY KEY,
+    url        TEXT      NOT NULL,
+    name       TEXT      NOT NULL,
+    forge      TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO projects (id, url, name, forge, created_at)
+SELECT
+    id,
+    url,
+    name,
+    forge,
+    created_at
+FROM projects_tmp;
+
+DROP TABLE projects_tmp;
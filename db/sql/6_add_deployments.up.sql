@@ -0,0 +1,14 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+CREATE TABLE deployments
+(
+    id         TEXT      NOT NULL PRIMARY KEY,
+    project_id TEXT      NOT NULL,
+    name       TEXT      NOT NULL,
+    version    TEXT      NOT NULL,
+    notes      TEXT      NOT NULL DEFAULT '',
+    owner      TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
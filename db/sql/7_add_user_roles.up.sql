@@ -0,0 +1,6 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+-- Everyone had full access before roles existed, so existing users are admins
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'admin';
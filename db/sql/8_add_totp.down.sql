@@ -0,0 +1,7 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+DROP TABLE pending_logins;
+DROP TABLE recovery_codes;
+DROP TABLE totp;
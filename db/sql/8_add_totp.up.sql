@@ -0,0 +1,33 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+-- Secrets stay unconfirmed until the user proves their authenticator works.
+-- last_counter is the most recent time step a code was accepted for so codes
+-- can't be replayed.
+CREATE TABLE totp
+(
+    username     TEXT      NOT NULL PRIMARY KEY,
+    secret       TEXT      NOT NULL,
+    confirmed    BOOLEAN   NOT NULL DEFAULT 0,
+    last_counter INTEGER   NOT NULL DEFAULT 0,
+    created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE recovery_codes
+(
+    username   TEXT      NOT NULL,
+    hash       TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (username, hash)
+);
+
+-- Logins that passed the password check and are waiting for a second factor
+CREATE TABLE pending_logins
+(
+    token      TEXT      NOT NULL PRIMARY KEY,
+    username   TEXT      NOT NULL,
+    expires    TIMESTAMP NOT NULL,
+    attempts   INTEGER   NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,7 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+DROP TABLE webauthn_challenges;
+DROP TABLE webauthn_credentials;
+ALTER TABLE users DROP COLUMN webauthn_id;
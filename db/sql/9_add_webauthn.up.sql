@@ -0,0 +1,28 @@
+-- SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+--
+-- SPDX-License-Identifier: CC0-1.0
+
+-- Random user handle given to authenticators instead of the username
+ALTER TABLE users ADD COLUMN webauthn_id TEXT;
+
+-- credential is the JSON-encoded public key, flags, and signature counter
+CREATE TABLE webauthn_credentials
+(
+    id         TEXT      NOT NULL PRIMARY KEY,
+    username   TEXT      NOT NULL,
+    name       TEXT      NOT NULL,
+    credential TEXT      NOT NULL,
+    last_used  TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Challenges for registrations and logins that are in progress. username is
+-- empty for logins with discoverable credentials.
+CREATE TABLE webauthn_challenges
+(
+    token      TEXT      NOT NULL PRIMARY KEY,
+    username   TEXT      NOT NULL,
+    session    TEXT      NOT NULL,
+    expires    TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,162 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// GetTOTP returns a user's TOTP secret, whether they've confirmed it, and the
+// last time step a code was accepted for
+func GetTOTP(db *sql.DB, username string) (string, bool, int64, error) {
+	var (
+		secret      string
+		confirmed   bool
+		lastCounter int64
+	)
+	err := db.QueryRow("SELECT secret, confirmed, last_counter FROM totp WHERE username = ?", username).Scan(&secret, &confirmed, &lastCounter)
+	return secret, confirmed, lastCounter, err
+}
+
+// UpsertTOTPSecret stores a new, unconfirmed TOTP secret for a user
+func UpsertTOTPSecret(db *sql.DB, username, secret string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec(`INSERT INTO totp (username, secret)
+		VALUES (?, ?)
+		ON CONFLICT(username) DO
+			UPDATE SET
+				secret = excluded.secret,
+				confirmed = 0,
+				last_counter = 0,
+				created_at = CURRENT_TIMESTAMP;`, username, secret)
+	return err
+}
+
+// ConfirmTOTP marks a user's TOTP secret as confirmed
+func ConfirmTOTP(db *sql.DB, username string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("UPDATE totp SET confirmed = 1 WHERE username = ?", username)
+	return err
+}
+
+// SetTOTPCounter records the time step of the most recently accepted code,
+// returning false if a code for that or a later step was already accepted
+func SetTOTPCounter(db *sql.DB, username string, counter int64) (bool, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	result, err := db.Exec("UPDATE totp SET last_counter = ? WHERE username = ? AND last_counter < ?", counter, username, counter)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+// DeleteTOTP removes a user's TOTP secret and recovery codes
+func DeleteTOTP(db *sql.DB, username string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("DELETE FROM totp WHERE username = ?", username)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM recovery_codes WHERE username = ?", username)
+	return err
+}
+
+// ReplaceRecoveryCodes replaces all of a user's recovery code hashes
+func ReplaceRecoveryCodes(db *sql.DB, username string, hashes []string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE username = ?", username)
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		_, err = tx.Exec("INSERT INTO recovery_codes (username, hash) VALUES (?, ?)", username, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode deletes a recovery code hash, returning true if the user
+// had it
+func UseRecoveryCode(db *sql.DB, username, hash string) (bool, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	result, err := db.Exec("DELETE FROM recovery_codes WHERE username = ? AND hash = ?", username, hash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes a user has
+func CountRecoveryCodes(db *sql.DB, username string) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE username = ?", username).Scan(&count)
+	return count, err
+}
+
+// CreatePendingLogin records a login waiting for its second factor
+func CreatePendingLogin(db *sql.DB, token, username string, expiry time.Time) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("DELETE FROM pending_logins WHERE expires < ?", time.Now().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO pending_logins (token, username, expires) VALUES (?, ?, ?)", token, username, expiry.Format(time.RFC3339))
+	return err
+}
+
+// GetPendingLogin returns the username, expiry, and number of failed attempts
+// of a pending login
+func GetPendingLogin(db *sql.DB, token string) (string, time.Time, int, error) {
+	var (
+		username      string
+		expiresString string
+		attempts      int
+	)
+	err := db.QueryRow("SELECT username, expires, attempts FROM pending_logins WHERE token = ?", token).Scan(&username, &expiresString, &attempts)
+	if err != nil {
+		return "", time.Time{}, 0, err
+	}
+
+	expires, err := time.Parse(time.RFC3339, expiresString)
+	if err != nil {
+		return "", time.Time{}, 0, err
+	}
+	return username, expires, attempts, nil
+}
+
+// IncrementPendingLoginAttempts records a failed attempt at a pending login's
+// second factor
+func IncrementPendingLoginAttempts(db *sql.DB, token string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("UPDATE pending_logins SET attempts = attempts + 1 WHERE token = ?", token)
+	return err
+}
+
+// DeletePendingLogin removes a pending login
+func DeletePendingLogin(db *sql.DB, token string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("DELETE FROM pending_logins WHERE token = ?", token)
+	return err
+}
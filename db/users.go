@@ -15,17 +15,145 @@ func DeleteUser(db *sql.DB, user string) error {
 	mutex.Lock()
 	defer mutex.Unlock()
 	_, err := db.Exec("DELETE FROM users WHERE username = ?", user)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM feed_tokens WHERE username = ?", user)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM api_tokens WHERE username = ?", user)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM oidc_identities WHERE username = ?", user)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM user_projects WHERE username = ?", user)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM imports WHERE username = ?", user)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM totp WHERE username = ?", user)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM recovery_codes WHERE username = ?", user)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM pending_logins WHERE username = ?", user)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM webauthn_credentials WHERE username = ?", user)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM webauthn_challenges WHERE username = ?", user)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM sessions WHERE username = ?", user)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM password_resets WHERE username = ?", user)
 	return err
 }
 
 // CreateUser creates a new user in the database and returns an error if it fails
-func CreateUser(db *sql.DB, username, hash, salt string) error {
+func CreateUser(db *sql.DB, username, hash, salt, role string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("INSERT INTO users (username, hash, salt, role) VALUES (?, ?, ?, ?)", username, hash, salt, role)
+	return err
+}
+
+// SetUserPassword replaces a user's hash and salt and returns an error if it
+// fails
+func SetUserPassword(db *sql.DB, username, hash, salt string) error {
 	mutex.Lock()
 	defer mutex.Unlock()
-	_, err := db.Exec("INSERT INTO users (username, hash, salt) VALUES (?, ?, ?)", username, hash, salt)
+	_, err := db.Exec("UPDATE users SET hash = ?, salt = ? WHERE username = ?", hash, salt, username)
 	return err
 }
 
+// GetUserEmail returns a user's email address, which is empty if they haven't
+// set one
+func GetUserEmail(db *sql.DB, username string) (string, error) {
+	var email string
+	err := db.QueryRow("SELECT email FROM users WHERE username = ?", username).Scan(&email)
+	return email, err
+}
+
+// SetUserEmail changes a user's email address and returns an error if it fails
+func SetUserEmail(db *sql.DB, username, email string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("UPDATE users SET email = ? WHERE username = ?", email, username)
+	return err
+}
+
+// GetUsernameByEmail returns the username of the user with the email address
+func GetUsernameByEmail(db *sql.DB, email string) (string, error) {
+	var username string
+	err := db.QueryRow("SELECT username FROM users WHERE email != '' AND email = ? COLLATE NOCASE", email).Scan(&username)
+	return username, err
+}
+
+// GetUserRole returns a user's role from the database and returns an error if
+// it fails
+func GetUserRole(db *sql.DB, username string) (string, error) {
+	var role string
+	err := db.QueryRow("SELECT role FROM users WHERE username = ?", username).Scan(&role)
+	return role, err
+}
+
+// SetUserRole changes a user's role and returns an error if it fails
+func SetUserRole(db *sql.DB, username, role string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("UPDATE users SET role = ? WHERE username = ?", role, username)
+	return err
+}
+
+// CountUsersWithRole returns the number of users with the given role
+func CountUsersWithRole(db *sql.DB, role string) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", role).Scan(&count)
+	return count, err
+}
+
+// GetUsersWithRoles returns a list of all users in the database along with
+// their roles and returns an error if it fails
+func GetUsersWithRoles(db *sql.DB) ([]map[string]string, error) {
+	rows, err := db.Query("SELECT username, role FROM users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []map[string]string
+	for rows.Next() {
+		var username, role string
+		err = rows.Scan(&username, &role)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, map[string]string{
+			"username": username,
+			"role":     role,
+		})
+	}
+
+	return users, nil
+}
+
 // GetUser returns a user's hash and salt from the database as strings and
 // returns an error if it fails
 func GetUser(db *sql.DB, username string) (string, string, error) {
@@ -73,20 +201,19 @@ func GetSession(db *sql.DB, session string) (string, time.Time, error) {
 	return username, expires, nil
 }
 
-// InvalidateSession invalidates a session by setting the expiration date to the
-// provided time.
-func InvalidateSession(db *sql.DB, session string, expiry time.Time) error {
-	mutex.Lock()
-	defer mutex.Unlock()
-	_, err := db.Exec("UPDATE sessions SET expires = ? WHERE token = ?", expiry.Format(time.RFC3339), session)
-	return err
-}
-
 // CreateSession creates a new session in the database and returns an error if
 // it fails
-func CreateSession(db *sql.DB, username, token string, expiry time.Time) error {
+func CreateSession(db *sql.DB, username, token, csrf, ip, userAgent string, expiry time.Time) error {
 	mutex.Lock()
 	defer mutex.Unlock()
-	_, err := db.Exec("INSERT INTO sessions (token, username, expires) VALUES (?, ?, ?)", token, username, expiry.Format(time.RFC3339))
+	_, err := db.Exec("INSERT INTO sessions (token, username, csrf, ip, user_agent, last_seen, expires) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		token, username, csrf, ip, userAgent, time.Now().UTC().Format(time.RFC3339), expiry.Format(time.RFC3339))
 	return err
 }
+
+// GetSessionCSRF returns the CSRF token belonging to a session
+func GetSessionCSRF(db *sql.DB, session string) (string, error) {
+	var csrf string
+	err := db.QueryRow("SELECT csrf FROM sessions WHERE token = ?", session).Scan(&csrf)
+	return csrf, err
+}
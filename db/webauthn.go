@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// GetWebAuthnID returns the user handle given to a user's authenticators or an
+// empty string if they don't have one yet
+func GetWebAuthnID(db *sql.DB, username string) (string, error) {
+	var id sql.NullString
+	err := db.QueryRow("SELECT webauthn_id FROM users WHERE username = ?", username).Scan(&id)
+	return id.String, err
+}
+
+// SetWebAuthnID sets the user handle given to a user's authenticators
+func SetWebAuthnID(db *sql.DB, username, id string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("UPDATE users SET webauthn_id = ? WHERE username = ?", id, username)
+	return err
+}
+
+// GetWebAuthnIDUser returns the username associated with a user handle
+func GetWebAuthnIDUser(db *sql.DB, id string) (string, error) {
+	var username string
+	err := db.QueryRow("SELECT username FROM users WHERE webauthn_id = ?", id).Scan(&username)
+	return username, err
+}
+
+// GetWebAuthnCredentials returns all of a user's WebAuthn credentials
+func GetWebAuthnCredentials(db *sql.DB, username string) ([]map[string]string, error) {
+	rows, err := db.Query(`SELECT id, name, credential, COALESCE(last_used, ''), created_at
+		FROM webauthn_credentials
+		WHERE username = ?
+		ORDER BY created_at`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credentials []map[string]string
+	for rows.Next() {
+		var id, name, credential, lastUsed, createdAt string
+		err = rows.Scan(&id, &name, &credential, &lastUsed, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, map[string]string{
+			"id":         id,
+			"name":       name,
+			"credential": credential,
+			"last_used":  lastUsed,
+			"created_at": createdAt,
+		})
+	}
+	return credentials, nil
+}
+
+// CreateWebAuthnCredential stores a newly registered WebAuthn credential
+func CreateWebAuthnCredential(db *sql.DB, id, username, name, credential string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("INSERT INTO webauthn_credentials (id, username, name, credential) VALUES (?, ?, ?, ?)", id, username, name, credential)
+	return err
+}
+
+// UpdateWebAuthnCredential stores a credential's new signature counter and
+// records when it was used
+func UpdateWebAuthnCredential(db *sql.DB, id, credential string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("UPDATE webauthn_credentials SET credential = ?, last_used = ? WHERE id = ?", credential, time.Now().Format(time.RFC3339), id)
+	return err
+}
+
+// DeleteWebAuthnCredential removes one of a user's WebAuthn credentials
+func DeleteWebAuthnCredential(db *sql.DB, username, id string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("DELETE FROM webauthn_credentials WHERE username = ? AND id = ?", username, id)
+	return err
+}
+
+// CreateWebAuthnChallenge stores the state of a WebAuthn registration or login
+// that's in progress
+func CreateWebAuthnChallenge(db *sql.DB, token, username, session string, expiry time.Time) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, err := db.Exec("DELETE FROM webauthn_challenges WHERE expires < ?", time.Now().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO webauthn_challenges (token, username, session, expires) VALUES (?, ?, ?, ?)", token, username, session, expiry.Format(time.RFC3339))
+	return err
+}
+
+// TakeWebAuthnChallenge returns the username, state, and expiry of a WebAuthn
+// registration or login and deletes it so it can only be used once
+func TakeWebAuthnChallenge(db *sql.DB, token string) (string, string, time.Time, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var username, session, expiresString string
+	err := db.QueryRow("SELECT username, session, expires FROM webauthn_challenges WHERE token = ?", token).Scan(&username, &session, &expiresString)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	_, err = db.Exec("DELETE FROM webauthn_challenges WHERE token = ?", token)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	expires, err := time.Parse(time.RFC3339, expiresString)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return username, session, expires, nil
+}
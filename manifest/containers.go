@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"git.sr.ht/~amolith/willow/oci"
+)
+
+// EcosystemContainer is for container images, which OSV doesn't cover. Their
+// names are the full image name, like docker.io/library/nginx, and their
+// versions the tag.
+const EcosystemContainer = "container"
+
+// isCompose reports whether the file name is one Docker Compose reads,
+// including overrides like docker-compose.prod.yml
+func isCompose(base string) bool {
+	ext := filepath.Ext(base)
+	if ext != ".yml" && ext != ".yaml" {
+		return false
+	}
+	name := strings.TrimSuffix(base, ext)
+	return name == "compose" || name == "docker-compose" ||
+		strings.HasPrefix(name, "compose.") || strings.HasPrefix(name, "docker-compose.")
+}
+
+// isKubernetes reports whether a YAML file holds Kubernetes objects, which
+// unlike compose files can be called anything
+func isKubernetes(base string, data []byte) bool {
+	ext := filepath.Ext(base)
+	if ext != ".yml" && ext != ".yaml" {
+		return false
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var object struct {
+			APIVersion string `yaml:"apiVersion"`
+			Kind       string `yaml:"kind"`
+		}
+		if err := dec.Decode(&object); err != nil {
+			return false
+		}
+		if object.APIVersion != "" && object.Kind != "" {
+			return true
+		}
+	}
+}
+
+// parseCompose returns the images of a compose file's services. Services that
+// are only built locally have no image to track.
+func parseCompose(data []byte) ([]Dependency, error) {
+	var compose struct {
+		Services map[string]struct {
+			Image string `yaml:"image"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(data, &compose); err != nil {
+		return nil, err
+	}
+
+	images := make([]string, 0, len(compose.Services))
+	for _, service := range compose.Services {
+		if service.Image != "" {
+			images = append(images, service.Image)
+		}
+	}
+	return imageDependencies(images), nil
+}
+
+// podKeys are the fields of a pod spec that list containers
+var podKeys = map[string]bool{
+	"containers":          true,
+	"initContainers":      true,
+	"ephemeralContainers": true,
+}
+
+// parseKubernetes returns the images of every container in a file of
+// Kubernetes objects. Pod specs are nested differently in each kind of
+// workload, and in custom resources, so they're found by walking the whole
+// document rather than by kind.
+func parseKubernetes(data []byte) ([]Dependency, error) {
+	var images []string
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var node yaml.Node
+		err := dec.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		images = append(images, podImages(&node, false)...)
+	}
+	return imageDependencies(images), nil
+}
+
+// podImages collects the images of containers under node, where inPod says
+// whether node is a list of containers
+func podImages(node *yaml.Node, inPod bool) []string {
+	var images []string
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			images = append(images, podImages(child, inPod)...)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			if inPod && key == "image" && value.Kind == yaml.ScalarNode {
+				images = append(images, value.Value)
+				continue
+			}
+			images = append(images, podImages(value, podKeys[key])...)
+		}
+	}
+	return images
+}
+
+// interpolation matches compose variables with defaults, like
+// ${TAG:-1.2.3}, whose defaults are the best guess at what's running
+var interpolation = regexp.MustCompile(`\$\{[A-Za-z_][A-Za-z0-9_]*:?-([^}]*)\}`)
+
+// imageDependencies turns image references into dependencies, sorted by
+// name. Images without a version tag, like nginx:latest or those pinned only
+// by digest, are returned without a version so they're reported as unpinned.
+// References that still can't be parsed, like ones with variables lacking
+// defaults, are left out.
+func imageDependencies(images []string) []Dependency {
+	deps := make([]Dependency, 0, len(images))
+	for _, image := range images {
+		ref, err := oci.ParseReference(interpolation.ReplaceAllString(image, "$1"))
+		if err != nil {
+			continue
+		}
+		dep := Dependency{Ecosystem: EcosystemContainer, Name: ref.Name()}
+		if _, _, ok := oci.SplitTag(ref.Tag); ok {
+			dep.Version = ref.Tag
+		}
+		deps = append(deps, dep)
+	}
+	sort.SliceStable(deps, func(i, j int) bool {
+		return deps[i].Name < deps[j].Name
+	})
+	return deps
+}
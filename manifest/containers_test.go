@@ -0,0 +1,195 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"git.sr.ht/~amolith/willow/db"
+	"git.sr.ht/~amolith/willow/project"
+)
+
+func TestParseContainers(t *testing.T) {
+	tests := []struct {
+		filename string
+		data     string
+		want     []Dependency
+	}{
+		{"docker-compose.prod.yml", `services:
+  web:
+    image: nginx:1.25.3-alpine
+  app:
+    image: ghcr.io/owner/app:${APP_VERSION:-v2.1.0}
+  db:
+    image: postgres:16.2@sha256:abcdef0123
+  cache:
+    image: redis
+  worker:
+    build: .
+  broken:
+    image: ${IMAGE}
+`, []Dependency{
+			{Ecosystem: EcosystemContainer, Name: "docker.io/library/nginx", Version: "1.25.3-alpine"},
+			{Ecosystem: EcosystemContainer, Name: "docker.io/library/postgres", Version: "16.2"},
+			{Ecosystem: EcosystemContainer, Name: "docker.io/library/redis"},
+			{Ecosystem: EcosystemContainer, Name: "ghcr.io/owner/app", Version: "v2.1.0"},
+		}},
+		{"app.yaml", `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  image: not/an-image:1.0.0
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: migrate
+          image: ghcr.io/owner/app:v2.1.0
+      containers:
+        - name: app
+          image: ghcr.io/owner/app:v2.1.0
+        - name: proxy
+          image: quay.io/oauth2-proxy/oauth2-proxy:latest
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: backup
+              image: docker.io/restic/restic:0.16.4
+`, []Dependency{
+			{Ecosystem: EcosystemContainer, Name: "docker.io/restic/restic", Version: "0.16.4"},
+			{Ecosystem: EcosystemContainer, Name: "ghcr.io/owner/app", Version: "v2.1.0"},
+			{Ecosystem: EcosystemContainer, Name: "ghcr.io/owner/app", Version: "v2.1.0"},
+			{Ecosystem: EcosystemContainer, Name: "quay.io/oauth2-proxy/oauth2-proxy"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			doc, err := Parse(tt.filename, []byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(doc.Dependencies, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", doc.Dependencies, tt.want)
+			}
+		})
+	}
+
+	if _, err := Parse("values.yaml", []byte("replicaCount: 1\n")); err == nil {
+		t.Error("Parse() accepted YAML that isn't a compose file or Kubernetes manifest")
+	}
+}
+
+func TestResolveContainer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ghcr.io/v2/owner/app/manifests/v2.1.0":
+			_, _ = w.Write([]byte(`{"config": {"digest": "sha256:config"}}`))
+		case "/ghcr.io/v2/owner/app/blobs/sha256:config":
+			_, _ = w.Write([]byte(`{"config": {"Labels": {"org.opencontainers.image.source": "https://github.com/owner/app.git"}}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: redirect{serverURL}}
+
+	tests := []struct {
+		dep      Dependency
+		upstream bool
+		want     Source
+	}{
+		{Dependency{Ecosystem: EcosystemContainer, Name: "docker.io/library/nginx", Version: "1.25.3-alpine"}, false,
+			Source{Name: "nginx", URL: "https://hub.docker.com/r/library/nginx#alpine", Forge: "container"}},
+		{Dependency{Ecosystem: EcosystemContainer, Name: "ghcr.io/owner/app", Version: "v2.1.0"}, false,
+			Source{Name: "ghcr.io/owner/app", URL: "https://ghcr.io/owner/app", Forge: "container"}},
+		{Dependency{Ecosystem: EcosystemContainer, Name: "ghcr.io/owner/app", Version: "v2.1.0"}, true,
+			Source{Name: "app", URL: "https://github.com/owner/app", Forge: "github", Version: "v2.1.0"}},
+		// Images without a source label stay on their registry
+		{Dependency{Ecosystem: EcosystemContainer, Name: "docker.io/library/nginx", Version: "1.25.3"}, true,
+			Source{Name: "nginx", URL: "https://hub.docker.com/r/library/nginx", Forge: "container"}},
+	}
+	for _, tt := range tests {
+		got, err := Resolver{Client: client, Upstream: tt.upstream}.Resolve(tt.dep)
+		if err != nil || got != tt.want {
+			t.Errorf("Resolve(%+v) with upstream %v = %+v, %v; want %+v", tt.dep, tt.upstream, got, err, tt.want)
+		}
+	}
+}
+
+func TestImportContainers(t *testing.T) {
+	dbConn, err := db.Open(filepath.Join(t.TempDir(), "willow.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbConn.Close()
+	if err := db.InitialiseDatabase(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	mu := &sync.Mutex{}
+
+	importCompose := func(compose string) Result {
+		t.Helper()
+		doc, err := Parse("compose.yaml", []byte(compose))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := Import(dbConn, mu, "alice", doc, Resolver{}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	running := func(url string) string {
+		t.Helper()
+		row, err := db.GetUserProject(dbConn, "alice", project.GenProjectID(url, "nginx", "container"))
+		if err != nil {
+			return ""
+		}
+		return row["version"]
+	}
+
+	importCompose("services: {web: {image: 'nginx:1.25.3-alpine'}}")
+	importCompose("services: {web: {image: 'nginx:1.25.4-alpine'}}")
+	if got := running("https://hub.docker.com/r/library/nginx#alpine"); got != "1.25.4-alpine" {
+		t.Errorf("nginx is running %q after re-import, want 1.25.4-alpine", got)
+	}
+
+	// Switching variants replaces the project rather than adding another
+	result := importCompose("services: {web: {image: 'nginx:1.25.4'}}")
+	if len(result.Untracked) != 1 || result.Untracked[0] != "nginx" {
+		t.Errorf("untracked %v, want [nginx]", result.Untracked)
+	}
+	if running("https://hub.docker.com/r/library/nginx#alpine") != "" {
+		t.Error("the alpine variant is still tracked")
+	}
+	if got := running("https://hub.docker.com/r/library/nginx"); got != "1.25.4" {
+		t.Errorf("nginx is running %q, want 1.25.4", got)
+	}
+}
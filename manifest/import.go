@@ -0,0 +1,230 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/microcosm-cc/bluemonday"
+
+	"git.sr.ht/~amolith/willow/db"
+	"git.sr.ht/~amolith/willow/project"
+)
+
+// Outcome is what importing a dependency did. Err explains why it was
+// skipped.
+type Outcome struct {
+	Dependency Dependency
+	Source     Source
+	Err        error
+}
+
+// Result lists the dependencies that were tracked and those that were
+// skipped, along with the names of projects that were untracked because
+// they disappeared from the document
+type Result struct {
+	Imported  []Outcome
+	Skipped   []Outcome
+	Untracked []string
+}
+
+var bmStrict = bluemonday.StrictPolicy()
+
+// resolveWorkers is how many dependencies are looked up at once, which keeps
+// large lock files quick without hammering registries
+const resolveWorkers = 8
+
+// Import resolves each dependency in the document to its source repo and
+// tracks it for the user with its version as the one they're running. Repos
+// that are already tracked keep their name and URL, and dependencies from the
+// same repo are only tracked once. Importing the same document again updates
+// the versions, replaces projects whose dependencies now resolve to another
+// one, and with untrack set, stops tracking projects whose dependencies are
+// no longer in it. Projects the user tracked before importing the document
+// are never untracked. The caller refreshes afterwards.
+func Import(dbConn *sql.DB, mu *sync.Mutex, username string, doc Document, resolver Resolver, untrack bool) (Result, error) {
+	tracked, err := project.GetProjectsByRepo(dbConn)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var result Result
+	source := bmStrict.Sanitize(doc.Name)
+	previous, err := importedProjects(dbConn, username, source)
+	if err != nil {
+		return Result{}, err
+	}
+	present := make(map[string]bool)
+	pinned := make([]Dependency, 0, len(doc.Dependencies))
+	for _, d := range doc.Dependencies {
+		d.Name = bmStrict.Sanitize(d.Name)
+		d.Version = bmStrict.Sanitize(d.Version)
+		if d.Name == "" {
+			continue
+		}
+		present[d.key()] = true
+		if d.Version == "" {
+			result.Skipped = append(result.Skipped, Outcome{Dependency: d, Err: fmt.Errorf("no version is pinned")})
+			continue
+		}
+		pinned = append(pinned, d)
+	}
+
+	seen := make(map[string]Outcome)
+	for _, o := range resolveAll(pinned, resolver) {
+		if o.Err != nil {
+			result.Skipped = append(result.Skipped, o)
+			continue
+		}
+		o.Source.URL = bmStrict.Sanitize(o.Source.URL)
+		version := o.Dependency.Version
+		if o.Source.Version != "" {
+			version = bmStrict.Sanitize(o.Source.Version)
+		}
+		repo := project.RepoKey(o.Source.Forge, o.Source.URL)
+		if existing, ok := tracked[repo]; ok {
+			o.Source = Source{Name: existing.Name, URL: existing.URL, Forge: existing.Forge}
+		}
+		first, duplicate := seen[repo]
+		if duplicate {
+			o.Source = first.Source
+		}
+		id := project.GenProjectID(o.Source.URL, o.Source.Name, o.Source.Forge)
+
+		// Duplicates are recorded too so the project stays tracked as long as
+		// any of its dependencies are in the document
+		if err := recordImport(dbConn, mu, username, source, o.Dependency, id); err != nil {
+			return result, err
+		}
+		// A dependency can move to another project between imports, like an
+		// image switching to its -alpine variant, which replaces the old one
+		if old, ok := previous[o.Dependency.key()]; ok && old != id {
+			name, err := untrackUnused(dbConn, mu, username, old)
+			if err != nil {
+				return result, err
+			}
+			if name != "" {
+				result.Untracked = append(result.Untracked, name)
+			}
+		}
+		if duplicate {
+			o.Err = fmt.Errorf("same repo as %s", first.Dependency.Name)
+			result.Skipped = append(result.Skipped, o)
+			continue
+		}
+		seen[repo] = o
+		project.Track(dbConn, mu, nil, username, o.Source.Name, o.Source.URL, o.Source.Forge, version)
+		result.Imported = append(result.Imported, o)
+	}
+
+	if untrack {
+		untracked, err := untrackMissing(dbConn, mu, username, source, present)
+		result.Untracked = append(result.Untracked, untracked...)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// importedProjects maps the dependencies imported from the document before to
+// the projects they were tracked as
+func importedProjects(dbConn *sql.DB, username, source string) (map[string]string, error) {
+	imports, err := db.GetImports(dbConn, username, source)
+	if err != nil {
+		return nil, err
+	}
+	projects := make(map[string]string, len(imports))
+	for _, i := range imports {
+		projects[i["dependency"]] = i["project_id"]
+	}
+	return projects, nil
+}
+
+// recordImport remembers that the dependency made the user track the project,
+// unless they already tracked it themselves
+func recordImport(dbConn *sql.DB, mu *sync.Mutex, username, source string, dep Dependency, id string) error {
+	_, err := db.GetUserProject(dbConn, username, id)
+	if err == nil {
+		imports, err := db.CountProjectImports(dbConn, username, id)
+		if err != nil || imports == 0 {
+			return err
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return db.UpsertImport(dbConn, mu, username, source, dep.key(), id)
+}
+
+// untrackMissing forgets the dependencies that are no longer in the document
+// and untracks their projects unless another import still needs them
+func untrackMissing(dbConn *sql.DB, mu *sync.Mutex, username, source string, present map[string]bool) ([]string, error) {
+	imports, err := db.GetImports(dbConn, username, source)
+	if err != nil {
+		return nil, err
+	}
+
+	var untracked []string
+	for _, i := range imports {
+		if present[i["dependency"]] {
+			continue
+		}
+		if err := db.DeleteImport(dbConn, mu, username, source, i["dependency"]); err != nil {
+			return untracked, err
+		}
+		name, err := untrackUnused(dbConn, mu, username, i["project_id"])
+		if err != nil {
+			return untracked, err
+		}
+		if name != "" {
+			untracked = append(untracked, name)
+		}
+	}
+	return untracked, nil
+}
+
+// untrackUnused untracks the project if no import needs it anymore and the
+// projects file doesn't manage it, returning its name if it was untracked
+func untrackUnused(dbConn *sql.DB, mu *sync.Mutex, username, id string) (string, error) {
+	remaining, err := db.CountProjectImports(dbConn, username, id)
+	if err != nil || remaining > 0 || project.IsManaged(dbConn, username, id) {
+		return "", err
+	}
+	p, err := db.GetProject(dbConn, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	project.Untrack(dbConn, mu, username, id)
+	return p["name"], nil
+}
+
+// resolveAll resolves dependencies concurrently, returning outcomes in the
+// same order as the dependencies
+func resolveAll(deps []Dependency, resolver Resolver) []Outcome {
+	outcomes := make([]Outcome, len(deps))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < resolveWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				source, err := resolver.Resolve(deps[i])
+				outcomes[i] = Outcome{Dependency: deps[i], Source: source, Err: err}
+			}
+		}()
+	}
+	for i := range deps {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return outcomes
+}
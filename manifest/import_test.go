@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"git.sr.ht/~amolith/willow/db"
+	"git.sr.ht/~amolith/willow/project"
+)
+
+func TestImport(t *testing.T) {
+	dbConn, err := db.Open(filepath.Join(t.TempDir(), "willow.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbConn.Close()
+	if err := db.InitialiseDatabase(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	mu := &sync.Mutex{}
+
+	// Tracked by hand before anything was imported
+	project.Track(dbConn, mu, nil, "alice", "Manual", "https://github.com/example/manual", "github", "v1.0.0")
+
+	running := func(url string) string {
+		t.Helper()
+		row, err := db.GetUserProject(dbConn, "alice", project.GenProjectID(url, "Manual", "github"))
+		if err == nil {
+			return row["version"]
+		}
+		row, err = db.GetUserProject(dbConn, "alice", project.GenProjectID(url, "libfoo", "github"))
+		if err != nil {
+			return ""
+		}
+		return row["version"]
+	}
+	importDoc := func(untrack bool, deps ...Dependency) Result {
+		t.Helper()
+		result, err := Import(dbConn, mu, "alice", Document{Name: "app", Dependencies: deps}, Resolver{}, untrack)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	libfoo := Dependency{Name: "libfoo", Version: "2.0.0", Repo: "git+https://github.com/example/libfoo.git"}
+	libfooTests := Dependency{Name: "libfoo-tests", Version: "2.0.0", Repo: "https://github.com/example/libfoo/tree/main/tests"}
+	manual := Dependency{Name: "manual", Version: "v2.0.0", Repo: "https://github.com/example/manual"}
+
+	result := importDoc(false, libfoo, libfooTests, manual, Dependency{Ecosystem: EcosystemNPM, Name: "unpinned"})
+	if len(result.Imported) != 2 || len(result.Skipped) != 2 {
+		t.Fatalf("first import = %+v, want libfoo and manual imported and the rest skipped", result)
+	}
+	if result.Imported[1].Source.Name != "Manual" {
+		t.Errorf("manual was imported as %q, want the existing project's name kept", result.Imported[1].Source.Name)
+	}
+	if got := running("https://github.com/example/manual"); got != "v2.0.0" {
+		t.Errorf("manual is running %q, want v2.0.0", got)
+	}
+
+	// Importing again updates versions
+	libfoo.Version = "2.1.0"
+	importDoc(true, libfoo, libfooTests, manual)
+	if got := running("https://github.com/example/libfoo"); got != "2.1.0" {
+		t.Errorf("libfoo is running %q after re-import, want 2.1.0", got)
+	}
+
+	// Projects stay tracked while any dependency pointing at them is left,
+	// and those tracked by hand are never untracked
+	result = importDoc(true, libfooTests)
+	if len(result.Untracked) != 0 {
+		t.Errorf("untracked %v, want nothing", result.Untracked)
+	}
+	if running("https://github.com/example/libfoo") == "" || running("https://github.com/example/manual") == "" {
+		t.Error("a project was untracked too early")
+	}
+
+	// Without untrack, nothing disappears
+	if result := importDoc(false); len(result.Untracked) != 0 {
+		t.Errorf("untracked %v without being asked to", result.Untracked)
+	}
+
+	result = importDoc(true)
+	if len(result.Untracked) != 1 || result.Untracked[0] != "libfoo" {
+		t.Errorf("untracked %v, want [libfoo]", result.Untracked)
+	}
+	if running("https://github.com/example/libfoo") != "" {
+		t.Error("libfoo is still tracked")
+	}
+	if running("https://github.com/example/manual") == "" {
+		t.Error("the project tracked by hand was untracked")
+	}
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package manifest reads the dependencies out of package manifests, lock
+// files, and SBOMs so they can be tracked in bulk.
+package manifest
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Ecosystems dependencies come from, named the way OSV names them
+const (
+	EcosystemGo       = "Go"
+	EcosystemNPM      = "npm"
+	EcosystemCargo    = "crates.io"
+	EcosystemPyPI     = "PyPI"
+	EcosystemRubyGems = "RubyGems"
+)
+
+// Dependency is a package a manifest depends on and the version it's locked
+// to, or the version it asks for if there's no lock file
+type Dependency struct {
+	Ecosystem string
+	Name      string
+	Version   string
+	// Repo is the dependency's source repo when the document says what it
+	// is, which saves asking its registry. Dependencies outside the
+	// ecosystems above can only be tracked through it.
+	Repo string
+}
+
+// key identifies the dependency within a document across imports
+func (d Dependency) key() string {
+	if d.Ecosystem == "" {
+		return d.Repo
+	}
+	return d.Ecosystem + ":" + d.Name
+}
+
+// Document is a parsed manifest or SBOM
+type Document struct {
+	// Name identifies the document when it's imported again: the name an
+	// SBOM gives the software it describes, or else the file name
+	Name         string
+	Dependencies []Dependency
+}
+
+// parsers maps the manifests Willow understands to their parsers. Lock files
+// list every transitive dependency, so where they say which dependencies are
+// direct, only those are returned.
+var parsers = map[string]func([]byte) ([]Dependency, error){
+	"go.mod":            parseGoMod,
+	"package.json":      parsePackageJSON,
+	"package-lock.json": parsePackageLock,
+	"Cargo.lock":        parseCargoLock,
+	"requirements.txt":  parseRequirements,
+	"poetry.lock":       parsePoetryLock,
+	"Gemfile.lock":      parseGemfileLock,
+}
+
+// Supported returns the names of the manifests Parse understands. SBOMs and
+// Kubernetes manifests are recognised by their contents whatever they're
+// called.
+func Supported() []string {
+	return []string{"go.mod", "package.json", "package-lock.json", "Cargo.lock", "requirements.txt", "poetry.lock", "Gemfile.lock", "compose.yaml", "docker-compose.yml"}
+}
+
+// Parse returns the dependencies in a manifest or SBOM, recognising manifests
+// from their file name and SBOMs and Kubernetes manifests from their contents
+func Parse(filename string, data []byte) (Document, error) {
+	base := filepath.Base(filename)
+	parse, ok := parsers[base]
+	if !ok {
+		// requirements files are often split up, like requirements-dev.txt
+		if strings.HasPrefix(base, "requirements") && strings.HasSuffix(base, ".txt") {
+			parse = parseRequirements
+		} else if isCompose(base) {
+			parse = parseCompose
+		} else if isKubernetes(base, data) {
+			parse = parseKubernetes
+		} else if format := sbomFormat(data); format != nil {
+			doc, err := format(data)
+			if err != nil {
+				return Document{}, fmt.Errorf("parsing SBOM %s: %w", base, err)
+			}
+			if doc.Name == "" {
+				doc.Name = base
+			}
+			return doc, nil
+		} else {
+			return Document{}, fmt.Errorf("unsupported manifest %q, must be an SBOM, Kubernetes manifest, or one of %s", base, strings.Join(Supported(), ", "))
+		}
+	}
+
+	deps, err := parse(data)
+	if err != nil {
+		return Document{}, fmt.Errorf("parsing %s: %w", base, err)
+	}
+	return Document{Name: base, Dependencies: deps}, nil
+}
@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		filename string
+		data     string
+		want     []Dependency
+	}{
+		{"go.mod", `module example.org/app
+
+go 1.21
+
+require (
+	github.com/BurntSushi/toml v1.3.2
+	golang.org/x/term v0.17.0 // indirect
+)
+
+require git.sr.ht/~amolith/willow v0.0.0-20240101000000-abcdefabcdef
+`, []Dependency{
+			{Ecosystem: EcosystemGo, Name: "github.com/BurntSushi/toml", Version: "v1.3.2"},
+			{Ecosystem: EcosystemGo, Name: "git.sr.ht/~amolith/willow", Version: "v0.0.0-20240101000000-abcdefabcdef"},
+		}},
+		{"package.json", `{
+	"dependencies": {"react": "^18.2.0", "local": "file:../local"},
+	"devDependencies": {"typescript": "~5.3.3"}
+}`, []Dependency{
+			{Ecosystem: EcosystemNPM, Name: "local", Version: ""},
+			{Ecosystem: EcosystemNPM, Name: "react", Version: "18.2.0"},
+			{Ecosystem: EcosystemNPM, Name: "typescript", Version: "5.3.3"},
+		}},
+		{"package-lock.json", `{
+	"lockfileVersion": 3,
+	"packages": {
+		"": {"dependencies": {"react": "^18.2.0"}, "devDependencies": {"@types/node": "^20.0.0"}},
+		"node_modules/react": {"version": "18.2.0"},
+		"node_modules/loose-envify": {"version": "1.4.0"},
+		"node_modules/@types/node": {"version": "20.11.5"}
+	}
+}`, []Dependency{
+			{Ecosystem: EcosystemNPM, Name: "@types/node", Version: "20.11.5"},
+			{Ecosystem: EcosystemNPM, Name: "react", Version: "18.2.0"},
+		}},
+		{"Cargo.lock", `version = 3
+
+[[package]]
+name = "app"
+version = "0.1.0"
+dependencies = [
+ "serde",
+ "rand 0.8.5",
+ "helper",
+]
+
+[[package]]
+name = "helper"
+version = "0.1.0"
+
+[[package]]
+name = "serde"
+version = "1.0.196"
+source = "registry+https://github.com/rust-lang/crates.io-index"
+
+[[package]]
+name = "rand"
+version = "0.7.3"
+source = "registry+https://github.com/rust-lang/crates.io-index"
+
+[[package]]
+name = "rand"
+version = "0.8.5"
+source = "registry+https://github.com/rust-lang/crates.io-index"
+`, []Dependency{
+			{Ecosystem: EcosystemCargo, Name: "rand", Version: "0.8.5"},
+			{Ecosystem: EcosystemCargo, Name: "serde", Version: "1.0.196"},
+		}},
+		{"requirements-dev.txt", `# tools
+-r requirements.txt
+requests[socks]==2.31.0 ; python_version >= "3.8"
+flask>=2.0
+black === 24.1.1
+git+https://github.com/example/thing.git
+`, []Dependency{
+			{Ecosystem: EcosystemPyPI, Name: "requests", Version: "2.31.0"},
+			{Ecosystem: EcosystemPyPI, Name: "flask", Version: ""},
+			{Ecosystem: EcosystemPyPI, Name: "black", Version: "24.1.1"},
+		}},
+		{"poetry.lock", `[[package]]
+name = "certifi"
+version = "2024.2.2"
+
+[[package]]
+name = "idna"
+version = "3.6"
+`, []Dependency{
+			{Ecosystem: EcosystemPyPI, Name: "certifi", Version: "2024.2.2"},
+			{Ecosystem: EcosystemPyPI, Name: "idna", Version: "3.6"},
+		}},
+		{"Gemfile.lock", `GEM
+  remote: https://rubygems.org/
+  specs:
+    nokogiri (1.16.2-x86_64-linux)
+      racc (~> 1.4)
+    racc (1.7.3)
+    rails (7.1.3)
+
+PLATFORMS
+  x86_64-linux
+
+DEPENDENCIES
+  nokogiri
+  rails (~> 7.1)
+
+BUNDLED WITH
+   2.5.5
+`, []Dependency{
+			{Ecosystem: EcosystemRubyGems, Name: "nokogiri", Version: "1.16.2"},
+			{Ecosystem: EcosystemRubyGems, Name: "rails", Version: "7.1.3"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			doc, err := Parse("/tmp/"+tt.filename, []byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc.Name != tt.filename {
+				t.Errorf("Parse() named the document %q, want %q", doc.Name, tt.filename)
+			}
+			if !reflect.DeepEqual(doc.Dependencies, tt.want) {
+				t.Errorf("Parse() = %v, want %v", doc.Dependencies, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUnsupported(t *testing.T) {
+	if _, err := Parse("pom.xml", []byte("<project/>")); err == nil {
+		t.Error("Parse() accepted an unsupported manifest")
+	}
+}
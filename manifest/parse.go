@@ -0,0 +1,264 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/mod/modfile"
+)
+
+// parseGoMod returns the modules go.mod requires directly
+func parseGoMod(data []byte) ([]Dependency, error) {
+	f, err := modfile.ParseLax("go.mod", data, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var deps []Dependency
+	for _, r := range f.Require {
+		if r.Indirect {
+			continue
+		}
+		deps = append(deps, Dependency{Ecosystem: EcosystemGo, Name: r.Mod.Path, Version: r.Mod.Version})
+	}
+	return deps, nil
+}
+
+// parsePackageJSON returns the dependencies and dev dependencies in
+// package.json. It doesn't lock versions, so the lowest version each range
+// allows is used.
+func parsePackageJSON(data []byte) ([]Dependency, error) {
+	var pkg struct {
+		Dependencies    map[string]string `json:"dependencies"`
+		DevDependencies map[string]string `json:"devDependencies"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil, err
+	}
+
+	var deps []Dependency
+	for _, m := range []map[string]string{pkg.Dependencies, pkg.DevDependencies} {
+		for name, version := range m {
+			deps = append(deps, Dependency{Ecosystem: EcosystemNPM, Name: name, Version: lowestVersion(version)})
+		}
+	}
+	return sortDeps(deps), nil
+}
+
+// parsePackageLock returns the direct dependencies in package-lock.json and
+// the versions they're locked to. Version 1 lock files don't say which are
+// direct, so every top-level dependency is returned for those.
+func parsePackageLock(data []byte) ([]Dependency, error) {
+	var lock struct {
+		LockfileVersion int `json:"lockfileVersion"`
+		Packages        map[string]struct {
+			Version         string            `json:"version"`
+			Dependencies    map[string]string `json:"dependencies"`
+			DevDependencies map[string]string `json:"devDependencies"`
+		} `json:"packages"`
+		Dependencies map[string]struct {
+			Version string `json:"version"`
+		} `json:"dependencies"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, err
+	}
+
+	var deps []Dependency
+	if root, ok := lock.Packages[""]; ok {
+		for _, m := range []map[string]string{root.Dependencies, root.DevDependencies} {
+			for name := range m {
+				deps = append(deps, Dependency{Ecosystem: EcosystemNPM, Name: name, Version: lock.Packages["node_modules/"+name].Version})
+			}
+		}
+		return sortDeps(deps), nil
+	}
+
+	for name, dep := range lock.Dependencies {
+		deps = append(deps, Dependency{Ecosystem: EcosystemNPM, Name: name, Version: dep.Version})
+	}
+	return sortDeps(deps), nil
+}
+
+// parseCargoLock returns the crates the workspace's own packages depend on
+// directly and the versions they're locked to
+func parseCargoLock(data []byte) ([]Dependency, error) {
+	var lock struct {
+		Package []struct {
+			Name         string   `toml:"name"`
+			Version      string   `toml:"version"`
+			Source       string   `toml:"source"`
+			Dependencies []string `toml:"dependencies"`
+		} `toml:"package"`
+	}
+	if err := toml.Unmarshal(data, &lock); err != nil {
+		return nil, err
+	}
+
+	// Packages without a source belong to the workspace
+	locked := make(map[string]string)
+	var direct []string
+	for _, p := range lock.Package {
+		if p.Source == "" {
+			direct = append(direct, p.Dependencies...)
+			continue
+		}
+		locked[p.Name] = p.Version
+	}
+
+	seen := make(map[string]bool)
+	var deps []Dependency
+	for _, d := range direct {
+		// Entries are "name" or, when several versions are locked,
+		// "name version" or "name version (source)"
+		fields := strings.Fields(d)
+		name := fields[0]
+		version, ok := locked[name]
+		if !ok {
+			// Another workspace member
+			continue
+		}
+		if len(fields) > 1 {
+			version = fields[1]
+		}
+		if seen[name+" "+version] {
+			continue
+		}
+		seen[name+" "+version] = true
+		deps = append(deps, Dependency{Ecosystem: EcosystemCargo, Name: name, Version: version})
+	}
+	return sortDeps(deps), nil
+}
+
+// parseRequirements returns the packages in a pip requirements file. Only
+// those pinned with == have a version; options like -r and -e are skipped.
+func parseRequirements(data []byte) ([]Dependency, error) {
+	var deps []Dependency
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line, _, _ = strings.Cut(line, ";")
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "-") || strings.Contains(line, "://") {
+			continue
+		}
+
+		name, version := line, ""
+		if i := strings.IndexAny(line, "=<>!~ "); i >= 0 {
+			name = line[:i]
+			spec := strings.TrimSpace(line[i:])
+			// == and === both pin a version
+			if strings.HasPrefix(spec, "==") {
+				pinned, _, _ := strings.Cut(strings.TrimLeft(spec, "="), ",")
+				version = strings.TrimSpace(pinned)
+			}
+		}
+		name, _, _ = strings.Cut(name, "[")
+		deps = append(deps, Dependency{Ecosystem: EcosystemPyPI, Name: strings.TrimSpace(name), Version: version})
+	}
+	return deps, scanner.Err()
+}
+
+// parsePoetryLock returns every package in poetry.lock and its locked
+// version. The lock file doesn't say which are direct dependencies.
+func parsePoetryLock(data []byte) ([]Dependency, error) {
+	var lock struct {
+		Package []struct {
+			Name    string `toml:"name"`
+			Version string `toml:"version"`
+		} `toml:"package"`
+	}
+	if err := toml.Unmarshal(data, &lock); err != nil {
+		return nil, err
+	}
+
+	deps := make([]Dependency, 0, len(lock.Package))
+	for _, p := range lock.Package {
+		deps = append(deps, Dependency{Ecosystem: EcosystemPyPI, Name: p.Name, Version: p.Version})
+	}
+	return deps, nil
+}
+
+// parseGemfileLock returns the gems listed under DEPENDENCIES in
+// Gemfile.lock with the versions locked under GEM
+func parseGemfileLock(data []byte) ([]Dependency, error) {
+	locked := make(map[string]string)
+	var direct []string
+
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" && line[0] != ' ' {
+			section = strings.TrimSpace(line)
+			continue
+		}
+
+		switch section {
+		case "GEM":
+			// Gems are indented by four spaces and their own dependencies
+			// by six
+			if !strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "      ") {
+				continue
+			}
+			name, version, ok := strings.Cut(strings.TrimSpace(line), " (")
+			if !ok {
+				continue
+			}
+			version = strings.TrimSuffix(version, ")")
+			// Drop platforms, as in nokogiri (1.16.2-x86_64-linux)
+			version, _, _ = strings.Cut(version, "-")
+			if _, ok := locked[name]; !ok {
+				locked[name] = version
+			}
+		case "DEPENDENCIES":
+			fields := strings.Fields(line)
+			if len(fields) > 0 {
+				direct = append(direct, strings.TrimSuffix(fields[0], "!"))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	deps := make([]Dependency, 0, len(direct))
+	for _, name := range direct {
+		deps = append(deps, Dependency{Ecosystem: EcosystemRubyGems, Name: name, Version: locked[name]})
+	}
+	return deps, nil
+}
+
+// lowestVersion returns the version an npm range like ^1.2.3 or >=2.0.0 <3
+// starts at, or an empty string for tags, URLs, and other things that aren't
+// versions
+func lowestVersion(versionRange string) string {
+	fields := strings.Fields(versionRange)
+	if len(fields) == 0 {
+		return ""
+	}
+	version := strings.TrimLeft(fields[0], "^~=>v")
+	if version == "" || version[0] < '0' || version[0] > '9' {
+		return ""
+	}
+	return version
+}
+
+// sortDeps sorts dependencies by name so results from maps are stable
+func sortDeps(deps []Dependency) []Dependency {
+	sort.Slice(deps, func(i, j int) bool {
+		if deps[i].Name == deps[j].Name {
+			return deps[i].Version < deps[j].Version
+		}
+		return deps[i].Name < deps[j].Name
+	})
+	return deps
+}
@@ -0,0 +1,326 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"git.sr.ht/~amolith/willow/oci"
+)
+
+// ErrNoRepo is returned when a dependency's registry doesn't say where its
+// source code is
+var ErrNoRepo = errors.New("registry doesn't link to a source repo")
+
+// Source is where a dependency's releases can be tracked, in the form
+// project.Track takes
+type Source struct {
+	Name  string
+	URL   string
+	Forge string
+	// Version is the version to track when the source tags releases
+	// differently from the dependency, like an image's upstream repo
+	Version string
+}
+
+// Resolver looks up where dependencies' source code lives using their
+// registries' APIs
+type Resolver struct {
+	Client *http.Client
+	// Upstream tracks the source repo container images link to with their
+	// org.opencontainers.image.source label instead of the images themselves
+	Upstream bool
+}
+
+// userAgent identifies Willow to registries; crates.io refuses requests
+// without one
+const userAgent = "willow (+https://sr.ht/~amolith/willow)"
+
+// forges maps hosts to Willow's forge types. Repos on them have owner/name
+// paths, so anything after that, like /tree/main, is dropped.
+var forges = map[string]string{
+	"github.com":    "github",
+	"codeberg.org":  "forgejo",
+	"gitlab.com":    "gitlab",
+	"git.sr.ht":     "sourcehut",
+	"bitbucket.org": "bitbucket",
+}
+
+// Resolve returns where the dependency's releases can be tracked, trusting
+// the repo the document gave for it over its registry
+func (r Resolver) Resolve(dep Dependency) (Source, error) {
+	if repoURL, forge, ok := normaliseRepo(dep.Repo); ok {
+		return Source{Name: dep.Name, URL: repoURL, Forge: forge}, nil
+	}
+
+	var repo string
+	var err error
+	switch dep.Ecosystem {
+	case EcosystemGo:
+		repo, err = r.goRepo(dep.Name)
+	case EcosystemNPM:
+		repo, err = r.npmRepo(dep.Name)
+	case EcosystemCargo:
+		repo, err = r.cratesRepo(dep.Name)
+	case EcosystemPyPI:
+		repo, err = r.pypiRepo(dep.Name)
+	case EcosystemRubyGems:
+		repo, err = r.rubygemsRepo(dep.Name)
+	case EcosystemContainer:
+		return r.containerSource(dep)
+	case "":
+		return Source{}, ErrNoRepo
+	default:
+		return Source{}, fmt.Errorf("%s packages aren't supported", dep.Ecosystem)
+	}
+	if err != nil {
+		return Source{}, err
+	}
+
+	repoURL, forge, ok := normaliseRepo(repo)
+	if !ok {
+		return Source{}, ErrNoRepo
+	}
+	return Source{Name: dep.Name, URL: repoURL, Forge: forge}, nil
+}
+
+// containerSource tracks the image in its registry, or with Upstream set, the
+// repo its source label points to when that's on a known forge. Images
+// without a usable label fall back to the registry.
+func (r Resolver) containerSource(dep Dependency) (Source, error) {
+	ref, err := oci.ParseReference(dep.Name)
+	if err != nil {
+		return Source{}, err
+	}
+	ref.Tag = dep.Version
+	image := Source{Name: ref.ShortName(), URL: ref.URL(), Forge: "container"}
+	if !r.Upstream {
+		return image, nil
+	}
+
+	labels, err := oci.Client{HTTP: r.Client}.Labels(ref)
+	if err != nil {
+		return image, nil
+	}
+	repoURL, forge, ok := normaliseRepo(labels[oci.SourceLabel])
+	if !ok || forge == "other" {
+		return image, nil
+	}
+	// Variants like -alpine mean nothing upstream
+	version, _, _ := oci.SplitTag(dep.Version)
+	name := repoURL[strings.LastIndex(repoURL, "/")+1:]
+	return Source{Name: name, URL: repoURL, Forge: forge, Version: version}, nil
+}
+
+// goRepo returns the repo of a Go module, asking the module's host with
+// ?go-get=1 unless it's on a forge whose paths map directly to repos
+func (r Resolver) goRepo(module string) (string, error) {
+	host, _, _ := strings.Cut(module, "/")
+	if _, ok := forges[host]; ok {
+		return "https://" + module, nil
+	}
+
+	body, err := r.get("https://" + module + "?go-get=1")
+	if err != nil {
+		return "", err
+	}
+	for _, m := range goImport.FindAllSubmatch(body, -1) {
+		fields := strings.Fields(string(m[1]))
+		if len(fields) == 3 && fields[1] == "git" && (module == fields[0] || strings.HasPrefix(module, fields[0]+"/")) {
+			return fields[2], nil
+		}
+	}
+	return "", ErrNoRepo
+}
+
+var goImport = regexp.MustCompile(`<meta\s+name=["']go-import["']\s+content=["']([^"']+)["']`)
+
+// npmRepo returns the repository field of an npm package's latest version
+func (r Resolver) npmRepo(name string) (string, error) {
+	body, err := r.get("https://registry.npmjs.org/" + url.PathEscape(name) + "/latest")
+	if err != nil {
+		return "", err
+	}
+	var pkg struct {
+		Repository json.RawMessage `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &pkg); err != nil {
+		return "", err
+	}
+
+	// repository is either a URL or an object with one
+	var repo string
+	if json.Unmarshal(pkg.Repository, &repo) == nil {
+		return repo, nil
+	}
+	var obj struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(pkg.Repository, &obj); err != nil {
+		return "", ErrNoRepo
+	}
+	return obj.URL, nil
+}
+
+// cratesRepo returns the repository of a crate on crates.io
+func (r Resolver) cratesRepo(name string) (string, error) {
+	body, err := r.get("https://crates.io/api/v1/crates/" + url.PathEscape(name))
+	if err != nil {
+		return "", err
+	}
+	var crate struct {
+		Crate struct {
+			Repository string `json:"repository"`
+		} `json:"crate"`
+	}
+	if err := json.Unmarshal(body, &crate); err != nil {
+		return "", err
+	}
+	return crate.Crate.Repository, nil
+}
+
+// pypiRepo returns the source link of a package on PyPI, preferring links to
+// known forges because projects label them inconsistently
+func (r Resolver) pypiRepo(name string) (string, error) {
+	body, err := r.get("https://pypi.org/pypi/" + url.PathEscape(name) + "/json")
+	if err != nil {
+		return "", err
+	}
+	var pkg struct {
+		Info struct {
+			HomePage    string            `json:"home_page"`
+			ProjectURLs map[string]string `json:"project_urls"`
+		} `json:"info"`
+	}
+	if err := json.Unmarshal(body, &pkg); err != nil {
+		return "", err
+	}
+
+	links := []string{}
+	for _, label := range []string{"Source", "Source Code", "Repository", "Code", "GitHub", "Homepage"} {
+		if link, ok := pkg.Info.ProjectURLs[label]; ok {
+			links = append(links, link)
+		}
+	}
+	for _, link := range pkg.Info.ProjectURLs {
+		links = append(links, link)
+	}
+	links = append(links, pkg.Info.HomePage)
+	return preferForge(links), nil
+}
+
+// rubygemsRepo returns the source code or home page link of a gem
+func (r Resolver) rubygemsRepo(name string) (string, error) {
+	body, err := r.get("https://rubygems.org/api/v1/gems/" + url.PathEscape(name) + ".json")
+	if err != nil {
+		return "", err
+	}
+	var gem struct {
+		SourceCodeURI string `json:"source_code_uri"`
+		HomepageURI   string `json:"homepage_uri"`
+	}
+	if err := json.Unmarshal(body, &gem); err != nil {
+		return "", err
+	}
+	return preferForge([]string{gem.SourceCodeURI, gem.HomepageURI}), nil
+}
+
+// get fetches a URL and returns its body
+func (r Resolver) get(link string) ([]byte, error) {
+	client := r.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("not found in registry")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+}
+
+// preferForge returns the first link to a known forge, or else the first
+// link that isn't empty
+func preferForge(links []string) string {
+	for _, link := range links {
+		if _, forge, ok := normaliseRepo(link); ok && forge != "other" {
+			return link
+		}
+	}
+	for _, link := range links {
+		if link != "" {
+			return link
+		}
+	}
+	return ""
+}
+
+// normaliseRepo turns the many ways registries write repo URLs, like
+// git+https://github.com/foo/bar.git#readme or git@github.com:foo/bar, into a
+// plain https URL and works out its forge
+func normaliseRepo(repo string) (string, string, bool) {
+	repo = strings.TrimSpace(repo)
+	repo = strings.TrimPrefix(repo, "git+")
+	if user, rest, ok := strings.Cut(repo, "@"); ok && !strings.Contains(user, "/") && !strings.Contains(user, ":") {
+		// scp-like git@host:owner/name
+		repo = "https://" + strings.Replace(rest, ":", "/", 1)
+	}
+	if strings.HasPrefix(repo, "github:") {
+		repo = "https://github.com/" + strings.TrimPrefix(repo, "github:")
+	}
+
+	u, err := url.Parse(repo)
+	if err != nil || u.Host == "" {
+		return "", "", false
+	}
+	switch u.Scheme {
+	case "https", "http", "git", "ssh", "git+ssh":
+	default:
+		return "", "", false
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	path := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	forge, known := forges[host]
+	if known {
+		parts := strings.Split(path, "/")
+		// GitLab allows subgroups, so only cut off its /-/ pages
+		if forge == "gitlab" {
+			path, _, _ = strings.Cut(path, "/-/")
+		} else if len(parts) > 2 {
+			path = strings.Join(parts[:2], "/")
+		}
+		if strings.Count(path, "/") < 1 {
+			return "", "", false
+		}
+	} else {
+		forge = "other"
+	}
+	if path == "" {
+		return "", "", false
+	}
+	return "https://" + host + "/" + path, forge, true
+}
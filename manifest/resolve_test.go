@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// redirect sends every request to the test server, keeping the original host
+// in the path so one handler can stand in for every registry
+type redirect struct {
+	server *url.URL
+}
+
+func (rt redirect) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Path = "/" + r.URL.Host + r.URL.Path
+	r.URL.Scheme = rt.server.Scheme
+	r.URL.Host = rt.server.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestResolve(t *testing.T) {
+	responses := map[string]string{
+		"/golang.org/x/mod":                        `<html><head><meta name="go-import" content="golang.org/x/mod git https://go.googlesource.com/mod"></head></html>`,
+		"/registry.npmjs.org/@scope/pkg/latest":    `{"repository": {"type": "git", "url": "git+https://github.com/scope/pkg.git"}}`,
+		"/registry.npmjs.org/left-pad/latest":      `{"repository": "git@github.com:left-pad/left-pad.git"}`,
+		"/crates.io/api/v1/crates/serde":           `{"crate": {"repository": "https://github.com/serde-rs/serde"}}`,
+		"/pypi.org/pypi/requests/json":             `{"info": {"home_page": "https://requests.readthedocs.io", "project_urls": {"Documentation": "https://requests.readthedocs.io", "Source": "https://github.com/psf/requests/tree/main"}}}`,
+		"/rubygems.org/api/v1/gems/rails.json":     `{"source_code_uri": "https://github.com/rails/rails/tree/v7.1.3", "homepage_uri": "https://rubyonrails.org"}`,
+		"/rubygems.org/api/v1/gems/nothing.json":   `{"source_code_uri": null, "homepage_uri": ""}`,
+		"/crates.io/api/v1/crates/gitlab-subgroup": `{"crate": {"repository": "https://gitlab.com/group/subgroup/project/-/tree/main"}}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") == "" {
+			t.Errorf("request to %s has no User-Agent", r.URL.Path)
+		}
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	resolver := Resolver{Client: &http.Client{Transport: redirect{serverURL}}}
+
+	tests := []struct {
+		dep   Dependency
+		url   string
+		forge string
+	}{
+		{Dependency{Ecosystem: EcosystemGo, Name: "github.com/BurntSushi/toml", Version: "v1.3.2"}, "https://github.com/BurntSushi/toml", "github"},
+		{Dependency{Ecosystem: EcosystemGo, Name: "github.com/go-webauthn/webauthn/v2", Version: "v2.0.0"}, "https://github.com/go-webauthn/webauthn", "github"},
+		{Dependency{Ecosystem: EcosystemGo, Name: "golang.org/x/mod", Version: "v0.15.0"}, "https://go.googlesource.com/mod", "other"},
+		{Dependency{Ecosystem: EcosystemNPM, Name: "@scope/pkg", Version: "1.0.0"}, "https://github.com/scope/pkg", "github"},
+		{Dependency{Ecosystem: EcosystemNPM, Name: "left-pad", Version: "1.3.0"}, "https://github.com/left-pad/left-pad", "github"},
+		{Dependency{Ecosystem: EcosystemCargo, Name: "serde", Version: "1.0.196"}, "https://github.com/serde-rs/serde", "github"},
+		{Dependency{Ecosystem: EcosystemCargo, Name: "gitlab-subgroup", Version: "1.0.0"}, "https://gitlab.com/group/subgroup/project", "gitlab"},
+		{Dependency{Ecosystem: EcosystemPyPI, Name: "requests", Version: "2.31.0"}, "https://github.com/psf/requests", "github"},
+		{Dependency{Ecosystem: EcosystemRubyGems, Name: "rails", Version: "7.1.3"}, "https://github.com/rails/rails", "github"},
+	}
+	for _, tt := range tests {
+		got, err := resolver.Resolve(tt.dep)
+		if err != nil {
+			t.Errorf("Resolve(%s) returned error: %v", tt.dep.Name, err)
+			continue
+		}
+		if got.URL != tt.url || got.Forge != tt.forge || got.Name != tt.dep.Name {
+			t.Errorf("Resolve(%s) = %+v, want %s on %s", tt.dep.Name, got, tt.url, tt.forge)
+		}
+	}
+
+	if _, err := resolver.Resolve(Dependency{Ecosystem: EcosystemRubyGems, Name: "nothing", Version: "1.0.0"}); err != ErrNoRepo {
+		t.Errorf("Resolve() of a gem without links returned %v, want ErrNoRepo", err)
+	}
+	if _, err := resolver.Resolve(Dependency{Ecosystem: EcosystemPyPI, Name: "missing", Version: "1.0.0"}); err == nil {
+		t.Error("Resolve() of a package missing from the registry succeeded")
+	}
+}
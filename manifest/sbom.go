@@ -0,0 +1,348 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/url"
+	"strings"
+)
+
+// sbomFormat recognises the CycloneDX and SPDX documents Willow understands
+// and returns their parser, or nil if the data isn't one of them
+func sbomFormat(data []byte) func([]byte) (Document, error) {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		var probe struct {
+			BOMFormat   string `json:"bomFormat"`
+			SPDXVersion string `json:"spdxVersion"`
+		}
+		if json.Unmarshal(trimmed, &probe) != nil {
+			return nil
+		}
+		if probe.BOMFormat == "CycloneDX" {
+			return parseCycloneDXJSON
+		}
+		if probe.SPDXVersion != "" {
+			return parseSPDXJSON
+		}
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		if bytes.Contains(trimmed, []byte("cyclonedx.org/schema/bom")) {
+			return parseCycloneDXXML
+		}
+	case bytes.HasPrefix(trimmed, []byte("SPDXVersion:")):
+		return parseSPDXTagValue
+	}
+	return nil
+}
+
+// cdxComponent is a CycloneDX component, in either of its encodings
+type cdxComponent struct {
+	Type               string         `json:"type" xml:"type,attr"`
+	Group              string         `json:"group" xml:"group"`
+	Name               string         `json:"name" xml:"name"`
+	Version            string         `json:"version" xml:"version"`
+	PURL               string         `json:"purl" xml:"purl"`
+	ExternalReferences []cdxReference `json:"externalReferences" xml:"externalReferences>reference"`
+	Components         []cdxComponent `json:"components" xml:"components>component"`
+}
+
+type cdxReference struct {
+	Type string `json:"type" xml:"type,attr"`
+	URL  string `json:"url" xml:"url"`
+}
+
+type cdxBOM struct {
+	Metadata struct {
+		Component cdxComponent `json:"component" xml:"component"`
+	} `json:"metadata" xml:"metadata"`
+	Components []cdxComponent `json:"components" xml:"components>component"`
+}
+
+func parseCycloneDXJSON(data []byte) (Document, error) {
+	var bom cdxBOM
+	if err := json.Unmarshal(data, &bom); err != nil {
+		return Document{}, err
+	}
+	return bom.document(), nil
+}
+
+func parseCycloneDXXML(data []byte) (Document, error) {
+	var bom cdxBOM
+	if err := xml.Unmarshal(data, &bom); err != nil {
+		return Document{}, err
+	}
+	return bom.document(), nil
+}
+
+// document flattens the BOM's component tree into dependencies. The component
+// in the metadata is the software the BOM describes, so it names the document
+// rather than being one of its dependencies.
+func (bom cdxBOM) document() Document {
+	doc := Document{Name: bom.Metadata.Component.fullName()}
+	var walk func([]cdxComponent)
+	walk = func(components []cdxComponent) {
+		for _, c := range components {
+			switch c.Type {
+			case "file", "operating-system", "device", "firmware", "data":
+			default:
+				vcs := ""
+				for _, ref := range c.ExternalReferences {
+					if ref.Type == "vcs" {
+						vcs = ref.URL
+						break
+					}
+				}
+				if dep, ok := sbomDependency(c.fullName(), c.Version, c.PURL, vcs); ok {
+					doc.Dependencies = append(doc.Dependencies, dep)
+				}
+			}
+			walk(c.Components)
+		}
+	}
+	walk(bom.Components)
+	return doc
+}
+
+// fullName returns the component's name with its group, like @scope/name
+func (c cdxComponent) fullName() string {
+	if c.Group == "" {
+		return c.Name
+	}
+	return c.Group + "/" + c.Name
+}
+
+// spdxPackage is the part of an SPDX package Willow reads
+type spdxPackage struct {
+	SPDXID           string            `json:"SPDXID"`
+	Name             string            `json:"name"`
+	VersionInfo      string            `json:"versionInfo"`
+	DownloadLocation string            `json:"downloadLocation"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+// purl returns the package's package URL, if it has one
+func (p spdxPackage) purl() string {
+	for _, ref := range p.ExternalRefs {
+		if ref.ReferenceType == "purl" {
+			return ref.ReferenceLocator
+		}
+	}
+	return ""
+}
+
+func parseSPDXJSON(data []byte) (Document, error) {
+	var spdx struct {
+		Name              string        `json:"name"`
+		DocumentDescribes []string      `json:"documentDescribes"`
+		Packages          []spdxPackage `json:"packages"`
+		Relationships     []struct {
+			SPDXElementID      string `json:"spdxElementId"`
+			RelationshipType   string `json:"relationshipType"`
+			RelatedSPDXElement string `json:"relatedSpdxElement"`
+		} `json:"relationships"`
+	}
+	if err := json.Unmarshal(data, &spdx); err != nil {
+		return Document{}, err
+	}
+
+	described := make(map[string]bool)
+	for _, id := range spdx.DocumentDescribes {
+		described[id] = true
+	}
+	for _, r := range spdx.Relationships {
+		if r.SPDXElementID == "SPDXRef-DOCUMENT" && r.RelationshipType == "DESCRIBES" {
+			described[r.RelatedSPDXElement] = true
+		}
+	}
+	return spdxDocument(spdx.Name, spdx.Packages, described), nil
+}
+
+// parseSPDXTagValue reads the single-line fields of an SPDX document in its
+// tag-value format, which is all Willow needs
+func parseSPDXTagValue(data []byte) (Document, error) {
+	var name string
+	var packages []spdxPackage
+	described := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		tag, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if tag == "PackageName" {
+			packages = append(packages, spdxPackage{Name: value})
+			continue
+		}
+
+		switch tag {
+		case "DocumentName":
+			name = value
+		case "Relationship":
+			fields := strings.Fields(value)
+			if len(fields) == 3 && fields[0] == "SPDXRef-DOCUMENT" && fields[1] == "DESCRIBES" {
+				described[fields[2]] = true
+			}
+		}
+		if len(packages) == 0 {
+			continue
+		}
+		p := &packages[len(packages)-1]
+		switch tag {
+		case "SPDXID":
+			p.SPDXID = value
+		case "PackageVersion":
+			p.VersionInfo = value
+		case "PackageDownloadLocation":
+			p.DownloadLocation = value
+		case "ExternalRef":
+			fields := strings.Fields(value)
+			if len(fields) == 3 {
+				p.ExternalRefs = append(p.ExternalRefs, spdxExternalRef{fields[0], fields[1], fields[2]})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Document{}, err
+	}
+	return spdxDocument(name, packages, described), nil
+}
+
+// spdxDocument turns SPDX packages into dependencies, leaving out the
+// packages the document describes since those are the software itself
+func spdxDocument(name string, packages []spdxPackage, described map[string]bool) Document {
+	doc := Document{Name: name}
+	for _, p := range packages {
+		if described[p.SPDXID] {
+			continue
+		}
+		if dep, ok := sbomDependency(p.Name, p.VersionInfo, p.purl(), vcsLocation(p.DownloadLocation)); ok {
+			doc.Dependencies = append(doc.Dependencies, dep)
+		}
+	}
+	return doc
+}
+
+// vcsLocation returns the repo in an SPDX download location like
+// git+https://github.com/foo/bar@v1.0.0#sub/dir, or an empty string if it
+// points at something other than a git repo
+func vcsLocation(location string) string {
+	if !strings.HasPrefix(location, "git+") {
+		return ""
+	}
+	location, _, _ = strings.Cut(location, "#")
+	if at := strings.LastIndex(location, "@"); at > strings.LastIndex(location, "/") {
+		location = location[:at]
+	}
+	return location
+}
+
+// sbomDependency builds a dependency from a component's package URL and VCS
+// reference. Components with neither can't be tracked and are left out.
+func sbomDependency(name, version, purl, vcs string) (Dependency, bool) {
+	dep := Dependency{Name: name, Version: version, Repo: vcs}
+	if p, ok := parsePurl(purl); ok {
+		if dep.Version == "" {
+			dep.Version = p.version
+		}
+		switch p.kind {
+		case "golang":
+			dep.Ecosystem, dep.Name = EcosystemGo, p.path()
+		case "npm":
+			dep.Ecosystem, dep.Name = EcosystemNPM, p.path()
+		case "cargo":
+			dep.Ecosystem, dep.Name = EcosystemCargo, p.name
+		case "pypi":
+			dep.Ecosystem, dep.Name = EcosystemPyPI, p.name
+		case "gem":
+			dep.Ecosystem, dep.Name = EcosystemRubyGems, p.name
+		case "github", "gitlab", "bitbucket":
+			if dep.Repo == "" {
+				dep.Repo = "https://" + purlHosts[p.kind] + "/" + p.path()
+			}
+		default:
+			if dep.Repo == "" {
+				// Kept so it's reported as skipped rather than vanishing
+				dep.Ecosystem, dep.Name = p.kind, p.path()
+			}
+		}
+	}
+	if dep.Ecosystem == "" && dep.Repo == "" {
+		return Dependency{}, false
+	}
+	if dep.Name == "" {
+		dep.Name = dep.Repo
+	}
+	return dep, true
+}
+
+// purlHosts maps the package URL types for repos to their forges' hosts
+var purlHosts = map[string]string{
+	"github":    "github.com",
+	"gitlab":    "gitlab.com",
+	"bitbucket": "bitbucket.org",
+}
+
+// purl is the part of a package URL, pkg:type/namespace/name@version,
+// Willow uses
+type purl struct {
+	kind      string
+	namespace string
+	name      string
+	version   string
+}
+
+// path returns the namespace and name, like @scope/name for npm or the module
+// path for Go
+func (p purl) path() string {
+	if p.namespace == "" {
+		return p.name
+	}
+	return p.namespace + "/" + p.name
+}
+
+// parsePurl parses a package URL as described in
+// https://github.com/package-url/purl-spec
+func parsePurl(s string) (purl, bool) {
+	rest, ok := strings.CutPrefix(s, "pkg:")
+	if !ok {
+		return purl{}, false
+	}
+	rest, _, _ = strings.Cut(rest, "#")
+	rest, _, _ = strings.Cut(rest, "?")
+	rest = strings.TrimLeft(rest, "/")
+
+	var p purl
+	if at := strings.LastIndex(rest, "@"); at > strings.LastIndex(rest, "/") {
+		p.version, _ = url.PathUnescape(rest[at+1:])
+		rest = rest[:at]
+	}
+	kind, path, ok := strings.Cut(rest, "/")
+	if !ok || path == "" {
+		return purl{}, false
+	}
+	p.kind = strings.ToLower(kind)
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i], _ = url.PathUnescape(segment)
+	}
+	p.name = segments[len(segments)-1]
+	p.namespace = strings.Join(segments[:len(segments)-1], "/")
+	return p, p.name != ""
+}
@@ -0,0 +1,165 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package manifest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSBOM(t *testing.T) {
+	want := []Dependency{
+		{Ecosystem: EcosystemGo, Name: "github.com/BurntSushi/toml", Version: "v1.3.2"},
+		{Ecosystem: EcosystemNPM, Name: "@scope/pkg", Version: "1.0.0"},
+		{Name: "libfoo", Version: "2.1.0", Repo: "https://github.com/example/libfoo"},
+		{Ecosystem: "maven", Name: "org.apache.commons/commons-lang3", Version: "3.14.0"},
+	}
+
+	tests := []struct {
+		filename string
+		data     string
+		name     string
+	}{
+		{"bom.json", `{
+	"bomFormat": "CycloneDX",
+	"specVersion": "1.5",
+	"metadata": {"component": {"type": "application", "name": "app", "purl": "pkg:golang/example.org/app@v1.0.0"}},
+	"components": [
+		{"type": "library", "name": "github.com/BurntSushi/toml", "version": "v1.3.2", "purl": "pkg:golang/github.com/BurntSushi/toml@v1.3.2"},
+		{"type": "library", "group": "@scope", "name": "pkg", "version": "1.0.0", "purl": "pkg:npm/%40scope/pkg@1.0.0", "components": [
+			{"type": "library", "name": "libfoo", "version": "2.1.0", "externalReferences": [{"type": "website", "url": "https://libfoo.example"}, {"type": "vcs", "url": "https://github.com/example/libfoo.git"}]}
+		]},
+		{"type": "library", "group": "org.apache.commons", "name": "commons-lang3", "version": "3.14.0", "purl": "pkg:maven/org.apache.commons/commons-lang3@3.14.0"},
+		{"type": "operating-system", "name": "debian", "version": "12"},
+		{"type": "library", "name": "vendored"}
+	]
+}`, "app"},
+		{"bom.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<bom xmlns="http://cyclonedx.org/schema/bom/1.5" version="1">
+  <metadata>
+    <component type="application"><name>app</name></component>
+  </metadata>
+  <components>
+    <component type="library">
+      <name>github.com/BurntSushi/toml</name>
+      <version>v1.3.2</version>
+      <purl>pkg:golang/github.com/BurntSushi/toml@v1.3.2</purl>
+    </component>
+    <component type="library">
+      <group>@scope</group>
+      <name>pkg</name>
+      <version>1.0.0</version>
+      <purl>pkg:npm/%40scope/pkg@1.0.0</purl>
+      <components>
+        <component type="library">
+          <name>libfoo</name>
+          <version>2.1.0</version>
+          <externalReferences><reference type="vcs"><url>git@github.com:example/libfoo.git</url></reference></externalReferences>
+        </component>
+      </components>
+    </component>
+    <component type="library">
+      <group>org.apache.commons</group>
+      <name>commons-lang3</name>
+      <version>3.14.0</version>
+      <purl>pkg:maven/org.apache.commons/commons-lang3@3.14.0</purl>
+    </component>
+  </components>
+</bom>`, "app"},
+		{"app.spdx.json", `{
+	"spdxVersion": "SPDX-2.3",
+	"SPDXID": "SPDXRef-DOCUMENT",
+	"name": "app",
+	"packages": [
+		{"SPDXID": "SPDXRef-app", "name": "app", "versionInfo": "1.0.0", "downloadLocation": "NOASSERTION"},
+		{"SPDXID": "SPDXRef-toml", "name": "toml", "versionInfo": "v1.3.2", "downloadLocation": "NOASSERTION",
+			"externalRefs": [{"referenceCategory": "PACKAGE-MANAGER", "referenceType": "purl", "referenceLocator": "pkg:golang/github.com/BurntSushi/toml@v1.3.2"}]},
+		{"SPDXID": "SPDXRef-pkg", "name": "@scope/pkg", "versionInfo": "1.0.0", "downloadLocation": "https://registry.npmjs.org/@scope/pkg/-/pkg-1.0.0.tgz",
+			"externalRefs": [{"referenceCategory": "PACKAGE_MANAGER", "referenceType": "purl", "referenceLocator": "pkg:npm/%40scope/pkg@1.0.0"}]},
+		{"SPDXID": "SPDXRef-libfoo", "name": "libfoo", "versionInfo": "2.1.0", "downloadLocation": "git+https://github.com/example/libfoo@v2.1.0#src"},
+		{"SPDXID": "SPDXRef-lang", "name": "commons-lang3", "versionInfo": "3.14.0", "downloadLocation": "NOASSERTION",
+			"externalRefs": [{"referenceCategory": "PACKAGE-MANAGER", "referenceType": "purl", "referenceLocator": "pkg:maven/org.apache.commons/commons-lang3@3.14.0"}]},
+		{"SPDXID": "SPDXRef-file", "name": "README", "downloadLocation": "NOASSERTION"}
+	],
+	"relationships": [{"spdxElementId": "SPDXRef-DOCUMENT", "relationshipType": "DESCRIBES", "relatedSpdxElement": "SPDXRef-app"}]
+}`, "app"},
+		{"sbom.spdx", `SPDXVersion: SPDX-2.3
+DataLicense: CC0-1.0
+SPDXID: SPDXRef-DOCUMENT
+DocumentName: app
+Relationship: SPDXRef-DOCUMENT DESCRIBES SPDXRef-app
+
+PackageName: app
+SPDXID: SPDXRef-app
+PackageDownloadLocation: NOASSERTION
+
+PackageName: toml
+SPDXID: SPDXRef-toml
+PackageVersion: v1.3.2
+ExternalRef: PACKAGE-MANAGER purl pkg:golang/github.com/BurntSushi/toml@v1.3.2
+
+PackageName: @scope/pkg
+SPDXID: SPDXRef-pkg
+PackageVersion: 1.0.0
+ExternalRef: PACKAGE-MANAGER purl pkg:npm/%40scope/pkg@1.0.0
+
+PackageName: libfoo
+SPDXID: SPDXRef-libfoo
+PackageVersion: 2.1.0
+PackageDownloadLocation: git+https://github.com/example/libfoo@v2.1.0
+
+PackageName: commons-lang3
+SPDXID: SPDXRef-lang
+PackageVersion: 3.14.0
+ExternalRef: PACKAGE-MANAGER purl pkg:maven/org.apache.commons/commons-lang3@3.14.0
+`, "app"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			doc, err := Parse(tt.filename, []byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc.Name != tt.name {
+				t.Errorf("Parse() named the document %q, want %q", doc.Name, tt.name)
+			}
+			// VCS references are normalised when resolving, not parsing
+			for i, dep := range doc.Dependencies {
+				if dep.Repo != "" {
+					source, err := Resolver{}.Resolve(dep)
+					if err != nil {
+						t.Fatal(err)
+					}
+					doc.Dependencies[i].Repo = source.URL
+				}
+			}
+			if !reflect.DeepEqual(doc.Dependencies, want) {
+				t.Errorf("Parse() = %+v, want %+v", doc.Dependencies, want)
+			}
+		})
+	}
+}
+
+func TestParsePurl(t *testing.T) {
+	tests := map[string]purl{
+		"pkg:golang/github.com/BurntSushi/toml@v1.3.2":     {kind: "golang", namespace: "github.com/BurntSushi", name: "toml", version: "v1.3.2"},
+		"pkg:npm/%40scope/pkg@1.0.0?arch=x64#lib":          {kind: "npm", namespace: "@scope", name: "pkg", version: "1.0.0"},
+		"pkg:npm/@scope/pkg":                               {kind: "npm", namespace: "@scope", name: "pkg"},
+		"pkg:PyPI/requests@2.31.0":                         {kind: "pypi", name: "requests", version: "2.31.0"},
+		"pkg:github/package-url/purl-spec@244fd47e07d1004": {kind: "github", namespace: "package-url", name: "purl-spec", version: "244fd47e07d1004"},
+	}
+	for s, want := range tests {
+		got, ok := parsePurl(s)
+		if !ok || got != want {
+			t.Errorf("parsePurl(%q) = %+v, %v; want %+v", s, got, ok, want)
+		}
+	}
+	for _, s := range []string{"", "npm/pkg@1.0.0", "pkg:npm"} {
+		if _, ok := parsePurl(s); ok {
+			t.Errorf("parsePurl(%q) succeeded", s)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Matrix posts release announcements to a Matrix room using the client-server
+// API and an access token belonging to the bot account.
+type Matrix struct {
+	Homeserver  string
+	AccessToken string
+	RoomID      string
+	Client      *http.Client
+}
+
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format"`
+	FormattedBody string `json:"formatted_body"`
+}
+
+// Notify sends an m.notice event describing the release to the configured room.
+func (m Matrix) Notify(release Release) error {
+	return m.send(message(release), matrixHTML(release))
+}
+
+// NotifyAdvisory sends an m.notice event describing the advisory to the
+// configured room.
+func (m Matrix) NotifyAdvisory(advisory Advisory) error {
+	return m.send(advisoryMessage(advisory), matrixAdvisoryHTML(advisory))
+}
+
+// send posts an m.notice event with the plain and HTML bodies
+func (m Matrix) send(plain, formatted string) error {
+	txnID, err := transactionID()
+	if err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimSuffix(m.Homeserver, "/") +
+		"/_matrix/client/v3/rooms/" + url.PathEscape(m.RoomID) +
+		"/send/m.room.message/" + txnID
+
+	body, err := json.Marshal(matrixMessage{
+		MsgType:       "m.notice",
+		Body:          plain,
+		Format:        "org.matrix.custom.html",
+		FormattedBody: formatted,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := m.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("matrix homeserver returned %s: %s", resp.Status, respBody)
+	}
+	return nil
+}
+
+// matrixHTML returns the HTML-formatted version of the notification.
+func matrixHTML(release Release) string {
+	var b strings.Builder
+	b.WriteString("<p><strong>" + html.EscapeString(release.Project) + "</strong> ")
+	if release.URL != "" {
+		b.WriteString(`<a href="` + html.EscapeString(release.URL) + `">` + html.EscapeString(release.Tag) + "</a>")
+	} else {
+		b.WriteString(html.EscapeString(release.Tag))
+	}
+	b.WriteString(" released</p>")
+
+	notes := PlainText(release.Content, maxNotesLength)
+	if notes != "" {
+		b.WriteString("<blockquote>" + strings.ReplaceAll(html.EscapeString(notes), "\n", "<br>") + "</blockquote>")
+	}
+	return b.String()
+}
+
+// matrixAdvisoryHTML returns the HTML-formatted version of the advisory
+// notification.
+func matrixAdvisoryHTML(advisory Advisory) string {
+	var b strings.Builder
+	b.WriteString("<p><strong>" + html.EscapeString(advisory.Project) + "</strong> ")
+	b.WriteString(html.EscapeString(strings.Join(advisory.Versions, ", ")) + " is affected by ")
+	if advisory.URL != "" {
+		b.WriteString(`<a href="` + html.EscapeString(advisory.URL) + `">` + html.EscapeString(advisory.ID) + "</a>")
+	} else {
+		b.WriteString(html.EscapeString(advisory.ID))
+	}
+	if advisory.Severity != "" {
+		b.WriteString(" (" + html.EscapeString(advisory.Severity) + ")")
+	}
+	b.WriteString("</p>")
+
+	summary := PlainText(advisory.Summary, maxNotesLength)
+	if summary != "" {
+		b.WriteString("<blockquote>" + html.EscapeString(summary) + "</blockquote>")
+	}
+	return b.String()
+}
+
+// transactionID generates a random ID so the homeserver can deduplicate
+// retried requests.
+func transactionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "willow-" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMatrixNotify(t *testing.T) {
+	var got matrixMessage
+	homeserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("method = %s, want PUT", r.Method)
+		}
+		if !strings.HasPrefix(r.URL.EscapedPath(), "/_matrix/client/v3/rooms/%21room:example.com/send/m.room.message/") {
+			t.Errorf("unexpected path %s", r.URL.EscapedPath())
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+			t.Errorf("Authorization = %q, want %q", auth, "Bearer secret")
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding body: %v", err)
+		}
+		_, _ = w.Write([]byte(`{"event_id":"$event"}`))
+	}))
+	defer homeserver.Close()
+
+	m := Matrix{
+		Homeserver:  homeserver.URL,
+		AccessToken: "secret",
+		RoomID:      "!room:example.com",
+	}
+	err := m.Notify(Release{
+		Project: "Willow",
+		Tag:     "v0.0.1",
+		URL:     "https://example.com/willow/v0.0.1",
+		Content: "<p>Fixed <em>everything</em></p>",
+	})
+	if err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	wantBody := "Willow v0.0.1 released: https://example.com/willow/v0.0.1\n\nFixed everything"
+	if got.Body != wantBody {
+		t.Errorf("body = %q, want %q", got.Body, wantBody)
+	}
+	if got.MsgType != "m.notice" {
+		t.Errorf("msgtype = %q, want m.notice", got.MsgType)
+	}
+	if !strings.Contains(got.FormattedBody, `<a href="https://example.com/willow/v0.0.1">v0.0.1</a>`) {
+		t.Errorf("formatted_body %q does not link to the release", got.FormattedBody)
+	}
+}
+
+func TestMatrixNotifyAdvisory(t *testing.T) {
+	var got matrixMessage
+	homeserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding body: %v", err)
+		}
+		_, _ = w.Write([]byte(`{"event_id":"$event"}`))
+	}))
+	defer homeserver.Close()
+
+	m := Matrix{Homeserver: homeserver.URL, AccessToken: "secret", RoomID: "!room:example.com"}
+	err := m.NotifyAdvisory(Advisory{
+		Project:  "Willow",
+		ID:       "GHSA-xxxx-xxxx-xxxx",
+		Summary:  "Cross-site scripting in <code>release notes</code>",
+		Severity: "high",
+		URL:      "https://example.com/advisories/GHSA-xxxx-xxxx-xxxx",
+		Versions: []string{"v0.0.1", "v0.0.2"},
+	})
+	if err != nil {
+		t.Fatalf("NotifyAdvisory returned error: %v", err)
+	}
+
+	wantBody := "Willow v0.0.1, v0.0.2 is affected by GHSA-xxxx-xxxx-xxxx (high): Cross-site scripting in release notes\n\nhttps://example.com/advisories/GHSA-xxxx-xxxx-xxxx"
+	if got.Body != wantBody {
+		t.Errorf("body = %q, want %q", got.Body, wantBody)
+	}
+	if !strings.Contains(got.FormattedBody, `<a href="https://example.com/advisories/GHSA-xxxx-xxxx-xxxx">GHSA-xxxx-xxxx-xxxx</a>`) {
+		t.Errorf("formatted_body %q does not link to the advisory", got.FormattedBody)
+	}
+}
+
+func TestMatrixNotifyError(t *testing.T) {
+	homeserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errcode":"M_FORBIDDEN"}`))
+	}))
+	defer homeserver.Close()
+
+	m := Matrix{Homeserver: homeserver.URL, AccessToken: "secret", RoomID: "!room:example.com"}
+	if err := m.Notify(Release{Project: "Willow", Tag: "v0.0.1"}); err == nil {
+		t.Error("Notify returned nil error for a forbidden response")
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		limit   int
+		want    string
+	}{
+		{
+			name:    "HTML",
+			content: "<h2>Changes</h2>\n<ul>\n<li>Fix &amp; improve</li>\n</ul>",
+			limit:   100,
+			want:    "Changes\nFix & improve",
+		},
+		{
+			name:    "Truncated",
+			content: "abcdefghij",
+			limit:   5,
+			want:    "abcde…",
+		},
+		{
+			name:    "Empty",
+			content: "",
+			limit:   5,
+			want:    "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := PlainText(test.content, test.limit); got != test.want {
+				t.Errorf("PlainText(%q, %d) = %q, want %q", test.content, test.limit, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package notify
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Release is the information about a new release that's sent to each
+// notification backend.
+type Release struct {
+	Project string
+	Tag     string
+	URL     string
+	Content string
+}
+
+// Advisory is the information about a security advisory affecting a version
+// someone runs that's sent to each notification backend.
+type Advisory struct {
+	Project  string
+	ID       string
+	Summary  string
+	Severity string
+	URL      string
+	// Versions are the affected versions that are running
+	Versions []string
+}
+
+// Notifier is implemented by each notification backend.
+type Notifier interface {
+	Notify(release Release) error
+	NotifyAdvisory(advisory Advisory) error
+}
+
+// maxNotesLength is the maximum number of characters of release notes included
+// in a notification.
+const maxNotesLength = 500
+
+var bmStrict = bluemonday.StrictPolicy().AddSpaceWhenStrippingTag(true)
+
+// All sends the release to every notifier, logging rather than returning
+// errors so one broken backend doesn't prevent the others from being notified.
+func All(notifiers []Notifier, release Release) {
+	for _, n := range notifiers {
+		if err := n.Notify(release); err != nil {
+			log.Printf("Error sending notification for %s %s: %v", release.Project, release.Tag, err)
+		}
+	}
+}
+
+// AllAdvisory sends the advisory to every notifier, logging errors like All.
+func AllAdvisory(notifiers []Notifier, advisory Advisory) {
+	for _, n := range notifiers {
+		if err := n.NotifyAdvisory(advisory); err != nil {
+			log.Printf("Error sending notification for %s %s: %v", advisory.Project, advisory.ID, err)
+		}
+	}
+}
+
+// PlainText strips any HTML from a release's notes, collapses whitespace, and
+// truncates the result to at most limit characters.
+func PlainText(content string, limit int) string {
+	text := html.UnescapeString(bmStrict.Sanitize(content))
+
+	lines := strings.Split(text, "\n")
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			kept = append(kept, line)
+		}
+	}
+	text = strings.Join(kept, "\n")
+
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:limit])) + "…"
+}
+
+// summary returns the first line of a notification, such as "Willow v0.0.1
+// released: https://...".
+func summary(release Release) string {
+	if release.URL == "" {
+		return fmt.Sprintf("%s %s released", release.Project, release.Tag)
+	}
+	return fmt.Sprintf("%s %s released: %s", release.Project, release.Tag, release.URL)
+}
+
+// message returns the full plain-text notification for a release.
+func message(release Release) string {
+	notes := PlainText(release.Content, maxNotesLength)
+	if notes == "" {
+		return summary(release)
+	}
+	return summary(release) + "\n\n" + notes
+}
+
+// advisorySummary returns the first line of an advisory notification, such as
+// "Willow v0.0.1 is affected by GHSA-xxxx-xxxx-xxxx (high)".
+func advisorySummary(advisory Advisory) string {
+	s := fmt.Sprintf("%s %s is affected by %s", advisory.Project, strings.Join(advisory.Versions, ", "), advisory.ID)
+	if advisory.Severity != "" {
+		s += " (" + advisory.Severity + ")"
+	}
+	return s
+}
+
+// advisoryMessage returns the full plain-text notification for an advisory.
+func advisoryMessage(advisory Advisory) string {
+	msg := advisorySummary(advisory)
+	if summary := PlainText(advisory.Summary, maxNotesLength); summary != "" {
+		msg += ": " + summary
+	}
+	if advisory.URL != "" {
+		msg += "\n\n" + advisory.URL
+	}
+	return msg
+}
@@ -0,0 +1,337 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package notify
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// XMPP sends release announcements to a JID or, if MUC is true, to a
+// multi-user chat. It connects, authenticates, sends the message, and
+// disconnects for every notification; releases are infrequent enough that
+// keeping a connection alive isn't worth the complexity.
+type XMPP struct {
+	// JID is the bare JID of Willow's account, such as willow@example.com
+	JID      string
+	Password string
+	// Server is an optional host:port to connect to instead of looking up
+	// the JID's SRV records
+	Server    string
+	Recipient string
+	MUC       bool
+	// Nick is the nickname used when joining a MUC
+	Nick string
+
+	// tlsConfig replaces the default STARTTLS configuration in tests
+	tlsConfig *tls.Config
+}
+
+const (
+	nsStream = "http://etherx.jabber.org/streams"
+	nsTLS    = "urn:ietf:params:xml:ns:xmpp-tls"
+	nsSASL   = "urn:ietf:params:xml:ns:xmpp-sasl"
+	nsBind   = "urn:ietf:params:xml:ns:xmpp-bind"
+	nsMUC    = "http://jabber.org/protocol/muc"
+
+	xmppTimeout = 30 * time.Second
+)
+
+type xmppFeatures struct {
+	XMLName    xml.Name  `xml:"http://etherx.jabber.org/streams features"`
+	StartTLS   *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
+	Mechanisms []string  `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms>mechanism"`
+	Bind       *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+}
+
+type xmppPresence struct {
+	From     string `xml:"from,attr"`
+	Type     string `xml:"type,attr"`
+	Statuses []struct {
+		Code string `xml:"code,attr"`
+	} `xml:"http://jabber.org/protocol/muc#user x>status"`
+}
+
+type xmppConn struct {
+	conn net.Conn
+	dec  *xml.Decoder
+}
+
+// Notify connects to the server and sends a message describing the release.
+func (x XMPP) Notify(release Release) error {
+	return x.send(message(release))
+}
+
+// NotifyAdvisory connects to the server and sends a message describing the
+// advisory.
+func (x XMPP) NotifyAdvisory(advisory Advisory) error {
+	return x.send(advisoryMessage(advisory))
+}
+
+// send connects to the server and sends the plain-text message
+func (x XMPP) send(text string) error {
+	local, domain, ok := strings.Cut(x.JID, "@")
+	if !ok || local == "" || domain == "" {
+		return fmt.Errorf("invalid XMPP JID %q", x.JID)
+	}
+
+	c, err := x.connect(domain)
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close()
+
+	tlsConfig := x.tlsConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: domain, MinVersion: tls.VersionTLS12}
+	}
+	if err := c.login(domain, local, x.Password, tlsConfig); err != nil {
+		return err
+	}
+
+	msgType := "chat"
+	if x.MUC {
+		msgType = "groupchat"
+		if err := c.joinMUC(x.Recipient, x.Nick); err != nil {
+			return err
+		}
+	}
+
+	var body strings.Builder
+	if err := xml.EscapeText(&body, []byte(text)); err != nil {
+		return err
+	}
+	if err := c.send(fmt.Sprintf("<message to='%s' type='%s'><body>%s</body></message>", xmlAttr(x.Recipient), msgType, body.String())); err != nil {
+		return err
+	}
+
+	return c.send("</stream:stream>")
+}
+
+// connect opens a TCP connection to the server, preferring the explicitly
+// configured address, then SRV records, then the domain on the default port.
+func (x XMPP) connect(domain string) (*xmppConn, error) {
+	addr := x.Server
+	if addr == "" {
+		addr = net.JoinHostPort(domain, "5222")
+		if _, srvs, err := net.LookupSRV("xmpp-client", "tcp", domain); err == nil && len(srvs) > 0 {
+			addr = net.JoinHostPort(strings.TrimSuffix(srvs[0].Target, "."), fmt.Sprint(srvs[0].Port))
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, xmppTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Now().Add(xmppTimeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &xmppConn{conn: conn}, nil
+}
+
+// login negotiates STARTTLS, authenticates with SASL PLAIN, and binds a
+// resource.
+func (c *xmppConn) login(domain, local, password string, tlsConfig *tls.Config) error {
+	features, err := c.openStream(domain)
+	if err != nil {
+		return err
+	}
+	if features.StartTLS == nil {
+		return errors.New("XMPP server does not offer STARTTLS, refusing to send credentials")
+	}
+
+	if err := c.send("<starttls xmlns='" + nsTLS + "'/>"); err != nil {
+		return err
+	}
+	if name, err := c.nextElement(); err != nil {
+		return err
+	} else if name.Local != "proceed" {
+		return fmt.Errorf("STARTTLS failed: %s", name.Local)
+	}
+	tlsConn := tls.Client(c.conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	c.conn = tlsConn
+
+	if features, err = c.openStream(domain); err != nil {
+		return err
+	}
+	plain := false
+	for _, m := range features.Mechanisms {
+		if m == "PLAIN" {
+			plain = true
+		}
+	}
+	if !plain {
+		return errors.New("XMPP server does not support SASL PLAIN")
+	}
+
+	auth := base64.StdEncoding.EncodeToString([]byte("\x00" + local + "\x00" + password))
+	if err := c.send("<auth xmlns='" + nsSASL + "' mechanism='PLAIN'>" + auth + "</auth>"); err != nil {
+		return err
+	}
+	if name, err := c.nextElement(); err != nil {
+		return err
+	} else if name.Local != "success" {
+		return errors.New("XMPP authentication failed")
+	}
+
+	if _, err = c.openStream(domain); err != nil {
+		return err
+	}
+	if err := c.send("<iq type='set' id='bind'><bind xmlns='" + nsBind + "'><resource>willow</resource></bind></iq>"); err != nil {
+		return err
+	}
+	for {
+		start, err := c.nextStart()
+		if err != nil {
+			return err
+		}
+		if start.Name.Local != "iq" {
+			if err := c.dec.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		typ := attr(start, "type")
+		if err := c.dec.Skip(); err != nil {
+			return err
+		}
+		if typ != "result" {
+			return errors.New("XMPP resource binding failed")
+		}
+		return nil
+	}
+}
+
+// joinMUC joins the room and waits for the server to reflect our own presence
+// so the message isn't rejected for being sent by a non-occupant.
+func (c *xmppConn) joinMUC(room, nick string) error {
+	if nick == "" {
+		nick = "Willow"
+	}
+	occupant := room + "/" + nick
+	if err := c.send("<presence to='" + xmlAttr(occupant) + "'><x xmlns='" + nsMUC + "'><history maxstanzas='0'/></x></presence>"); err != nil {
+		return err
+	}
+
+	for {
+		start, err := c.nextStart()
+		if err != nil {
+			return err
+		}
+		if start.Name.Local != "presence" {
+			if err := c.dec.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		var p xmppPresence
+		if err := c.dec.DecodeElement(&p, &start); err != nil {
+			return err
+		}
+		if !strings.EqualFold(p.From, occupant) && !selfPresence(p) {
+			continue
+		}
+		if p.Type == "error" {
+			return fmt.Errorf("failed joining MUC %s", room)
+		}
+		return nil
+	}
+}
+
+// selfPresence reports whether the MUC marked a presence as our own (status
+// 110), which handles servers that rewrite the nickname.
+func selfPresence(p xmppPresence) bool {
+	for _, s := range p.Statuses {
+		if s.Code == "110" {
+			return true
+		}
+	}
+	return false
+}
+
+// openStream sends a new stream header and returns the features the server
+// advertises.
+func (c *xmppConn) openStream(domain string) (xmppFeatures, error) {
+	var features xmppFeatures
+	header := "<?xml version='1.0'?><stream:stream to='" + xmlAttr(domain) +
+		"' xmlns='jabber:client' xmlns:stream='" + nsStream + "' version='1.0'>"
+	if err := c.send(header); err != nil {
+		return features, err
+	}
+
+	c.dec = xml.NewDecoder(c.conn)
+	start, err := c.nextStart()
+	if err != nil {
+		return features, err
+	}
+	if start.Name.Space != nsStream || start.Name.Local != "stream" {
+		return features, fmt.Errorf("unexpected XMPP element %s", start.Name.Local)
+	}
+
+	start, err = c.nextStart()
+	if err != nil {
+		return features, err
+	}
+	err = c.dec.DecodeElement(&features, &start)
+	return features, err
+}
+
+// nextElement returns the name of the next element, skipping its contents.
+func (c *xmppConn) nextElement() (xml.Name, error) {
+	start, err := c.nextStart()
+	if err != nil {
+		return xml.Name{}, err
+	}
+	return start.Name, c.dec.Skip()
+}
+
+// nextStart returns the next start element in the stream.
+func (c *xmppConn) nextStart() (xml.StartElement, error) {
+	for {
+		tok, err := c.dec.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			return t, nil
+		case xml.EndElement:
+			if t.Name.Space == nsStream && t.Name.Local == "stream" {
+				return xml.StartElement{}, io.EOF
+			}
+		}
+	}
+}
+
+func (c *xmppConn) send(s string) error {
+	_, err := io.WriteString(c.conn, s)
+	return err
+}
+
+func attr(start xml.StartElement, name string) string {
+	for _, a := range start.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func xmlAttr(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return strings.ReplaceAll(b.String(), "'", "&#39;")
+}
@@ -0,0 +1,282 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package notify
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// xmppStub is a minimal XMPP server that accepts a single connection, plays
+// through STARTTLS, SASL PLAIN, resource binding, and optionally joining a
+// MUC, and records the message it's sent
+type xmppStub struct {
+	listener net.Listener
+	cert     tls.Certificate
+	password string
+	// rewriteNick makes the MUC reflect the join under a different nickname
+	// with status 110, like servers that enforce registered nicknames do
+	rewriteNick bool
+
+	joined  string
+	message xmppStubMessage
+	done    chan error
+}
+
+type xmppStubMessage struct {
+	To   string `xml:"to,attr"`
+	Type string `xml:"type,attr"`
+	Body string `xml:"body"`
+}
+
+// newXMPPStub starts the server and returns it along with a client config
+// that trusts its certificate
+func newXMPPStub(t *testing.T, password string) (*xmppStub, *tls.Config) {
+	// Borrow httptest's certificate, which is valid for example.com
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	certServer.Close()
+	clientConfig := certServer.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	clientConfig.ServerName = "example.com"
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &xmppStub{
+		listener: listener,
+		cert:     certServer.TLS.Certificates[0],
+		password: password,
+		done:     make(chan error, 1),
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.done <- err
+			return
+		}
+		defer conn.Close()
+		s.done <- s.serve(conn)
+	}()
+	return s, clientConfig
+}
+
+func (s *xmppStub) serve(conn net.Conn) error {
+	dec, err := s.openStream(conn, "<starttls xmlns='"+nsTLS+"'><required/></starttls>")
+	if err != nil {
+		return err
+	}
+	if _, err := s.expect(dec, "starttls"); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(conn, "<proceed xmlns='"+nsTLS+"'/>"); err != nil {
+		return err
+	}
+	tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{s.cert}})
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	conn = tlsConn
+
+	dec, err = s.openStream(conn, "<mechanisms xmlns='"+nsSASL+"'><mechanism>SCRAM-SHA-1</mechanism><mechanism>PLAIN</mechanism></mechanisms>")
+	if err != nil {
+		return err
+	}
+	start, err := s.expect(dec, "auth")
+	if err != nil {
+		return err
+	}
+	var auth string
+	if err := dec.DecodeElement(&auth, &start); err != nil {
+		return err
+	}
+	credentials, _ := base64.StdEncoding.DecodeString(auth)
+	if string(credentials) != "\x00willow\x00"+s.password {
+		_, err := io.WriteString(conn, "<failure xmlns='"+nsSASL+"'><not-authorized/></failure></stream:stream>")
+		return err
+	}
+	if _, err := io.WriteString(conn, "<success xmlns='"+nsSASL+"'/>"); err != nil {
+		return err
+	}
+
+	dec, err = s.openStream(conn, "<bind xmlns='"+nsBind+"'/>")
+	if err != nil {
+		return err
+	}
+	if _, err := s.expect(dec, "iq"); err != nil {
+		return err
+	}
+	if err := dec.Skip(); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(conn, "<iq type='result' id='bind'><bind xmlns='"+nsBind+"'><jid>willow@example.com/willow</jid></bind></iq>"); err != nil {
+		return err
+	}
+
+	for {
+		start, err := s.expect(dec, "")
+		if err != nil {
+			return err
+		}
+		switch start.Name.Local {
+		case "presence":
+			s.joined = attr(start, "to")
+			if err := dec.Skip(); err != nil {
+				return err
+			}
+			// Someone else's presence comes first, as it does when joining
+			// an occupied room
+			from := s.joined
+			status := ""
+			if s.rewriteNick {
+				from = strings.Split(s.joined, "/")[0] + "/willow-bot"
+				status = "<status code='110'/>"
+			}
+			_, err := fmt.Fprintf(conn, "<presence from='%s/alice'><x xmlns='%s#user'/></presence><presence from='%s'><x xmlns='%s#user'>%s</x></presence>",
+				strings.Split(s.joined, "/")[0], nsMUC, from, nsMUC, status)
+			if err != nil {
+				return err
+			}
+		case "message":
+			return dec.DecodeElement(&s.message, &start)
+		default:
+			return fmt.Errorf("unexpected element %s", start.Name.Local)
+		}
+	}
+}
+
+// openStream reads the client's stream header and replies with our own and
+// the given features
+func (s *xmppStub) openStream(conn net.Conn, features string) (*xml.Decoder, error) {
+	dec := xml.NewDecoder(conn)
+	if _, err := s.expect(dec, "stream"); err != nil {
+		return nil, err
+	}
+	_, err := io.WriteString(conn, "<?xml version='1.0'?><stream:stream from='example.com' id='1' xmlns='jabber:client' xmlns:stream='"+nsStream+"' version='1.0'>"+
+		"<stream:features>"+features+"</stream:features>")
+	return dec, err
+}
+
+// expect returns the next start element, failing unless it's called name when
+// name is set
+func (s *xmppStub) expect(dec *xml.Decoder, name string) (xml.StartElement, error) {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			if name != "" && start.Name.Local != name {
+				return start, fmt.Errorf("got %s, want %s", start.Name.Local, name)
+			}
+			return start, nil
+		}
+	}
+}
+
+func TestXMPPNotify(t *testing.T) {
+	tests := []struct {
+		name        string
+		muc         bool
+		rewriteNick bool
+		recipient   string
+		wantJoined  string
+		wantType    string
+	}{
+		{name: "direct message", recipient: "amolith@example.com", wantType: "chat"},
+		{name: "MUC", muc: true, recipient: "releases@muc.example.com", wantJoined: "releases@muc.example.com/Willow", wantType: "groupchat"},
+		{name: "MUC rewriting nickname", muc: true, rewriteNick: true, recipient: "releases@muc.example.com", wantJoined: "releases@muc.example.com/Willow", wantType: "groupchat"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, tlsConfig := newXMPPStub(t, "s3cret")
+			server.rewriteNick = tt.rewriteNick
+			x := XMPP{
+				JID:       "willow@example.com",
+				Password:  "s3cret",
+				Server:    server.listener.Addr().String(),
+				Recipient: tt.recipient,
+				MUC:       tt.muc,
+				tlsConfig: tlsConfig,
+			}
+			err := x.Notify(Release{Project: "Willow", Tag: "v0.0.1", URL: "https://example.com/willow/v0.0.1", Content: "<p>Fixed <em>everything</em> & more</p>"})
+			if err != nil {
+				t.Fatalf("Notify returned error: %v", err)
+			}
+			if err := <-server.done; err != nil {
+				t.Fatalf("server: %v", err)
+			}
+
+			if server.joined != tt.wantJoined {
+				t.Errorf("joined %q, want %q", server.joined, tt.wantJoined)
+			}
+			want := xmppStubMessage{
+				To:   tt.recipient,
+				Type: tt.wantType,
+				Body: "Willow v0.0.1 released: https://example.com/willow/v0.0.1\n\nFixed everything & more",
+			}
+			if server.message != want {
+				t.Errorf("message = %+v, want %+v", server.message, want)
+			}
+		})
+	}
+}
+
+func TestXMPPNotifyAuthFailure(t *testing.T) {
+	server, tlsConfig := newXMPPStub(t, "s3cret")
+	x := XMPP{
+		JID:       "willow@example.com",
+		Password:  "wrong",
+		Server:    server.listener.Addr().String(),
+		Recipient: "amolith@example.com",
+		tlsConfig: tlsConfig,
+	}
+	err := x.Notify(Release{Project: "Willow", Tag: "v0.0.1"})
+	if err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("Notify with the wrong password = %v, want authentication to fail", err)
+	}
+	if err := <-server.done; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	if server.message != (xmppStubMessage{}) {
+		t.Errorf("message sent despite failing to authenticate: %+v", server.message)
+	}
+}
+
+func TestXMPPNotifyRequiresTLS(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s := &xmppStub{}
+		dec, err := s.openStream(conn, "<mechanisms xmlns='"+nsSASL+"'><mechanism>PLAIN</mechanism></mechanisms>")
+		if err != nil {
+			return
+		}
+		// Wait for the client to hang up
+		_, _ = s.expect(dec, "")
+	}()
+
+	x := XMPP{JID: "willow@example.com", Password: "s3cret", Server: listener.Addr().String(), Recipient: "amolith@example.com"}
+	if err := x.Notify(Release{Project: "Willow", Tag: "v0.0.1"}); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("Notify without STARTTLS = %v, want it to refuse", err)
+	}
+}
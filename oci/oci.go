@@ -0,0 +1,188 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package oci tracks container images, treating the version tags in their
+// registry as releases.
+package oci
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// DockerHub is the registry images without a registry host come from
+const DockerHub = "docker.io"
+
+type Release struct {
+	Tag     string
+	Content string
+	URL     string
+	Date    time.Time
+}
+
+var bmStrict = bluemonday.StrictPolicy()
+
+// Reference is an image reference like ghcr.io/owner/app:1.2.3 split into
+// its parts. Repository is normalised the way Docker does, so nginx becomes
+// library/nginx on Docker Hub.
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference parses an image reference as written in compose files and
+// pod specs
+func ParseReference(image string) (Reference, error) {
+	image = strings.TrimSpace(image)
+	if image == "" || strings.ContainsAny(image, " \t${}") {
+		return Reference{}, fmt.Errorf("%q isn't an image reference", image)
+	}
+
+	var ref Reference
+	if name, digest, ok := strings.Cut(image, "@"); ok {
+		image, ref.Digest = name, digest
+	}
+	// A colon after the last slash separates the tag; one before it is a
+	// registry port
+	if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+		image, ref.Tag = image[:colon], image[colon+1:]
+	}
+
+	first, rest, ok := strings.Cut(image, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.Registry, ref.Repository = strings.ToLower(first), rest
+	} else {
+		ref.Registry, ref.Repository = DockerHub, image
+	}
+	if ref.Registry == "index.docker.io" || ref.Registry == "registry-1.docker.io" {
+		ref.Registry = DockerHub
+	}
+	if ref.Registry == DockerHub && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = "library/" + ref.Repository
+	}
+	if ref.Repository == "" {
+		return Reference{}, fmt.Errorf("%q has no repository", image)
+	}
+	return ref, nil
+}
+
+// Name returns the image's full name without its tag, like
+// docker.io/library/nginx
+func (r Reference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+// ShortName returns the name people usually write, like nginx or
+// linuxserver/sonarr for Docker Hub images
+func (r Reference) ShortName() string {
+	if r.Registry != DockerHub {
+		return r.Name()
+	}
+	return strings.TrimPrefix(r.Repository, "library/")
+}
+
+// URL returns a link to the image that both people and ParseURL can follow.
+// Tags with a variant, like 1.25-alpine, only compare sensibly with tags of
+// the same variant, so the variant is kept in the fragment.
+func (r Reference) URL() string {
+	link := "https://" + r.Name()
+	if r.Registry == DockerHub {
+		link = "https://hub.docker.com/r/" + r.Repository
+	}
+	if _, variant, ok := SplitTag(r.Tag); ok && variant != "" {
+		link += "#" + variant
+	}
+	return link
+}
+
+// ParseURL returns the image and variant a project URL from Reference.URL
+// refers to
+func ParseURL(link string) (Reference, string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return Reference{}, "", err
+	}
+	if u.Host == "" {
+		return Reference{}, "", fmt.Errorf("%q isn't an image URL", link)
+	}
+
+	path := strings.Trim(u.Path, "/")
+	ref := Reference{Registry: strings.ToLower(u.Host), Repository: path}
+	if ref.Registry == "hub.docker.com" {
+		ref.Registry = DockerHub
+		ref.Repository = strings.TrimPrefix(path, "r/")
+		if name, ok := strings.CutPrefix(path, "_/"); ok {
+			ref.Repository = "library/" + name
+		}
+	}
+	if ref.Repository == "" {
+		return Reference{}, "", fmt.Errorf("%q has no repository", link)
+	}
+	return ref, u.Fragment, nil
+}
+
+// SplitTag splits a tag like v1.25.3-alpine3.19 into its version and variant,
+// with the numbers dropped from the variant so images rebuilt on a newer base
+// or with a new build number, like 1.2.3-ls45, keep the same one. Tags that
+// don't start with a version, like latest, return false.
+func SplitTag(tag string) (string, string, bool) {
+	trimmed := strings.TrimPrefix(tag, "v")
+	if trimmed == "" || trimmed[0] < '0' || trimmed[0] > '9' {
+		return "", "", false
+	}
+	version, suffix, _ := strings.Cut(tag, "-")
+	variant := strings.Trim(strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == '.' {
+			return -1
+		}
+		return r
+	}, suffix), "-_")
+	return version, variant, true
+}
+
+// GetReleases lists the version tags of an image with the same variant as
+// the project's URL
+func GetReleases(projectURL string) ([]Release, error) {
+	ref, variant, err := ParseURL(projectURL)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := Client{}.Tags(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	releases := make([]Release, 0, len(tags))
+	for _, t := range tags {
+		_, v, ok := SplitTag(t.Name)
+		if !ok || v != variant {
+			continue
+		}
+		tagged := ref
+		tagged.Tag = t.Name
+		releases = append(releases, Release{
+			Tag:     bmStrict.Sanitize(t.Name),
+			Content: bmStrict.Sanitize(tagged.Name() + ":" + t.Name),
+			URL:     bmStrict.Sanitize(tagURL(tagged)),
+			Date:    t.Updated,
+		})
+	}
+	return releases, nil
+}
+
+// tagURL links to the tag on Docker Hub, where each tag has a page, and to
+// the image elsewhere
+func tagURL(ref Reference) string {
+	if ref.Registry == DockerHub {
+		return "https://hub.docker.com/r/" + ref.Repository + "/tags?name=" + url.QueryEscape(ref.Tag)
+	}
+	return "https://" + ref.Name()
+}
@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package oci
+
+import "testing"
+
+func TestParseReference(t *testing.T) {
+	tests := map[string]Reference{
+		"nginx":                             {Registry: DockerHub, Repository: "library/nginx"},
+		"nginx:1.25-alpine":                 {Registry: DockerHub, Repository: "library/nginx", Tag: "1.25-alpine"},
+		"linuxserver/sonarr:4.0.2":          {Registry: DockerHub, Repository: "linuxserver/sonarr", Tag: "4.0.2"},
+		"docker.io/library/redis:7":         {Registry: DockerHub, Repository: "library/redis", Tag: "7"},
+		"index.docker.io/grafana/grafana":   {Registry: DockerHub, Repository: "grafana/grafana"},
+		"ghcr.io/owner/app:v1.2.3":          {Registry: "ghcr.io", Repository: "owner/app", Tag: "v1.2.3"},
+		"registry.example.com:5000/a/b/c":   {Registry: "registry.example.com:5000", Repository: "a/b/c"},
+		"localhost/app:dev":                 {Registry: "localhost", Repository: "app", Tag: "dev"},
+		"quay.io/org/app@sha256:abcdef0123": {Registry: "quay.io", Repository: "org/app", Digest: "sha256:abcdef0123"},
+		"postgres:16.2@sha256:abcdef0123":   {Registry: DockerHub, Repository: "library/postgres", Tag: "16.2", Digest: "sha256:abcdef0123"},
+	}
+	for image, want := range tests {
+		got, err := ParseReference(image)
+		if err != nil || got != want {
+			t.Errorf("ParseReference(%q) = %+v, %v; want %+v", image, got, err, want)
+		}
+	}
+	for _, image := range []string{"", "${IMAGE}", "ghcr.io/"} {
+		if _, err := ParseReference(image); err == nil {
+			t.Errorf("ParseReference(%q) succeeded", image)
+		}
+	}
+}
+
+func TestSplitTag(t *testing.T) {
+	tests := []struct {
+		tag, version, variant string
+		ok                    bool
+	}{
+		{"1.25.3", "1.25.3", "", true},
+		{"v1.2.3", "v1.2.3", "", true},
+		{"1.25.3-alpine3.19", "1.25.3", "alpine", true},
+		{"4.0.2-ls45", "4.0.2", "ls", true},
+		{"16-bookworm", "16", "bookworm", true},
+		{"latest", "", "", false},
+		{"stable-alpine", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		version, variant, ok := SplitTag(tt.tag)
+		if version != tt.version || variant != tt.variant || ok != tt.ok {
+			t.Errorf("SplitTag(%q) = %q, %q, %v; want %q, %q, %v", tt.tag, version, variant, ok, tt.version, tt.variant, tt.ok)
+		}
+	}
+}
+
+func TestURLRoundTrip(t *testing.T) {
+	tests := map[string]string{
+		"nginx:1.25-alpine":        "https://hub.docker.com/r/library/nginx#alpine",
+		"linuxserver/sonarr:4.0.2": "https://hub.docker.com/r/linuxserver/sonarr",
+		"ghcr.io/owner/app:v1.2.3": "https://ghcr.io/owner/app",
+	}
+	for image, want := range tests {
+		ref, _ := ParseReference(image)
+		if got := ref.URL(); got != want {
+			t.Errorf("URL() of %s = %q, want %q", image, got, want)
+		}
+		parsed, variant, err := ParseURL(ref.URL())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, wantVariant, _ := SplitTag(ref.Tag)
+		ref.Tag = ""
+		if parsed != ref || variant != wantVariant {
+			t.Errorf("ParseURL(%q) = %+v, %q; want %+v, %q", want, parsed, variant, ref, wantVariant)
+		}
+	}
+
+	ref, _, err := ParseURL("https://hub.docker.com/_/postgres")
+	if err != nil || ref.Name() != "docker.io/library/postgres" {
+		t.Errorf("ParseURL() of an official image page = %+v, %v", ref, err)
+	}
+}
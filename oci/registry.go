@@ -0,0 +1,283 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SourceLabel is the image label that links to the image's source repo
+const SourceLabel = "org.opencontainers.image.source"
+
+// maxTagPages stops images with thousands of tags, like nightly builds, from
+// taking forever to list
+const maxTagPages = 20
+
+// Client talks to container registries anonymously, so only public images can
+// be tracked
+type Client struct {
+	HTTP *http.Client
+	// Registries overrides the API endpoint of registries by host, and Hub
+	// overrides Docker Hub's, which tests use to stand in for them
+	Registries map[string]string
+	Hub        string
+}
+
+// Tag is one of an image's tags. Updated is only known for Docker Hub images.
+type Tag struct {
+	Name    string
+	Updated time.Time
+}
+
+// Tags lists the image's tags
+func (c Client) Tags(ref Reference) ([]Tag, error) {
+	if ref.Registry == DockerHub {
+		return c.hubTags(ref)
+	}
+
+	var tags []Tag
+	next := c.endpoint(ref) + "/v2/" + ref.Repository + "/tags/list?n=1000"
+	for page := 0; next != "" && page < maxTagPages; page++ {
+		resp, err := c.get(ref, next, "application/json")
+		if err != nil {
+			return nil, err
+		}
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range list.Tags {
+			tags = append(tags, Tag{Name: t})
+		}
+		next = nextLink(resp.Request.URL, resp.Header.Get("Link"))
+	}
+	return tags, nil
+}
+
+// hubTags lists tags through Docker Hub's own API, which unlike the registry
+// API says when each tag was last pushed
+func (c Client) hubTags(ref Reference) ([]Tag, error) {
+	hub := c.Hub
+	if hub == "" {
+		hub = "https://hub.docker.com"
+	}
+
+	var tags []Tag
+	next := hub + "/v2/repositories/" + ref.Repository + "/tags?page_size=100"
+	for page := 0; next != "" && page < maxTagPages; page++ {
+		resp, err := c.client().Get(next)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("Docker Hub returned %s for %s", resp.Status, ref.Name())
+		}
+		var list struct {
+			Next    string `json:"next"`
+			Results []struct {
+				Name        string    `json:"name"`
+				LastUpdated time.Time `json:"last_updated"`
+			} `json:"results"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range list.Results {
+			tags = append(tags, Tag{Name: t.Name, Updated: t.LastUpdated})
+		}
+		next = list.Next
+	}
+	return tags, nil
+}
+
+// manifestTypes are the manifests and indexes Labels understands
+var manifestTypes = strings.Join([]string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}, ", ")
+
+// Labels returns the labels in the config of the image's tag. For
+// multi-platform images, the first platform's are used.
+func (c Client) Labels(ref Reference) (map[string]string, error) {
+	reference := ref.Tag
+	if ref.Digest != "" {
+		reference = ref.Digest
+	}
+	if reference == "" {
+		reference = "latest"
+	}
+
+	var manifest struct {
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+	}
+	if err := c.getJSON(ref, "/manifests/"+reference, manifestTypes, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Config.Digest == "" && len(manifest.Manifests) > 0 {
+		if err := c.getJSON(ref, "/manifests/"+manifest.Manifests[0].Digest, manifestTypes, &manifest); err != nil {
+			return nil, err
+		}
+	}
+	if manifest.Config.Digest == "" {
+		return nil, fmt.Errorf("%s has no image config", ref.Name())
+	}
+
+	var config struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	if err := c.getJSON(ref, "/blobs/"+manifest.Config.Digest, "application/json", &config); err != nil {
+		return nil, err
+	}
+	return config.Config.Labels, nil
+}
+
+// getJSON decodes a response from the image's part of the registry API
+func (c Client) getJSON(ref Reference, path, accept string, v any) error {
+	resp, err := c.get(ref, c.endpoint(ref)+"/v2/"+ref.Repository+path, accept)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(v)
+}
+
+// get requests a registry URL, fetching an anonymous token and trying again
+// when the registry asks for one
+func (c Client) get(ref Reference, link, accept string) (*http.Response, error) {
+	token := ""
+	for attempt := 0; attempt < 2; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, link, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", accept)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := c.client().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized || token != "" {
+			return nil, fmt.Errorf("%s returned %s for %s", ref.Registry, resp.Status, ref.Name())
+		}
+		token, err = c.token(ref, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%s refused access to %s", ref.Registry, ref.Name())
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// token fetches an anonymous pull token as described by a registry's Bearer
+// challenge
+func (c Client) token(ref Reference, challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("%s needs credentials to pull %s", ref.Registry, ref.Name())
+	}
+	values := make(map[string]string)
+	for _, m := range challengeParam.FindAllStringSubmatch(params, -1) {
+		values[m[1]] = m[2]
+	}
+	realm, err := url.Parse(values["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("%s sent an invalid auth challenge", ref.Registry)
+	}
+
+	query := realm.Query()
+	if values["service"] != "" {
+		query.Set("service", values["service"])
+	}
+	scope := values["scope"]
+	if scope == "" {
+		scope = "repository:" + ref.Repository + ":pull"
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	resp, err := c.client().Get(realm.String())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s refused a token for %s: %s", ref.Registry, ref.Name(), resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token == "" {
+		body.Token = body.AccessToken
+	}
+	return body.Token, nil
+}
+
+// endpoint returns the base URL of the image's registry API
+func (c Client) endpoint(ref Reference) string {
+	if endpoint, ok := c.Registries[ref.Registry]; ok {
+		return endpoint
+	}
+	if ref.Registry == DockerHub {
+		return "https://registry-1.docker.io"
+	}
+	return "https://" + ref.Registry
+}
+
+func (c Client) client() *http.Client {
+	if c.HTTP == nil {
+		return &http.Client{Timeout: 30 * time.Second}
+	}
+	return c.HTTP
+}
+
+var linkNext = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// nextLink resolves the next page from a Link header, which registries give
+// relative to themselves, or returns an empty string on the last page
+func nextLink(base *url.URL, header string) string {
+	m := linkNext.FindStringSubmatch(header)
+	if m == nil {
+		return ""
+	}
+	next, err := base.Parse(m[1])
+	if err != nil {
+		return ""
+	}
+	return next.String()
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package oci
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newRegistry serves an image that needs an anonymous token, two pages of
+// tags, and a multi-platform manifest
+func newRegistry(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("scope") != "repository:owner/app:pull" || r.URL.Query().Get("service") != "registry.test" {
+			t.Errorf("token requested with %s", r.URL.RawQuery)
+		}
+		_, _ = w.Write([]byte(`{"token": "secret"}`))
+	})
+	authed := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="registry.test",scope="repository:owner/app:pull"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+		}
+	}
+	mux.HandleFunc("/v2/owner/app/tags/list", authed(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("last") == "" {
+			w.Header().Set("Link", `</v2/owner/app/tags/list?n=1000&last=1.0.0>; rel="next"`)
+			_, _ = w.Write([]byte(`{"name": "owner/app", "tags": ["0.9.0", "1.0.0"]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"name": "owner/app", "tags": ["latest", "1.1.0"]}`))
+	}))
+	mux.HandleFunc("/v2/owner/app/manifests/1.1.0", authed(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"mediaType": "application/vnd.oci.image.index.v1+json", "manifests": [{"digest": "sha256:amd64"}]}`))
+	}))
+	mux.HandleFunc("/v2/owner/app/manifests/sha256:amd64", authed(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"mediaType": "application/vnd.oci.image.manifest.v1+json", "config": {"digest": "sha256:config"}}`))
+	}))
+	mux.HandleFunc("/v2/owner/app/blobs/sha256:config", authed(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"config": {"Labels": {"org.opencontainers.image.source": "https://github.com/owner/app"}}}`))
+	}))
+	mux.HandleFunc("/v2/repositories/library/nginx/tags", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"next": null, "results": [{"name": "1.25.3", "last_updated": "2024-01-02T03:04:05Z"}]}`))
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTags(t *testing.T) {
+	server := newRegistry(t)
+	client := Client{Registries: map[string]string{"registry.test": server.URL}, Hub: server.URL}
+
+	tags, err := client.Tags(Reference{Registry: "registry.test", Repository: "owner/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Tag{{Name: "0.9.0"}, {Name: "1.0.0"}, {Name: "latest"}, {Name: "1.1.0"}}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("Tags() = %+v, want %+v", tags, want)
+	}
+
+	tags, err = client.Tags(Reference{Registry: DockerHub, Repository: "library/nginx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if len(tags) != 1 || tags[0].Name != "1.25.3" || !tags[0].Updated.Equal(updated) {
+		t.Errorf("Tags() from Docker Hub = %+v", tags)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	server := newRegistry(t)
+	client := Client{Registries: map[string]string{"registry.test": server.URL}}
+
+	labels, err := client.Labels(Reference{Registry: "registry.test", Repository: "owner/app", Tag: "1.1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels[SourceLabel] != "https://github.com/owner/app" {
+		t.Errorf("Labels() = %v", labels)
+	}
+
+	if _, err := client.Labels(Reference{Registry: "registry.test", Repository: "owner/app", Tag: "missing"}); err == nil {
+		t.Error("Labels() succeeded for a missing tag")
+	}
+}
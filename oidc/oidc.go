@@ -0,0 +1,336 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// pendingTimeout is how long a user has to finish logging in with the
+	// identity provider
+	pendingTimeout = 10 * time.Minute
+	// maxPending caps how many logins can be in progress at once, since
+	// anyone can start one
+	maxPending = 1000
+)
+
+var (
+	ErrUnknownState = errors.New("unknown or expired login attempt")
+	ErrNoUsername   = errors.New("identity provider did not return a username")
+	ErrNoSubject    = errors.New("identity provider did not return a subject")
+)
+
+// Provider performs the OpenID Connect authorization code flow with PKCE
+// against a single identity provider.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes requested in addition to openid
+	Scopes []string
+	// UsernameClaim is the ID token claim used to name new Willow accounts.
+	// If it's empty, preferred_username, email, and sub are tried in that
+	// order. Accounts are then found by sub, so renaming oneself at the
+	// identity provider doesn't lead to someone else's account.
+	UsernameClaim string
+	Client        *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	pending   map[string]pendingLogin
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// Identity is who the identity provider says logged in. Issuer and Subject
+// never change for a user, while Username is only a suggestion.
+type Identity struct {
+	Issuer   string
+	Subject  string
+	Username string
+}
+
+type pendingLogin struct {
+	verifier string
+	nonce    string
+	expires  time.Time
+}
+
+type tokenResponse struct {
+	IDToken     string `json:"id_token"`
+	AccessToken string `json:"access_token"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// AuthCodeURL starts a login, returning the state that must be bound to the
+// user's browser and the identity provider URL to redirect them to.
+func (p *Provider) AuthCodeURL(ctx context.Context) (string, string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	p.mu.Lock()
+	if p.pending == nil {
+		p.pending = make(map[string]pendingLogin)
+	}
+	oldest := ""
+	for s, pl := range p.pending {
+		if time.Now().After(pl.expires) {
+			delete(p.pending, s)
+		} else if oldest == "" || pl.expires.Before(p.pending[oldest].expires) {
+			oldest = s
+		}
+	}
+	// When full, the login that's been waiting longest has to start again
+	if len(p.pending) >= maxPending {
+		delete(p.pending, oldest)
+	}
+	p.pending[state] = pendingLogin{
+		verifier: verifier,
+		nonce:    nonce,
+		expires:  time.Now().Add(pendingTimeout),
+	}
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return state, d.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange finishes a login by trading the authorization code for an ID token
+// and returns the identity it contains.
+func (p *Provider) Exchange(ctx context.Context, state, code string) (Identity, error) {
+	p.mu.Lock()
+	pl, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mu.Unlock()
+	if !ok || time.Now().After(pl.expires) {
+		return Identity{}, ErrUnknownState
+	}
+
+	d, err := p.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {pl.verifier},
+		"client_id":     {p.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return Identity{}, err
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return Identity{}, fmt.Errorf("failed decoding token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return Identity{}, fmt.Errorf("token endpoint returned %s: %s %s", resp.Status, token.Error, token.Description)
+	}
+
+	claims, err := p.verifyIDToken(d, token.IDToken, pl.nonce)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return Identity{}, ErrNoSubject
+	}
+	username, err := p.username(claims)
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{Issuer: d.Issuer, Subject: subject, Username: username}, nil
+}
+
+// verifyIDToken checks the ID token's issuer, audience, expiry, and nonce. The
+// token came straight from the token endpoint over TLS, which discover makes
+// sure of, so, as permitted by OpenID Connect Core 3.1.3.7, its signature
+// isn't checked.
+func (p *Provider) verifyIDToken(d *discovery, idToken, nonce string) (map[string]any, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token payload: %w", err)
+	}
+
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != d.Issuer {
+		return nil, fmt.Errorf("ID token issued by %q, expected %q", iss, d.Issuer)
+	}
+	if !audienceContains(claims["aud"], p.ClientID) {
+		return nil, errors.New("ID token was not issued for this client")
+	}
+	if exp, ok := claims["exp"].(float64); !ok || time.Now().After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("ID token has expired")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+
+	return claims, nil
+}
+
+// username returns the configured claim or the first of the default claims
+// that's present.
+func (p *Provider) username(claims map[string]any) (string, error) {
+	candidates := []string{"preferred_username", "email", "sub"}
+	if p.UsernameClaim != "" {
+		candidates = []string{p.UsernameClaim}
+	}
+	for _, c := range candidates {
+		if v, ok := claims[c].(string); ok && v != "" {
+			return v, nil
+		}
+	}
+	return "", ErrNoUsername
+}
+
+// discover fetches and caches the provider's configuration document.
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	cached := p.discovery
+	p.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	endpoint := strings.TrimSuffix(p.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OpenID configuration request returned %s", resp.Status)
+	}
+
+	var d discovery
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&d); err != nil {
+		return nil, fmt.Errorf("failed decoding OpenID configuration: %w", err)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" {
+		return nil, errors.New("OpenID configuration is missing endpoints")
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != strings.TrimSuffix(p.Issuer, "/") {
+		return nil, fmt.Errorf("OpenID configuration is for issuer %q, expected %q", d.Issuer, p.Issuer)
+	}
+	for _, endpoint := range []string{d.Issuer, d.TokenEndpoint} {
+		if u, err := url.Parse(endpoint); err != nil || u.Scheme != "https" {
+			return nil, fmt.Errorf("%q must use https", endpoint)
+		}
+	}
+
+	p.mu.Lock()
+	p.discovery = &d
+	p.mu.Unlock()
+	return &d, nil
+}
+
+func (p *Provider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+func audienceContains(aud any, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []any:
+		for _, v := range a {
+			if s, ok := v.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// randomString returns 32 random bytes encoded as unpadded base64url, which is
+// suitable for state, nonce, and PKCE verifier values.
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
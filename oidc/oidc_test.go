@@ -0,0 +1,233 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// mockProvider is a minimal identity provider that issues a single code
+type mockProvider struct {
+	server    *httptest.Server
+	challenge string
+	nonce     string
+	claims    map[string]any
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	m := &mockProvider{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if r.FormValue("code") != "good-code" || base64.RawURLEncoding.EncodeToString(verifier[:]) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		if id, secret, _ := r.BasicAuth(); id != "willow" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+
+		claims := map[string]any{
+			"iss":   m.server.URL,
+			"aud":   "willow",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": m.nonce,
+		}
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+		payload, _ := json.Marshal(claims)
+		idToken := "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+	})
+	m.server = httptest.NewTLSServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// authorize plays the part of the user's browser at the authorization endpoint
+func (m *mockProvider) authorize(t *testing.T, authURL string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("redirect_uri") != "https://willow.example/login/oidc/callback" {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+	m.challenge = q.Get("code_challenge")
+	m.nonce = q.Get("nonce")
+}
+
+func TestExchange(t *testing.T) {
+	tests := []struct {
+		name          string
+		claims        map[string]any
+		usernameClaim string
+		code          string
+		want          Identity
+		wantErr       bool
+	}{
+		{
+			name:   "preferred username",
+			claims: map[string]any{"sub": "1234", "preferred_username": "alice", "email": "alice@example.com"},
+			code:   "good-code",
+			want:   Identity{Subject: "1234", Username: "alice"},
+		},
+		{
+			name:   "falls back to sub",
+			claims: map[string]any{"sub": "1234"},
+			code:   "good-code",
+			want:   Identity{Subject: "1234", Username: "1234"},
+		},
+		{
+			name:          "configured claim",
+			claims:        map[string]any{"sub": "1234", "preferred_username": "alice", "email": "alice@example.com"},
+			usernameClaim: "email",
+			code:          "good-code",
+			want:          Identity{Subject: "1234", Username: "alice@example.com"},
+		},
+		{
+			name:    "no subject",
+			claims:  map[string]any{"preferred_username": "alice"},
+			code:    "good-code",
+			wantErr: true,
+		},
+		{
+			name:    "bad code",
+			claims:  map[string]any{"sub": "1234"},
+			code:    "bad-code",
+			wantErr: true,
+		},
+		{
+			name:    "wrong audience",
+			claims:  map[string]any{"sub": "1234", "aud": []any{"someone-else"}},
+			code:    "good-code",
+			wantErr: true,
+		},
+		{
+			name:    "wrong nonce",
+			claims:  map[string]any{"sub": "1234", "nonce": "replayed"},
+			code:    "good-code",
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			claims:  map[string]any{"sub": "1234", "exp": time.Now().Add(-time.Minute).Unix()},
+			code:    "good-code",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			m.claims = tt.claims
+			p := &Provider{
+				Issuer:        m.server.URL,
+				ClientID:      "willow",
+				ClientSecret:  "s3cret",
+				RedirectURL:   "https://willow.example/login/oidc/callback",
+				UsernameClaim: tt.usernameClaim,
+				Client:        m.server.Client(),
+			}
+
+			state, authURL, err := p.AuthCodeURL(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.authorize(t, authURL)
+
+			got, err := p.Exchange(context.Background(), state, tt.code)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Exchange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				tt.want.Issuer = m.server.URL
+			}
+			if got != tt.want {
+				t.Errorf("Exchange() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExchangeUnknownState(t *testing.T) {
+	m := newMockProvider(t)
+	p := &Provider{Issuer: m.server.URL, ClientID: "willow", ClientSecret: "s3cret", Client: m.server.Client()}
+
+	state, _, err := p.AuthCodeURL(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Exchange(context.Background(), "forged", "good-code"); err != ErrUnknownState {
+		t.Errorf("Exchange() with forged state error = %v, want %v", err, ErrUnknownState)
+	}
+	// Each state can only be used once
+	_, _ = p.Exchange(context.Background(), state, "bad-code")
+	if _, err := p.Exchange(context.Background(), state, "good-code"); err != ErrUnknownState {
+		t.Errorf("Exchange() with reused state error = %v, want %v", err, ErrUnknownState)
+	}
+}
+
+func TestPendingLoginsCapped(t *testing.T) {
+	m := newMockProvider(t)
+	p := &Provider{Issuer: m.server.URL, ClientID: "willow", ClientSecret: "s3cret", Client: m.server.Client()}
+
+	first, _, err := p.AuthCodeURL(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxPending; i++ {
+		if _, _, err := p.AuthCodeURL(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := len(p.pending); n != maxPending {
+		t.Errorf("%d logins pending, want at most %d", n, maxPending)
+	}
+	if _, ok := p.pending[first]; ok {
+		t.Error("the oldest pending login wasn't evicted")
+	}
+}
+
+func TestDiscoverRequiresHTTPS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 "http://" + r.Host,
+			"authorization_endpoint": "http://" + r.Host + "/authorize",
+			"token_endpoint":         "http://" + r.Host + "/token",
+		})
+	}))
+	defer server.Close()
+
+	p := &Provider{Issuer: server.URL, ClientID: "willow"}
+	if _, _, err := p.AuthCodeURL(context.Background()); err == nil {
+		t.Error("AuthCodeURL() accepted an identity provider without https")
+	}
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package project
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"git.sr.ht/~amolith/willow/advisory"
+	"git.sr.ht/~amolith/willow/db"
+)
+
+// Deployment is a named instance of a project, such as production or staging,
+// that runs its own version.
+type Deployment struct {
+	ID        string
+	ProjectID string
+	Name      string
+	Running   string
+	Notes     string
+	Owner     string
+	// AffectedBy are the security advisories affecting Running
+	AffectedBy []advisory.Advisory
+}
+
+// GenDeploymentID generates a likely-unique ID from its project's ID, its
+// name, and its owner
+func GenDeploymentID(projectID, name, owner string) string {
+	idByte := sha256.Sum256([]byte(projectID + name + owner))
+	return fmt.Sprintf("%x", idByte)
+}
+
+// Latest returns the tag of the project's most recent release or an empty
+// string if it has no releases
+func (p Project) Latest() string {
+	if len(p.Releases) == 0 {
+		return ""
+	}
+	return p.Releases[0].Tag
+}
+
+// Outdated returns true if the version the user is running or the version of
+// any of the project's deployments differs from the latest release
+func (p Project) Outdated() bool {
+	latest := p.Latest()
+	if p.Running != latest {
+		return true
+	}
+	for _, d := range p.Deployments {
+		if d.Running != latest {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDeployments returns all of a project's deployments from the database
+func GetDeployments(dbConn *sql.DB, projectID string) ([]Deployment, error) {
+	rows, err := db.GetDeployments(dbConn, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	deployments := make([]Deployment, len(rows))
+	for i, row := range rows {
+		deployments[i] = deploymentFromRow(row)
+	}
+	return deployments, nil
+}
+
+// GetDeployment returns a single deployment from the database
+func GetDeployment(dbConn *sql.DB, id string) (Deployment, error) {
+	row, err := db.GetDeployment(dbConn, id)
+	if err != nil {
+		return Deployment{}, err
+	}
+	return deploymentFromRow(row), nil
+}
+
+// FindDeployment returns the user's deployment of the project with the given
+// name, ignoring case, and false if they don't have one
+func FindDeployment(dbConn *sql.DB, username, projectID, name string) (Deployment, bool, error) {
+	deployments, err := GetDeployments(dbConn, projectID)
+	if err != nil {
+		return Deployment{}, false, err
+	}
+
+	for _, d := range deployments {
+		if d.Owner == username && strings.EqualFold(d.Name, name) {
+			return d, true, nil
+		}
+	}
+	return Deployment{}, false, nil
+}
+
+// SaveDeployment adds or updates a deployment, generating its ID if it
+// doesn't have one yet
+func SaveDeployment(dbConn *sql.DB, mu *sync.Mutex, d Deployment) (Deployment, error) {
+	if d.ID == "" {
+		d.ID = GenDeploymentID(d.ProjectID, d.Name, d.Owner)
+	}
+	err := db.UpsertDeployment(dbConn, mu, d.ID, d.ProjectID, d.Name, d.Running, d.Notes, d.Owner)
+	return d, err
+}
+
+// DeleteDeployment removes a deployment from the database
+func DeleteDeployment(dbConn *sql.DB, mu *sync.Mutex, id string) error {
+	return db.DeleteDeployment(dbConn, mu, id)
+}
+
+func deploymentFromRow(row map[string]string) Deployment {
+	return Deployment{
+		ID:        row["id"],
+		ProjectID: row["project_id"],
+		Name:      row["name"],
+		Running:   row["version"],
+		Notes:     row["notes"],
+		Owner:     row["owner"],
+	}
+}
@@ -0,0 +1,204 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package project
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+
+	"git.sr.ht/~amolith/willow/audit"
+	"git.sr.ht/~amolith/willow/db"
+)
+
+// Managed is a project listed in the projects file
+type Managed struct {
+	Name    string
+	URL     string
+	Forge   string
+	Running string
+	// Users track the project; every user does when it's empty
+	Users []string
+}
+
+// Change is something reconciling the projects file did, or would do in a
+// dry run. From is the version that was running before, if any.
+type Change struct {
+	Action   string
+	Username string
+	Name     string
+	From     string
+	Running  string
+}
+
+// Kinds of changes
+const (
+	ChangeTrack   = "track"
+	ChangeManage  = "manage"
+	ChangeRunning = "set running"
+	ChangeUntrack = "untrack"
+)
+
+// String formats the change as a line of a diff
+func (c Change) String() string {
+	switch c.Action {
+	case ChangeTrack:
+		return fmt.Sprintf("+ %s: %s %s", c.Username, c.Name, c.Running)
+	case ChangeManage:
+		if c.From != c.Running {
+			return fmt.Sprintf("~ %s: %s %s -> %s (now managed)", c.Username, c.Name, c.From, c.Running)
+		}
+		return fmt.Sprintf("~ %s: %s %s (now managed)", c.Username, c.Name, c.Running)
+	case ChangeRunning:
+		return fmt.Sprintf("~ %s: %s %s -> %s", c.Username, c.Name, c.From, c.Running)
+	default:
+		return fmt.Sprintf("- %s: %s %s", c.Username, c.Name, c.From)
+	}
+}
+
+// desiredProject is a managed project one user should track
+type desiredProject struct {
+	Managed
+	id       string
+	username string
+}
+
+// Reconcile makes the projects each user tracks match the projects file.
+// Listed projects are tracked, or taken over if the user already tracks them,
+// and marked as managed so they can't be changed elsewhere. Managed projects
+// that are no longer listed are untracked; projects tracked by hand never
+// are. Users that don't exist yet are skipped until the next reconcile. With
+// dryRun set, the changes are returned without being made. The caller
+// triggers a refresh afterwards so releases are fetched for new projects.
+func Reconcile(dbConn *sql.DB, mu *sync.Mutex, projects []Managed, dryRun bool) ([]Change, error) {
+	allUsers, err := db.GetUsers(dbConn)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(allUsers)
+	exists := make(map[string]bool, len(allUsers))
+	for _, u := range allUsers {
+		exists[u] = true
+	}
+
+	var desired []desiredProject
+	wanted := make(map[string]bool)
+	for _, m := range projects {
+		if err := validateManaged(m); err != nil {
+			return nil, err
+		}
+		id := GenProjectID(m.URL, m.Name, m.Forge)
+		usernames := m.Users
+		if len(usernames) == 0 {
+			usernames = allUsers
+		}
+		for _, username := range usernames {
+			if !exists[username] {
+				continue
+			}
+			key := username + " " + id
+			if wanted[key] {
+				return nil, fmt.Errorf("%s is listed more than once for %s", m.Name, username)
+			}
+			wanted[key] = true
+			desired = append(desired, desiredProject{Managed: m, id: id, username: username})
+		}
+	}
+
+	var changes []Change
+	for _, d := range desired {
+		existing, err := db.GetUserProject(dbConn, d.username, d.id)
+		var change Change
+		if errors.Is(err, sql.ErrNoRows) {
+			change = Change{Action: ChangeTrack, Username: d.username, Name: d.Name, Running: d.Running}
+		} else if err != nil {
+			return changes, err
+		} else if existing["managed"] != "true" {
+			change = Change{Action: ChangeManage, Username: d.username, Name: d.Name, From: existing["version"], Running: d.Running}
+		} else if existing["version"] != d.Running {
+			change = Change{Action: ChangeRunning, Username: d.username, Name: d.Name, From: existing["version"], Running: d.Running}
+		} else {
+			continue
+		}
+		changes = append(changes, change)
+		if dryRun {
+			continue
+		}
+		if change.Action == ChangeTrack {
+			err = trackManaged(dbConn, mu, d)
+		} else {
+			err = setManagedRunning(dbConn, mu, d, change.From)
+		}
+		if err != nil {
+			return changes, err
+		}
+	}
+
+	managed, err := db.GetManagedProjects(dbConn)
+	if err != nil {
+		return changes, err
+	}
+	for _, m := range managed {
+		if wanted[m["username"]+" "+m["id"]] {
+			continue
+		}
+		changes = append(changes, Change{Action: ChangeUntrack, Username: m["username"], Name: m["name"], From: m["version"]})
+		if !dryRun {
+			untrack(dbConn, mu, audit.ProjectsFile, m["username"], m["id"])
+		}
+	}
+	return changes, nil
+}
+
+// IsManaged reports whether the user's project is managed by the projects
+// file
+func IsManaged(dbConn *sql.DB, username, id string) bool {
+	p, err := db.GetUserProject(dbConn, username, id)
+	return err == nil && p["managed"] == "true"
+}
+
+func validateManaged(m Managed) error {
+	if m.Name == "" {
+		return fmt.Errorf("a project in the projects file has no name")
+	}
+	if !ValidForge(m.Forge) {
+		return fmt.Errorf("%s has unknown forge %q", m.Name, m.Forge)
+	}
+	u, err := url.Parse(m.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return fmt.Errorf("%s has invalid URL %q", m.Name, m.URL)
+	}
+	if m.Running == "" {
+		return fmt.Errorf("%s has no running version", m.Name)
+	}
+	return nil
+}
+
+// trackManaged adds a managed project to the user's list
+func trackManaged(dbConn *sql.DB, mu *sync.Mutex, d desiredProject) error {
+	if err := db.UpsertProject(dbConn, mu, d.id, d.URL, d.Name, d.Forge); err != nil {
+		return err
+	}
+	if err := db.UpsertUserProject(dbConn, mu, d.username, d.id, d.Running); err != nil {
+		return err
+	}
+	audit.Record(dbConn, audit.ProjectsFile, audit.Track, d.Name, "for "+d.username+" running "+d.Running)
+	return db.SetManaged(dbConn, mu, d.username, d.id, true)
+}
+
+// setManagedRunning takes over a project the user already tracks and sets
+// the version they're running
+func setManagedRunning(dbConn *sql.DB, mu *sync.Mutex, d desiredProject, from string) error {
+	if from != d.Running {
+		if err := db.UpsertUserProject(dbConn, mu, d.username, d.id, d.Running); err != nil {
+			return err
+		}
+		audit.Record(dbConn, audit.ProjectsFile, audit.SetRunning, d.Name, "for "+d.username+" from "+from+" to "+d.Running)
+	}
+	return db.SetManaged(dbConn, mu, d.username, d.id, true)
+}
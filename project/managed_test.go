@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package project
+
+import (
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"git.sr.ht/~amolith/willow/db"
+)
+
+func TestReconcile(t *testing.T) {
+	dbConn, err := db.Open(filepath.Join(t.TempDir(), "willow.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbConn.Close()
+	if err := db.InitialiseDatabase(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	mu := &sync.Mutex{}
+	for _, username := range []string{"alice", "bob"} {
+		if err := db.CreateUser(dbConn, username, "hash", "salt", "editor"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	willow := Managed{Name: "Willow", URL: "https://git.sr.ht/~amolith/willow", Forge: "sourcehut", Running: "v0.0.1"}
+	toml := Managed{Name: "toml", URL: "https://github.com/BurntSushi/toml", Forge: "github", Running: "v1.3.2", Users: []string{"alice", "carol"}}
+	tomlID := GenProjectID(toml.URL, toml.Name, toml.Forge)
+	willowID := GenProjectID(willow.URL, willow.Name, willow.Forge)
+
+	// Tracked by hand before the file listed it, and never listed at all
+	Track(dbConn, mu, nil, "alice", toml.Name, toml.URL, toml.Forge, "v1.3.0")
+	Track(dbConn, mu, nil, "alice", "Manual", "https://example.com/manual", "other", "1.0")
+
+	changes, err := Reconcile(dbConn, mu, []Managed{willow, toml}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{Action: ChangeTrack, Username: "alice", Name: "Willow", Running: "v0.0.1"},
+		{Action: ChangeTrack, Username: "bob", Name: "Willow", Running: "v0.0.1"},
+		{Action: ChangeManage, Username: "alice", Name: "toml", From: "v1.3.0", Running: "v1.3.2"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("dry run = %v, want %v", changes, want)
+	}
+	if _, err := db.GetUserProject(dbConn, "bob", willowID); err == nil {
+		t.Error("dry run tracked a project")
+	}
+
+	if _, err := Reconcile(dbConn, mu, []Managed{willow, toml}, false); err != nil {
+		t.Fatal(err)
+	}
+	if !IsManaged(dbConn, "alice", tomlID) || !IsManaged(dbConn, "bob", willowID) {
+		t.Error("reconciled projects aren't managed")
+	}
+
+	// Managed projects can't be changed elsewhere
+	Track(dbConn, mu, nil, "alice", toml.Name, toml.URL, toml.Forge, "v0.1.0")
+	Untrack(dbConn, mu, "bob", willowID)
+	if p, err := db.GetUserProject(dbConn, "alice", tomlID); err != nil || p["version"] != "v1.3.2" {
+		t.Errorf("toml is running %q, %v; want v1.3.2", p["version"], err)
+	}
+	if !IsManaged(dbConn, "bob", willowID) {
+		t.Error("a managed project was untracked by hand")
+	}
+
+	// Reconciling again changes nothing
+	if changes, err := Reconcile(dbConn, mu, []Managed{willow, toml}, false); err != nil || len(changes) != 0 {
+		t.Errorf("second reconcile = %v, %v; want no changes", changes, err)
+	}
+
+	willow.Running = "v0.0.2"
+	willow.Users = []string{"alice"}
+	changes, err = Reconcile(dbConn, mu, []Managed{willow}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []Change{
+		{Action: ChangeRunning, Username: "alice", Name: "Willow", From: "v0.0.1", Running: "v0.0.2"},
+		{Action: ChangeUntrack, Username: "alice", Name: "toml", From: "v1.3.2"},
+		{Action: ChangeUntrack, Username: "bob", Name: "Willow", From: "v0.0.1"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("reconcile after editing = %v, want %v", changes, want)
+	}
+	if _, err := db.GetUserProject(dbConn, "alice", GenProjectID("https://example.com/manual", "Manual", "other")); err != nil {
+		t.Error("the project tracked by hand was untracked")
+	}
+
+	if _, err := Reconcile(dbConn, mu, []Managed{willow, willow}, true); err == nil {
+		t.Error("Reconcile() accepted a project listed twice")
+	}
+	if _, err := Reconcile(dbConn, mu, []Managed{{Name: "bad", URL: "https://example.com", Forge: "svn", Running: "1"}}, true); err == nil {
+		t.Error("Reconcile() accepted an unknown forge")
+	}
+}
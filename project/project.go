@@ -17,18 +17,30 @@ import (
 
 	"github.com/unascribed/FlexVer/go/flexver"
 
+	"git.sr.ht/~amolith/willow/advisory"
+	"git.sr.ht/~amolith/willow/audit"
 	"git.sr.ht/~amolith/willow/db"
 	"git.sr.ht/~amolith/willow/git"
+	"git.sr.ht/~amolith/willow/notify"
+	"git.sr.ht/~amolith/willow/oci"
 	"git.sr.ht/~amolith/willow/rss"
 )
 
 type Project struct {
-	ID       string
-	URL      string
-	Name     string
-	Forge    string
-	Running  string
-	Releases []Release
+	ID      string
+	URL     string
+	Name    string
+	Forge   string
+	Running string
+	// Managed projects are listed in the projects file and can only be
+	// changed there
+	Managed     bool
+	Releases    []Release
+	Deployments []Deployment
+	// Advisories are all the security advisories that mention the project
+	Advisories []advisory.Advisory
+	// AffectedBy are the advisories affecting Running
+	AffectedBy []advisory.Advisory
 }
 
 type Release struct {
@@ -40,35 +52,45 @@ type Release struct {
 	Date      time.Time
 }
 
-// GetReleases returns a list of all releases for a project from the database
+// GetReleases returns a list of all releases for a project from the database,
+// fetching them first if none are stored yet
 func GetReleases(dbConn *sql.DB, mu *sync.Mutex, proj Project) (Project, error) {
-	proj.ID = GenProjectID(proj.URL, proj.Name, proj.Forge)
+	proj, err := GetStoredReleases(dbConn, proj)
+	if err != nil || len(proj.Releases) > 0 {
+		return proj, err
+	}
 
-	ret, err := db.GetReleases(dbConn, proj.ID)
+	proj, err = fetchReleases(dbConn, mu, proj)
+	if err != nil {
+		return proj, err
+	}
+	err = upsertReleases(dbConn, mu, proj.ID, proj.Releases)
 	if err != nil {
 		return proj, err
 	}
+	return proj, nil
+}
 
-	if len(ret) == 0 {
-		proj, err = fetchReleases(dbConn, mu, proj)
-		if err != nil {
-			return proj, err
-		}
-		err = upsertReleases(dbConn, mu, proj.ID, proj.Releases)
-		if err != nil {
-			return proj, err
-		}
-		return proj, nil
+// GetStoredReleases returns a list of the releases stored for a project in the
+// database without fetching any
+func GetStoredReleases(dbConn *sql.DB, proj Project) (Project, error) {
+	proj.ID = GenProjectID(proj.URL, proj.Name, proj.Forge)
+
+	ret, err := db.GetReleases(dbConn, proj.ID)
+	if err != nil {
+		return proj, err
 	}
 
 	for _, row := range ret {
+		// Dates that fail to parse are left as the zero time
+		date, _ := time.Parse(time.RFC3339, row["date"])
 		proj.Releases = append(proj.Releases, Release{
 			ID:        row["id"],
 			ProjectID: proj.ID,
 			Tag:       row["tag"],
 			Content:   row["content"],
-			URL:       row["release_url"],
-			Date:      time.Time{},
+			URL:       row["url"],
+			Date:      date,
 		})
 	}
 	proj.Releases = SortReleases(proj.Releases)
@@ -99,6 +121,25 @@ func fetchReleases(dbConn *sql.DB, mu *sync.Mutex, p Project) (Project, error) {
 				return p, err
 			}
 		}
+	case "container":
+		ociReleases, err := oci.GetReleases(p.URL)
+		if err != nil {
+			return p, err
+		}
+		for _, release := range ociReleases {
+			p.Releases = append(p.Releases, Release{
+				ID:      GenReleaseID(p.URL, release.URL, release.Tag),
+				Tag:     release.Tag,
+				Content: release.Content,
+				URL:     release.URL,
+				Date:    release.Date,
+			})
+		}
+		err = upsertReleases(dbConn, mu, p.ID, p.Releases)
+		if err != nil {
+			log.Printf("Error upserting release: %v", err)
+			return p, err
+		}
 	default:
 		gitReleases, err := git.GetReleases(p.URL, p.Forge)
 		if err != nil {
@@ -156,25 +197,133 @@ func GenReleaseID(projectURL, releaseURL, tag string) string {
 	return fmt.Sprintf("%x", idByte)
 }
 
+// forges are the forge types Willow can fetch releases from
+var forges = map[string]bool{
+	"github":    true,
+	"gitea":     true,
+	"forgejo":   true,
+	"gitlab":    true,
+	"sourcehut": true,
+	"bitbucket": true,
+	"other":     true,
+	"container": true,
+}
+
+// ValidForge reports whether Willow can fetch releases from the forge type
+func ValidForge(forge string) bool {
+	return forges[forge]
+}
+
 // GenProjectID generates a likely-unique ID from a project's URI, name, and forge
 func GenProjectID(url, name, forge string) string {
 	idByte := sha256.Sum256([]byte(url + name + forge))
 	return fmt.Sprintf("%x", idByte)
 }
 
-func Track(dbConn *sql.DB, mu *sync.Mutex, manualRefresh *chan struct{}, name, url, forge, release string) {
+// Track adds a project to the user's list, or updates the version they're
+// running if it's already there, and triggers a refresh. Callers tracking
+// several projects at once pass a nil manualRefresh and trigger one refresh
+// themselves afterwards. Projects managed by the projects file are left alone.
+func Track(dbConn *sql.DB, mu *sync.Mutex, manualRefresh *chan struct{}, username, name, url, forge, release string) {
 	id := GenProjectID(url, name, forge)
-	err := db.UpsertProject(dbConn, mu, id, url, name, forge, release)
+	existing, err := db.GetUserProject(dbConn, username, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		audit.Record(dbConn, username, audit.Track, name, "running "+release)
+	} else if err != nil {
+		fmt.Println("Error getting user's project:", err)
+	} else if existing["managed"] == "true" {
+		fmt.Printf("Not changing %s for %s because it's managed by the projects file\n", name, username)
+		return
+	} else if existing["version"] != release {
+		audit.Record(dbConn, username, audit.SetRunning, name, "from "+existing["version"]+" to "+release)
+	}
+
+	err = db.UpsertProject(dbConn, mu, id, url, name, forge)
 	if err != nil {
 		fmt.Println("Error upserting project:", err)
 	}
-	*manualRefresh <- struct{}{}
+	err = db.UpsertUserProject(dbConn, mu, username, id, release)
+	if err != nil {
+		fmt.Println("Error upserting user's project:", err)
+	}
+	if manualRefresh != nil {
+		*manualRefresh <- struct{}{}
+	}
+}
+
+// Untrack removes a project from the user's list. When nobody else tracks the
+// project, it's deleted along with its releases and Willow's copy of its repo.
+// Projects managed by the projects file are left alone.
+func Untrack(dbConn *sql.DB, mu *sync.Mutex, username, id string) {
+	existing, err := db.GetUserProject(dbConn, username, id)
+	if err == nil && existing["managed"] == "true" {
+		fmt.Printf("Not untracking %s for %s because it's managed by the projects file\n", existing["name"], username)
+		return
+	}
+	untrack(dbConn, mu, username, username, id)
 }
 
-func Untrack(dbConn *sql.DB, mu *sync.Mutex, id string) {
+// ErrManaged is returned when changing a project that's managed by the
+// projects file
+var ErrManaged = errors.New("managed by the projects file")
+
+// SetRunning sets the version of the project the user is running or, when
+// deployment is set, the version that deployment is running, adding it for
+// the user if the project doesn't have one by that name. Notes replace the
+// deployment's notes unless they're empty.
+func SetRunning(dbConn *sql.DB, mu *sync.Mutex, username string, p Project, version, deployment, notes string) error {
+	if deployment == "" {
+		if p.Managed {
+			return ErrManaged
+		}
+		Track(dbConn, mu, nil, username, p.Name, p.URL, p.Forge, version)
+		return nil
+	}
+
+	d, ok, err := FindDeployment(dbConn, username, p.ID, deployment)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		d = Deployment{ProjectID: p.ID, Name: deployment, Owner: username}
+	}
+	d.Running = version
+	if notes != "" {
+		d.Notes = notes
+	}
+	if _, err := SaveDeployment(dbConn, mu, d); err != nil {
+		return err
+	}
+	audit.Record(dbConn, username, audit.SaveDeployment, d.Name, "running "+d.Running)
+	return nil
+}
+
+// untrack removes a project from the user's list on behalf of actor
+func untrack(dbConn *sql.DB, mu *sync.Mutex, actor, username, id string) {
 	proj, err := db.GetProject(dbConn, id)
 	if err != nil {
 		fmt.Println("Error getting project:", err)
+		return
+	}
+
+	err = db.DeleteUserProject(dbConn, mu, username, id)
+	if err != nil {
+		fmt.Println("Error removing project from user's list:", err)
+		return
+	}
+	details := ""
+	if actor != username {
+		details = "for " + username
+	}
+	audit.Record(dbConn, actor, audit.Untrack, proj["name"], details)
+
+	count, err := db.CountProjectUsers(dbConn, id)
+	if err != nil {
+		fmt.Println("Error counting project's users:", err)
+		return
+	}
+	if count > 0 {
+		return
 	}
 
 	err = db.DeleteProject(dbConn, mu, proj["id"])
@@ -190,7 +339,57 @@ func Untrack(dbConn *sql.DB, mu *sync.Mutex, id string) {
 	}
 }
 
-func RefreshLoop(dbConn *sql.DB, mu *sync.Mutex, interval int, manualRefresh, req *chan struct{}, res *chan []Project) {
+// Refresh fetches the latest releases of each project and stores them,
+// announcing any that weren't known before, and returns the projects sorted by
+// name. It waits for the announcements to be sent before returning.
+func Refresh(dbConn *sql.DB, mu *sync.Mutex, projects []Project, notifiers []notify.Notifier) []Project {
+	var announcing sync.WaitGroup
+	for i, p := range projects {
+		known, err := knownReleaseIDs(dbConn, p.ID)
+		if err != nil {
+			fmt.Println("Error getting known releases:", err)
+		}
+		p, err := fetchReleases(dbConn, mu, p)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		projects[i] = p
+		// Projects without any stored releases were only just tracked, so
+		// every release would otherwise be announced as new.
+		if len(known) > 0 && len(notifiers) > 0 {
+			announcing.Add(1)
+			go func() {
+				defer announcing.Done()
+				announceNewReleases(notifiers, p, known)
+			}()
+		}
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		return strings.ToLower(projects[i].Name) < strings.ToLower(projects[j].Name)
+	})
+	for i := range projects {
+		err := upsertReleases(dbConn, mu, projects[i].ID, projects[i].Releases)
+		if err != nil {
+			fmt.Println("Error upserting release:", err)
+			continue
+		}
+	}
+	announcing.Wait()
+	return projects
+}
+
+// RequestRefresh asks the refresh loop to fetch releases without waiting for
+// it. The channel holds one request, so requests made while one is already
+// pending are merged into it.
+func RequestRefresh(manualRefresh *chan struct{}) {
+	select {
+	case *manualRefresh <- struct{}{}:
+	default:
+	}
+}
+
+func RefreshLoop(dbConn *sql.DB, mu *sync.Mutex, interval int, manualRefresh, req *chan struct{}, res *chan []Project, notifiers []notify.Notifier, advisories []advisory.Source) {
 	ticker := time.NewTicker(time.Second * time.Duration(interval))
 
 	fetch := func() []Project {
@@ -198,23 +397,9 @@ func RefreshLoop(dbConn *sql.DB, mu *sync.Mutex, interval int, manualRefresh, re
 		if err != nil {
 			fmt.Println("Error getting projects:", err)
 		}
-		for i, p := range projectsList {
-			p, err := fetchReleases(dbConn, mu, p)
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
-			projectsList[i] = p
-		}
-		sort.Slice(projectsList, func(i, j int) bool {
-			return strings.ToLower(projectsList[i].Name) < strings.ToLower(projectsList[j].Name)
-		})
-		for i := range projectsList {
-			err = upsertReleases(dbConn, mu, projectsList[i].ID, projectsList[i].Releases)
-			if err != nil {
-				fmt.Println("Error upserting release:", err)
-				continue
-			}
+		projectsList = Refresh(dbConn, mu, projectsList, notifiers)
+		if len(advisories) > 0 {
+			advisory.Refresh(dbConn, mu, advisories, notifiers)
 		}
 		return projectsList
 	}
@@ -236,9 +421,39 @@ func RefreshLoop(dbConn *sql.DB, mu *sync.Mutex, interval int, manualRefresh, re
 	}
 }
 
-// GetProject returns a project from the database
-func GetProject(dbConn *sql.DB, proj Project) (Project, error) {
-	projectDB, err := db.GetProject(dbConn, proj.ID)
+// knownReleaseIDs returns the set of release IDs already stored for a project
+func knownReleaseIDs(dbConn *sql.DB, projectID string) (map[string]bool, error) {
+	rows, err := db.GetReleases(dbConn, projectID)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		known[row["id"]] = true
+	}
+	return known, nil
+}
+
+// announceNewReleases sends a notification for each of the project's releases
+// that wasn't previously known
+func announceNewReleases(notifiers []notify.Notifier, p Project, known map[string]bool) {
+	for _, release := range p.Releases {
+		if known[release.ID] {
+			continue
+		}
+		notify.All(notifiers, notify.Release{
+			Project: p.Name,
+			Tag:     release.Tag,
+			URL:     release.URL,
+			Content: release.Content,
+		})
+	}
+}
+
+// GetProject returns a project from the database along with the version the
+// user is running
+func GetProject(dbConn *sql.DB, username string, proj Project) (Project, error) {
+	projectDB, err := db.GetUserProject(dbConn, username, proj.ID)
 	if err != nil && errors.Is(err, sql.ErrNoRows) {
 		return proj, nil
 	} else if err != nil {
@@ -255,8 +470,8 @@ func GetProject(dbConn *sql.DB, proj Project) (Project, error) {
 }
 
 // GetProjectWithReleases returns a single project from the database along with its releases
-func GetProjectWithReleases(dbConn *sql.DB, mu *sync.Mutex, proj Project) (Project, error) {
-	project, err := GetProject(dbConn, proj)
+func GetProjectWithReleases(dbConn *sql.DB, mu *sync.Mutex, username string, proj Project) (Project, error) {
+	project, err := GetProject(dbConn, username, proj)
 	if err != nil {
 		return Project{}, err
 	}
@@ -264,41 +479,137 @@ func GetProjectWithReleases(dbConn *sql.DB, mu *sync.Mutex, proj Project) (Proje
 	return GetReleases(dbConn, mu, project)
 }
 
-// GetProjects returns a list of all projects from the database
+// GetProjects returns a list of all projects tracked by any user from the
+// database. Running versions are per-user, so they're left empty.
 func GetProjects(dbConn *sql.DB) ([]Project, error) {
 	projectsDB, err := db.GetProjects(dbConn)
 	if err != nil {
 		return nil, err
 	}
 
-	projects := make([]Project, len(projectsDB))
-	for i, p := range projectsDB {
+	return SortProjects(projectsFromRows(projectsDB)), nil
+}
+
+// GetUserProjects returns a list of all projects the user tracks from the
+// database
+func GetUserProjects(dbConn *sql.DB, username string) ([]Project, error) {
+	projectsDB, err := db.GetUserProjects(dbConn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return SortProjects(projectsFromRows(projectsDB)), nil
+}
+
+// RepoKey identifies a project's repo by its forge and URL, ignoring case, a
+// trailing slash, and a .git suffix, which all point at the same repo
+func RepoKey(forge, url string) string {
+	url = strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
+	return forge + " " + strings.ToLower(url)
+}
+
+// GetProjectsByRepo maps the RepoKey of every project tracked by any user to
+// it, so projects added in bulk aren't tracked a second time under another
+// name or spelling of their URL
+func GetProjectsByRepo(dbConn *sql.DB) (map[string]Project, error) {
+	projects, err := GetProjects(dbConn)
+	if err != nil {
+		return nil, err
+	}
+	byRepo := make(map[string]Project, len(projects))
+	for _, p := range projects {
+		byRepo[RepoKey(p.Forge, p.URL)] = p
+	}
+	return byRepo, nil
+}
+
+// ErrAmbiguous is returned by Find when more than one project matches
+var ErrAmbiguous = errors.New("more than one project matches")
+
+// Find returns the project the user tracks with the given ID, URL, or name,
+// ignoring case. It returns sql.ErrNoRows if there's no such project.
+func Find(dbConn *sql.DB, username, query string) (Project, error) {
+	projects, err := GetUserProjects(dbConn, username)
+	if err != nil {
+		return Project{}, err
+	}
+
+	var matches []Project
+	for _, p := range projects {
+		if p.ID == query {
+			return p, nil
+		}
+		if strings.EqualFold(p.URL, query) || strings.EqualFold(p.Name, query) {
+			matches = append(matches, p)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return Project{}, sql.ErrNoRows
+	case 1:
+		return matches[0], nil
+	default:
+		return Project{}, ErrAmbiguous
+	}
+}
+
+// projectsFromRows converts database rows to projects
+func projectsFromRows(rows []map[string]string) []Project {
+	projects := make([]Project, len(rows))
+	for i, p := range rows {
 		projects[i] = Project{
 			ID:      p["id"],
 			URL:     p["url"],
 			Name:    p["name"],
 			Forge:   p["forge"],
 			Running: p["version"],
+			Managed: p["managed"] == "true",
 		}
 	}
+	return projects
+}
 
-	return SortProjects(projects), nil
+// GetProjectsWithReleases returns a list of all projects the user tracks and
+// all their releases, deployments, and the advisories affecting them from the
+// database
+func GetProjectsWithReleases(dbConn *sql.DB, mu *sync.Mutex, username string) ([]Project, error) {
+	return projectsWithReleases(dbConn, username, func(p Project) (Project, error) {
+		return GetReleases(dbConn, mu, p)
+	})
 }
 
-// GetProjectsWithReleases returns a list of all projects and all their releases
-// from the database
-func GetProjectsWithReleases(dbConn *sql.DB, mu *sync.Mutex) ([]Project, error) {
-	projects, err := GetProjects(dbConn)
+// GetStoredProjectsWithReleases is GetProjectsWithReleases without fetching
+// the releases of projects that don't have any stored yet
+func GetStoredProjectsWithReleases(dbConn *sql.DB, username string) ([]Project, error) {
+	return projectsWithReleases(dbConn, username, func(p Project) (Project, error) {
+		return GetStoredReleases(dbConn, p)
+	})
+}
+
+func projectsWithReleases(dbConn *sql.DB, username string, getReleases func(Project) (Project, error)) ([]Project, error) {
+	projects, err := GetUserProjects(dbConn, username)
 	if err != nil {
 		return nil, err
 	}
 
 	for i := range projects {
-		projects[i], err = GetReleases(dbConn, mu, projects[i])
+		projects[i], err = getReleases(projects[i])
 		if err != nil {
 			return nil, err
 		}
 		projects[i].Releases = SortReleases(projects[i].Releases)
+		projects[i].Deployments, err = GetDeployments(dbConn, projects[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		projects[i].Advisories, err = advisory.ForProject(dbConn, projects[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		projects[i].AffectedBy = advisory.Affecting(projects[i].Advisories, projects[i].Running)
+		for j, d := range projects[i].Deployments {
+			projects[i].Deployments[j].AffectedBy = advisory.Affecting(projects[i].Advisories, d.Running)
+		}
 	}
 
 	return SortProjects(projects), nil
@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import (
+	"database/sql"
+	"errors"
+
+	"git.sr.ht/~amolith/willow/db"
+)
+
+// APIToken describes a user's API token without revealing it
+type APIToken struct {
+	CreatedAt string
+	// LastUsed is empty if the token hasn't been used yet
+	LastUsed string
+}
+
+// GetAPIToken returns the user's API token and false if they don't have one.
+func GetAPIToken(dbConn *sql.DB, username string) (APIToken, bool, error) {
+	row, err := db.GetAPIToken(dbConn, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIToken{}, false, nil
+	} else if err != nil {
+		return APIToken{}, false, err
+	}
+	return APIToken{CreatedAt: row["created_at"], LastUsed: row["last_used"]}, true, nil
+}
+
+// GenerateAPIToken gives the user a new API token, replacing any they had,
+// and returns it. Only its hash is stored, so it can't be shown again.
+func GenerateAPIToken(dbConn *sql.DB, username string) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	return token, db.UpsertAPIToken(dbConn, username, hashToken(token))
+}
+
+// RevokeAPIToken deletes the user's API token.
+func RevokeAPIToken(dbConn *sql.DB, username string) error {
+	return db.DeleteAPIToken(dbConn, username)
+}
+
+// APITokenUser returns the user an API token belongs to and false if the token
+// is invalid, recording that it was used.
+func APITokenUser(dbConn *sql.DB, token string) (string, bool, error) {
+	if token == "" {
+		return "", false, nil
+	}
+	hash := hashToken(token)
+	username, err := db.GetAPITokenUser(dbConn, hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return username, true, db.TouchAPIToken(dbConn, hash)
+}
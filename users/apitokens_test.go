@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import "testing"
+
+func TestAPITokens(t *testing.T) {
+	dbConn := testDB(t)
+
+	if _, ok, err := GetAPIToken(dbConn, "alice"); err != nil || ok {
+		t.Fatalf("GetAPIToken() before generating = %v, %v; want no token", ok, err)
+	}
+
+	old, err := GenerateAPIToken(dbConn, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := GenerateAPIToken(dbConn, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := APITokenUser(dbConn, old); ok {
+		t.Error("a replaced token still works")
+	}
+	if username, ok, err := APITokenUser(dbConn, token); err != nil || !ok || username != "alice" {
+		t.Errorf("APITokenUser() = %q, %v, %v; want alice", username, ok, err)
+	}
+	if _, ok, _ := APITokenUser(dbConn, ""); ok {
+		t.Error("an empty token works")
+	}
+
+	info, ok, err := GetAPIToken(dbConn, "alice")
+	if err != nil || !ok || info.LastUsed == "" {
+		t.Errorf("GetAPIToken() after use = %+v, %v, %v; want LastUsed set", info, ok, err)
+	}
+	var stored string
+	if err := dbConn.QueryRow("SELECT token_hash FROM api_tokens").Scan(&stored); err != nil || stored == token {
+		t.Errorf("stored %q, %v; want a hash of the token", stored, err)
+	}
+
+	if err := RevokeAPIToken(dbConn, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := APITokenUser(dbConn, token); ok {
+		t.Error("a revoked token still works")
+	}
+}
@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"git.sr.ht/~amolith/willow/db"
+)
+
+var (
+	// ErrLocalAccount is returned when someone signs in through an identity
+	// provider with the username of an account that has a local password.
+	ErrLocalAccount = errors.New("a local account with that username already exists")
+	// ErrIdentityTaken is returned when someone signs in through an identity
+	// provider with the username of an account tied to someone else there.
+	ErrIdentityTaken = errors.New("another single sign-on account already uses that username")
+)
+
+// ExternalLogin checks that a user authenticated by an identity provider may
+// log in and returns the username of their account. Accounts are tied to the
+// issuer and subject the first time they're used, and from then on the
+// subject alone decides which account is logged into, because users can often
+// change their username at the identity provider. The username is only used
+// to name new accounts, which are created with the given role. Accounts
+// created this way have no password, so they can't log in with the login
+// form, and accounts with a password can't be taken over through the identity
+// provider.
+func ExternalLogin(dbConn *sql.DB, issuer, subject, username, role string) (string, error) {
+	bound, err := db.GetOIDCIdentityUser(dbConn, issuer, subject)
+	if err == nil {
+		return bound, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	hash, _, err := db.GetUser(dbConn, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		if !ValidRole(role) {
+			return "", fmt.Errorf("invalid role %q, must be %s, %s, or %s", role, RoleAdmin, RoleEditor, RoleViewer)
+		}
+		if err := db.CreateUser(dbConn, username, "", "", role); err != nil {
+			return "", err
+		}
+		return username, db.InsertOIDCIdentity(dbConn, issuer, subject, username)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if hash != "" {
+		return "", ErrLocalAccount
+	}
+	// Accounts created before they were tied to a subject are tied to the
+	// first one that logs into them
+	taken, err := db.HasOIDCIdentity(dbConn, username)
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return "", ErrIdentityTaken
+	}
+	return username, db.InsertOIDCIdentity(dbConn, issuer, subject, username)
+}
+
+// ProxyLogin makes sure a user authenticated by a trusted reverse proxy has an
+// account, creating it with the given role if it doesn't exist yet, and
+// reports whether it was created. Unlike ExternalLogin, existing accounts with
+// a password are allowed because the proxy is trusted to vouch for them.
+func ProxyLogin(dbConn *sql.DB, username, role string) (bool, error) {
+	_, _, err := db.GetUser(dbConn, username)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	if !ValidRole(role) {
+		return false, fmt.Errorf("invalid role %q, must be %s, %s, or %s", role, RoleAdmin, RoleEditor, RoleViewer)
+	}
+	err = db.CreateUser(dbConn, username, "", "", role)
+	if err != nil {
+		// Another request from the same user may have created it first
+		if _, _, getErr := db.GetUser(dbConn, username); getErr == nil {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
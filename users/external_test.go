@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestProxyLogin(t *testing.T) {
+	dbConn := testDB(t)
+
+	created, err := ProxyLogin(dbConn, "alice", RoleViewer)
+	if err != nil || created {
+		t.Fatalf("ProxyLogin(alice) = %v, %v; want existing account with a password to be used", created, err)
+	}
+	if role, err := GetRole(dbConn, "alice"); err != nil || role != RoleAdmin {
+		t.Errorf("alice's role = %q, %v; want it left as %q", role, err, RoleAdmin)
+	}
+
+	created, err = ProxyLogin(dbConn, "bob", RoleEditor)
+	if err != nil || !created {
+		t.Fatalf("ProxyLogin(bob) = %v, %v; want account created", created, err)
+	}
+	if role, err := GetRole(dbConn, "bob"); err != nil || role != RoleEditor {
+		t.Errorf("bob's role = %q, %v; want %q", role, err, RoleEditor)
+	}
+	if created, err := ProxyLogin(dbConn, "bob", RoleEditor); err != nil || created {
+		t.Errorf("second ProxyLogin(bob) = %v, %v; want existing account", created, err)
+	}
+	if ok, err := UserAuthorised(dbConn, "bob", ""); err != nil || ok {
+		t.Errorf("UserAuthorised(bob, \"\") = %v, %v; want accounts from the proxy to have no password", ok, err)
+	}
+
+	if _, err := ProxyLogin(dbConn, "carol", "owner"); err == nil {
+		t.Error("ProxyLogin with an invalid role succeeded")
+	}
+	if _, err := ExternalLogin(dbConn, "https://idp.example", "1", "alice", RoleViewer); !errors.Is(err, ErrLocalAccount) {
+		t.Errorf("ExternalLogin(alice) = %v, want %v", err, ErrLocalAccount)
+	}
+}
+
+func TestExternalLogin(t *testing.T) {
+	dbConn := testDB(t)
+	const issuer = "https://idp.example"
+
+	if username, err := ExternalLogin(dbConn, issuer, "1", "carol", RoleEditor); err != nil || username != "carol" {
+		t.Fatalf("ExternalLogin(carol) = %q, %v; want account created", username, err)
+	}
+	if role, err := GetRole(dbConn, "carol"); err != nil || role != RoleEditor {
+		t.Errorf("carol's role = %q, %v; want %q", role, err, RoleEditor)
+	}
+
+	// Renaming oneself at the identity provider leads to the same account
+	if username, err := ExternalLogin(dbConn, issuer, "1", "caroline", RoleEditor); err != nil || username != "carol" {
+		t.Errorf("ExternalLogin() after renaming = %q, %v; want carol", username, err)
+	}
+	// and renaming oneself to someone else doesn't lead to theirs
+	if _, err := ExternalLogin(dbConn, issuer, "2", "carol", RoleEditor); !errors.Is(err, ErrIdentityTaken) {
+		t.Errorf("ExternalLogin() as carol with another subject = %v, want %v", err, ErrIdentityTaken)
+	}
+	if _, err := ExternalLogin(dbConn, "https://other.example", "1", "carol", RoleEditor); !errors.Is(err, ErrIdentityTaken) {
+		t.Errorf("ExternalLogin() as carol from another issuer = %v, want %v", err, ErrIdentityTaken)
+	}
+
+	// Accounts without a password that aren't tied to a subject yet are tied
+	// to the first one to log in
+	if _, err := ProxyLogin(dbConn, "dave", RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	if username, err := ExternalLogin(dbConn, issuer, "3", "dave", RoleViewer); err != nil || username != "dave" {
+		t.Errorf("ExternalLogin(dave) = %q, %v; want dave", username, err)
+	}
+	if _, err := ExternalLogin(dbConn, issuer, "4", "dave", RoleViewer); !errors.Is(err, ErrIdentityTaken) {
+		t.Errorf("ExternalLogin() as dave with another subject = %v, want %v", err, ErrIdentityTaken)
+	}
+}
@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"git.sr.ht/~amolith/willow/db"
+)
+
+// generateToken generates a random URL-safe token and returns it as a
+// hex-encoded string.
+func generateToken() (string, error) {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// hashToken returns the hex-encoded SHA-256 hash of a token, which is what's
+// stored in place of feed and API tokens.
+func hashToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// FeedTokenCreated returns when the user's feed token was created and false if
+// they don't have one.
+func FeedTokenCreated(dbConn *sql.DB, username string) (string, bool, error) {
+	createdAt, err := db.GetFeedToken(dbConn, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return createdAt, true, nil
+}
+
+// RegenerateFeedToken replaces the user's feed token with a new one,
+// invalidating any subscriptions using the old token, and returns it. Only its
+// hash is stored, so it can't be shown again.
+func RegenerateFeedToken(dbConn *sql.DB, username string) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	return token, db.UpsertFeedToken(dbConn, username, hashToken(token))
+}
+
+// FeedTokenUser returns the user a feed token belongs to and false if the token
+// is invalid.
+func FeedTokenUser(dbConn *sql.DB, token string) (string, bool, error) {
+	if token == "" {
+		return "", false, nil
+	}
+	username, err := db.GetFeedTokenUser(dbConn, hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return username, true, nil
+}
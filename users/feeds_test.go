@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import "testing"
+
+func TestFeedTokens(t *testing.T) {
+	dbConn := testDB(t)
+
+	if _, ok, err := FeedTokenCreated(dbConn, "alice"); err != nil || ok {
+		t.Fatalf("FeedTokenCreated() before generating = %v, %v; want no token", ok, err)
+	}
+
+	old, err := RegenerateFeedToken(dbConn, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := RegenerateFeedToken(dbConn, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := FeedTokenUser(dbConn, old); ok {
+		t.Error("a replaced token still works")
+	}
+	if username, ok, err := FeedTokenUser(dbConn, token); err != nil || !ok || username != "alice" {
+		t.Errorf("FeedTokenUser() = %q, %v, %v; want alice", username, ok, err)
+	}
+	if _, ok, _ := FeedTokenUser(dbConn, ""); ok {
+		t.Error("an empty token works")
+	}
+
+	if createdAt, ok, err := FeedTokenCreated(dbConn, "alice"); err != nil || !ok || createdAt == "" {
+		t.Errorf("FeedTokenCreated() = %q, %v, %v; want a date", createdAt, ok, err)
+	}
+	var stored string
+	if err := dbConn.QueryRow("SELECT token_hash FROM feed_tokens").Scan(&stored); err != nil || stored == token {
+		t.Errorf("stored %q, %v; want a hash of the token", stored, err)
+	}
+}
@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// HashParams are the argon2id parameters passwords are hashed with. Memory is
+// in KiB.
+type HashParams struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+// DefaultHashParams are used for any parameter that isn't configured
+var DefaultHashParams = HashParams{
+	Time:    2,
+	Memory:  64 * 1024,
+	Threads: 4,
+	KeyLen:  32,
+	SaltLen: 16,
+}
+
+// legacyHashParams are what hashes were created with before they were stored
+// in PHC format along with their parameters
+var legacyHashParams = HashParams{Time: 2, Memory: 64 * 1024, Threads: 4}
+
+// hashParams are the parameters new hashes are created with
+var hashParams = DefaultHashParams
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// SetHashParams changes the parameters new password hashes are created with.
+// Zero values are replaced with the defaults. Existing hashes keep working and
+// are upgraded the next time their user logs in.
+func SetHashParams(p HashParams) error {
+	if p.Time == 0 {
+		p.Time = DefaultHashParams.Time
+	}
+	if p.Memory == 0 {
+		p.Memory = DefaultHashParams.Memory
+	}
+	if p.Threads == 0 {
+		p.Threads = DefaultHashParams.Threads
+	}
+	if p.KeyLen == 0 {
+		p.KeyLen = DefaultHashParams.KeyLen
+	}
+	if p.SaltLen == 0 {
+		p.SaltLen = DefaultHashParams.SaltLen
+	}
+	if p.Memory < 8*uint32(p.Threads) {
+		return fmt.Errorf("argon2id memory must be at least 8 KiB per thread")
+	}
+	if p.KeyLen < 16 || p.SaltLen < 8 {
+		return fmt.Errorf("argon2id keys must be at least 16 bytes and salts at least 8")
+	}
+	hashParams = p
+	return nil
+}
+
+// hashPassword hashes the password with the current parameters and a random
+// salt, returning it in PHC string format, such as
+// $argon2id$v=19$m=65536,t=2,p=4$<salt>$<hash>
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, hashParams.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, hashParams.Time, hashParams.Memory, hashParams.Threads, hashParams.KeyLen)
+	return encodeHash(hashParams, salt, key), nil
+}
+
+// verifyPassword checks the password against a PHC string or, for hashes
+// from before they were stored that way, a bare base64 hash and its separate
+// salt. It also returns whether the hash should be replaced because it was
+// created with different parameters.
+func verifyPassword(password, encoded, legacySalt string) (bool, bool, error) {
+	var p HashParams
+	var salt, key []byte
+	var err error
+	if strings.HasPrefix(encoded, "$") {
+		p, salt, key, err = decodeHash(encoded)
+	} else {
+		p = legacyHashParams
+		salt, err = base64.StdEncoding.DecodeString(legacySalt)
+		if err == nil {
+			key, err = base64.StdEncoding.DecodeString(encoded)
+		}
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	provided := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(provided, key) != 1 {
+		return false, false, nil
+	}
+
+	// Legacy hashes have no SaltLen, so they never match
+	return true, p != hashParams, nil
+}
+
+// dummyVerify takes as long as checking a password with the current
+// parameters, so unknown users can't be told apart from wrong passwords
+func dummyVerify(password string) {
+	salt := make([]byte, hashParams.SaltLen)
+	argon2.IDKey([]byte(password), salt, hashParams.Time, hashParams.Memory, hashParams.Threads, hashParams.KeyLen)
+}
+
+func encodeHash(p HashParams, salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeHash(encoded string) (HashParams, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return HashParams{}, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return HashParams{}, nil, nil, ErrInvalidHash
+	}
+
+	var p HashParams
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return HashParams{}, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return HashParams{}, nil, nil, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return HashParams{}, nil, nil, ErrInvalidHash
+	}
+	p.SaltLen, p.KeyLen = uint32(len(salt)), uint32(len(key))
+	return p, salt, key, nil
+}
@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~amolith/willow/db"
+	"golang.org/x/crypto/argon2"
+)
+
+func TestPasswordHash(t *testing.T) {
+	hash, err := hashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=2,p=4$") {
+		t.Errorf("hashPassword() = %q, want a PHC string with the default parameters", hash)
+	}
+
+	tests := []struct {
+		password     string
+		wantOK       bool
+		wantOutdated bool
+	}{
+		{"hunter2", true, false},
+		{"hunter3", false, false},
+	}
+	for _, tt := range tests {
+		ok, outdated, err := verifyPassword(tt.password, hash, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.wantOK || outdated != tt.wantOutdated {
+			t.Errorf("verifyPassword(%q) = %v, %v, want %v, %v", tt.password, ok, outdated, tt.wantOK, tt.wantOutdated)
+		}
+	}
+
+	for _, malformed := range []string{"$argon2i$v=19$m=65536,t=2,p=4$c2FsdA$aGFzaA", "$argon2id$v=19$m=x$c2FsdA$aGFzaA", "$argon2id$"} {
+		if _, _, err := verifyPassword("hunter2", malformed, ""); err != ErrInvalidHash {
+			t.Errorf("verifyPassword() with %q error = %v, want %v", malformed, err, ErrInvalidHash)
+		}
+	}
+}
+
+func TestPasswordHashUpgrade(t *testing.T) {
+	defer func() { hashParams = DefaultHashParams }()
+	dbConn := testDB(t)
+
+	// Hashes from before PHC strings were stored as bare base64 with a
+	// separate salt
+	salt := make([]byte, 16)
+	legacy := base64.StdEncoding.EncodeToString(argon2.IDKey([]byte("hunter2"), salt, 2, 64*1024, 4, 64))
+	if err := db.SetUserPassword(dbConn, "alice", legacy, base64.StdEncoding.EncodeToString(salt)); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := UserAuthorised(dbConn, "alice", "hunter2"); err != nil || !ok {
+		t.Fatalf("UserAuthorised() with legacy hash = %v, %v", ok, err)
+	}
+	hash, _, err := db.GetUser(dbConn, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		t.Fatalf("legacy hash wasn't upgraded, got %q", hash)
+	}
+
+	if err := SetHashParams(HashParams{Time: 3, Memory: 32 * 1024, Threads: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := UserAuthorised(dbConn, "alice", "hunter2"); err != nil || !ok {
+		t.Fatalf("UserAuthorised() after changing parameters = %v, %v", ok, err)
+	}
+	hash, _, err = db.GetUser(dbConn, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=32768,t=3,p=2$") {
+		t.Errorf("hash wasn't upgraded to the new parameters, got %q", hash)
+	}
+
+	if err := SetHashParams(HashParams{Memory: 1}); err == nil {
+		t.Error("SetHashParams() accepted too little memory")
+	}
+}
@@ -0,0 +1,172 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// freeLoginAttempts is how many failures are allowed before delays start
+	freeLoginAttempts = 3
+	// baseLoginDelay is the delay after the first failure past the free ones,
+	// doubling with each further failure
+	baseLoginDelay = time.Second
+	// maxLoginDelay caps the exponential delay
+	maxLoginDelay = 5 * time.Minute
+	// usernameLockoutThreshold is how many failures lock a username out
+	usernameLockoutThreshold = 10
+	// ipLockoutThreshold is how many failures lock an IP out. It's higher
+	// than the username threshold because many people can share an IP.
+	ipLockoutThreshold = 50
+	// loginLockout is how long a lockout lasts
+	loginLockout = 15 * time.Minute
+	// forgetLoginFailures is how long after the last failure a username or IP
+	// starts with a clean slate
+	forgetLoginFailures = time.Hour
+)
+
+// LoginLimiter slows down and temporarily locks out repeated failed logins,
+// both per username and per IP, so passwords can't be guessed quickly. A nil
+// LoginLimiter allows everything.
+type LoginLimiter struct {
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+	now      func() time.Time
+}
+
+type loginFailures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// NewLoginLimiter returns an empty LoginLimiter.
+func NewLoginLimiter() *LoginLimiter {
+	return &LoginLimiter{
+		failures: make(map[string]*loginFailures),
+		now:      time.Now,
+	}
+}
+
+// Wait returns how long the IP or username has to wait before trying to log
+// in again, or zero if they can try now. An empty username only checks the IP.
+func (l *LoginLimiter) Wait(ip, username string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waitFor(ip, username, l.now())
+}
+
+// Attempt reserves a login attempt from the IP for the username, or returns
+// how long they have to wait if they can't try now. The attempt counts as a
+// failure until Success releases it, so a burst of concurrent guesses can't
+// all get past the limit before the first of them fails. An empty username
+// only counts against the IP.
+func (l *LoginLimiter) Attempt(ip, username string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if wait := l.waitFor(ip, username, now); wait > 0 {
+		return wait
+	}
+	l.record(ip, username, now)
+	return 0
+}
+
+// Failure records a failed login from the IP for the username. An empty
+// username only counts against the IP.
+func (l *LoginLimiter) Failure(ip, username string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.record(ip, username, l.now())
+}
+
+// Success forgets the username's failures after it logs in and releases the
+// attempt Attempt reserved for the IP. The IP's other failures are kept so an
+// attacker with one valid account can't use it to reset their limit.
+func (l *LoginLimiter) Success(ip, username string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, "user:"+username)
+	if f, ok := l.failures["ip:"+ip]; ok && f.count > 0 {
+		f.count--
+	}
+}
+
+func (l *LoginLimiter) record(ip, username string, now time.Time) {
+	l.fail("ip:"+ip, ipLockoutThreshold, now)
+	if username != "" {
+		l.fail("user:"+username, usernameLockoutThreshold, now)
+	}
+
+	// Drop stale entries so the map can't grow forever
+	for key, f := range l.failures {
+		if now.Sub(f.last) > forgetLoginFailures && now.After(f.lockedUntil) {
+			delete(l.failures, key)
+		}
+	}
+}
+
+func (l *LoginLimiter) waitFor(ip, username string, now time.Time) time.Duration {
+	wait := l.wait("ip:"+ip, now)
+	if username == "" {
+		return wait
+	}
+	if w := l.wait("user:"+username, now); w > wait {
+		wait = w
+	}
+	return wait
+}
+
+func (l *LoginLimiter) wait(key string, now time.Time) time.Duration {
+	f, ok := l.failures[key]
+	if !ok {
+		return 0
+	}
+	if now.Before(f.lockedUntil) {
+		return f.lockedUntil.Sub(now)
+	}
+	if f.count <= freeLoginAttempts {
+		return 0
+	}
+
+	delay := maxLoginDelay
+	if shift := f.count - freeLoginAttempts - 1; shift < 16 {
+		delay = min(baseLoginDelay<<shift, maxLoginDelay)
+	}
+	if next := f.last.Add(delay); now.Before(next) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+func (l *LoginLimiter) fail(key string, threshold int, now time.Time) {
+	f, ok := l.failures[key]
+	if !ok || (now.Sub(f.last) > forgetLoginFailures && now.After(f.lockedUntil)) {
+		f = &loginFailures{}
+		l.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	if f.count >= threshold {
+		f.lockedUntil = now.Add(loginLockout)
+		f.count = 0
+	}
+}
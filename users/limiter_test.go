@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoginLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLoginLimiter()
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < freeLoginAttempts; i++ {
+		l.Failure("192.0.2.1", "alice")
+	}
+	if wait := l.Wait("192.0.2.1", "alice"); wait != 0 {
+		t.Errorf("Wait() after free attempts = %v, want 0", wait)
+	}
+
+	l.Failure("192.0.2.1", "alice")
+	if wait := l.Wait("192.0.2.1", "alice"); wait != baseLoginDelay {
+		t.Errorf("Wait() after first delayed attempt = %v, want %v", wait, baseLoginDelay)
+	}
+	// The delay applies to the username from any IP and the IP for any username
+	if wait := l.Wait("198.51.100.1", "alice"); wait != baseLoginDelay {
+		t.Errorf("Wait() from another IP = %v, want %v", wait, baseLoginDelay)
+	}
+	if wait := l.Wait("192.0.2.1", "bob"); wait != baseLoginDelay {
+		t.Errorf("Wait() for another user = %v, want %v", wait, baseLoginDelay)
+	}
+
+	now = now.Add(baseLoginDelay)
+	l.Failure("192.0.2.1", "alice")
+	if wait := l.Wait("198.51.100.1", "alice"); wait != 2*baseLoginDelay {
+		t.Errorf("Wait() after second delayed attempt = %v, want %v", wait, 2*baseLoginDelay)
+	}
+
+	for i := freeLoginAttempts + 2; i < usernameLockoutThreshold; i++ {
+		l.Failure("198.51.100.1", "alice")
+	}
+	if wait := l.Wait("203.0.113.1", "alice"); wait != loginLockout {
+		t.Errorf("Wait() after lockout = %v, want %v", wait, loginLockout)
+	}
+
+	now = now.Add(loginLockout)
+	if wait := l.Wait("203.0.113.1", "alice"); wait != 0 {
+		t.Errorf("Wait() after lockout expired = %v, want 0", wait)
+	}
+
+	l.Success("192.0.2.1", "alice")
+	if wait := l.Wait("203.0.113.1", "alice"); wait != 0 {
+		t.Errorf("Wait() after success = %v, want 0", wait)
+	}
+
+	var nilLimiter *LoginLimiter
+	nilLimiter.Failure("192.0.2.1", "alice")
+	if wait := nilLimiter.Wait("192.0.2.1", "alice"); wait != 0 {
+		t.Errorf("nil Wait() = %v, want 0", wait)
+	}
+}
+
+func TestLoginLimiterConcurrentAttempts(t *testing.T) {
+	dbConn := testDB(t)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLoginLimiter()
+	l.now = func() time.Time { return now }
+
+	// Every guess checks the limiter before any of them finish hashing, so
+	// only attempts reserved up front can keep the rest out
+	var hashed atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if l.Attempt("192.0.2.1", "alice") > 0 {
+				return
+			}
+			hashed.Add(1)
+			if ok, err := UserAuthorised(dbConn, "alice", "wrong"); err != nil || ok {
+				t.Errorf("UserAuthorised() with the wrong password = %v, %v", ok, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if n := hashed.Load(); n > freeLoginAttempts+1 {
+		t.Errorf("%d concurrent guesses reached the password check, want at most %d", n, freeLoginAttempts+1)
+	}
+
+	// Logging in successfully releases the IP's reserved attempt
+	now = now.Add(loginLockout)
+	if wait := l.Attempt("198.51.100.1", "alice"); wait != 0 {
+		t.Fatalf("Attempt() from a fresh IP = %v, want 0", wait)
+	}
+	l.Success("198.51.100.1", "alice")
+	if f := l.failures["ip:198.51.100.1"]; f == nil || f.count != 0 {
+		t.Errorf("IP failures after a successful login = %+v, want none", f)
+	}
+}
@@ -0,0 +1,166 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"git.sr.ht/~amolith/willow/db"
+)
+
+const (
+	// MinPasswordLength is the shortest password that can be set
+	MinPasswordLength = 8
+	// passwordResetTimeout is how long a password reset link works for
+	passwordResetTimeout = time.Hour
+)
+
+var (
+	ErrWrongPassword     = errors.New("current password is incorrect")
+	ErrPasswordTooShort  = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	ErrInvalidEmail      = errors.New("invalid email address")
+	ErrInvalidResetToken = errors.New("password reset link is invalid or has expired")
+)
+
+// SetPassword replaces the user's password and cancels any password resets
+// they've requested.
+func SetPassword(dbConn *sql.DB, username, password string) error {
+	if len(password) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+	if _, err := db.GetUserRole(dbConn, username); err != nil {
+		return err
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if err := db.SetUserPassword(dbConn, username, hash, ""); err != nil {
+		return err
+	}
+	return db.DeleteUserPasswordResets(dbConn, username)
+}
+
+// ChangePassword replaces the user's password if current is correct.
+func ChangePassword(dbConn *sql.DB, username, current, password string) error {
+	authorised, err := UserAuthorised(dbConn, username, current)
+	if err != nil {
+		return err
+	}
+	if !authorised {
+		return ErrWrongPassword
+	}
+	return SetPassword(dbConn, username, password)
+}
+
+// GetEmail returns the user's email address, which is empty if they haven't
+// set one.
+func GetEmail(dbConn *sql.DB, username string) (string, error) {
+	return db.GetUserEmail(dbConn, username)
+}
+
+// SetEmail changes the address the user's password reset links are sent to.
+// An empty address removes it.
+func SetEmail(dbConn *sql.DB, username, email string) error {
+	email = strings.TrimSpace(email)
+	if email != "" {
+		addr, err := mail.ParseAddress(email)
+		if err != nil || addr.Address != email {
+			return ErrInvalidEmail
+		}
+	}
+	return db.SetUserEmail(dbConn, username, email)
+}
+
+// StartPasswordReset creates a password reset token for the user with the
+// username or email address. It returns the user's username, email address,
+// and the token, or empty strings if there's no such user or they don't have
+// an email address, so callers can't reveal which accounts exist.
+func StartPasswordReset(dbConn *sql.DB, usernameOrEmail string) (string, string, string, error) {
+	usernameOrEmail = strings.TrimSpace(usernameOrEmail)
+	username := usernameOrEmail
+	email, err := db.GetUserEmail(dbConn, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		username, err = db.GetUsernameByEmail(dbConn, usernameOrEmail)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", "", nil
+		}
+		if err != nil {
+			return "", "", "", err
+		}
+		email, err = db.GetUserEmail(dbConn, username)
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+	if email == "" {
+		return "", "", "", nil
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	err = db.CreatePasswordReset(dbConn, hashResetToken(token), username, time.Now().Add(passwordResetTimeout))
+	if err != nil {
+		return "", "", "", err
+	}
+	return username, email, token, nil
+}
+
+// PasswordResetUser returns the username a password reset token belongs to,
+// or ErrInvalidResetToken if it's unknown or expired.
+func PasswordResetUser(dbConn *sql.DB, token string) (string, error) {
+	username, expiry, err := db.GetPasswordReset(dbConn, hashResetToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrInvalidResetToken
+	}
+	if err != nil {
+		return "", err
+	}
+	if expiry.Before(time.Now()) {
+		return "", ErrInvalidResetToken
+	}
+	return username, nil
+}
+
+// FinishPasswordReset sets a new password for the user the token belongs to
+// and logs them out everywhere. The token can't be used again.
+func FinishPasswordReset(dbConn *sql.DB, token, password string) (string, error) {
+	username, err := PasswordResetUser(dbConn, token)
+	if err != nil {
+		return "", err
+	}
+	if err := SetPassword(dbConn, username, password); err != nil {
+		return "", err
+	}
+	return username, RevokeAllSessions(dbConn, username)
+}
+
+// hashResetToken returns the hash of a password reset token. The tokens are
+// long and random, so a fast hash is enough.
+func hashResetToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("%x", hash)
+}
+
+// HasPassword returns true if the user can log in with a password, rather than
+// only through an identity provider.
+func HasPassword(dbConn *sql.DB, username string) (bool, error) {
+	hash, _, err := db.GetUser(dbConn, username)
+	return hash != "", err
+}
@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChangePassword(t *testing.T) {
+	dbConn := testDB(t)
+
+	if err := ChangePassword(dbConn, "alice", "wrong", "correct horse"); err != ErrWrongPassword {
+		t.Errorf("ChangePassword() with wrong password error = %v, want %v", err, ErrWrongPassword)
+	}
+	if err := ChangePassword(dbConn, "alice", "hunter2", "short"); err != ErrPasswordTooShort {
+		t.Errorf("ChangePassword() with short password error = %v, want %v", err, ErrPasswordTooShort)
+	}
+	if err := ChangePassword(dbConn, "alice", "hunter2", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := UserAuthorised(dbConn, "alice", "hunter2"); ok {
+		t.Error("old password still works")
+	}
+	if ok, _ := UserAuthorised(dbConn, "alice", "correct horse"); !ok {
+		t.Error("new password doesn't work")
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	dbConn := testDB(t)
+
+	// Users without an email address can't reset their password
+	_, _, token, err := StartPasswordReset(dbConn, "alice")
+	if err != nil || token != "" {
+		t.Fatalf("StartPasswordReset() without email = %q, %v, want no token", token, err)
+	}
+
+	if err := SetEmail(dbConn, "alice", "not an address"); err != ErrInvalidEmail {
+		t.Errorf("SetEmail() error = %v, want %v", err, ErrInvalidEmail)
+	}
+	if err := SetEmail(dbConn, "alice", "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, who := range []string{"alice", "Alice@Example.com"} {
+		username, email, token, err := StartPasswordReset(dbConn, who)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if username != "alice" || email != "alice@example.com" || token == "" {
+			t.Errorf("StartPasswordReset(%q) = %q, %q, %q", who, username, email, token)
+		}
+	}
+	if _, _, token, _ := StartPasswordReset(dbConn, "nobody"); token != "" {
+		t.Error("StartPasswordReset() returned a token for a user that doesn't exist")
+	}
+
+	_, _, token, err = StartPasswordReset(dbConn, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _, err := CreateSession(dbConn, "alice", "", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FinishPasswordReset(dbConn, token, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := UserAuthorised(dbConn, "alice", "correct horse"); !ok {
+		t.Error("new password doesn't work")
+	}
+	if _, ok, _ := SessionUser(dbConn, session); ok {
+		t.Error("session is still valid after resetting the password")
+	}
+	if _, err := FinishPasswordReset(dbConn, token, "battery staple"); err != ErrInvalidResetToken {
+		t.Errorf("reusing reset token error = %v, want %v", err, ErrInvalidResetToken)
+	}
+}
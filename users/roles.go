@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"git.sr.ht/~amolith/willow/db"
+)
+
+// Roles a user can have. Viewers can only read, editors can also manage their
+// projects, and admins can also manage users.
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// ErrLastAdmin is returned when a change would leave Willow without any admins.
+var ErrLastAdmin = errors.New("there must be at least one admin")
+
+// User is a user and their role.
+type User struct {
+	Username string
+	Role     string
+}
+
+// ValidRole returns true if the role is one Willow knows about.
+func ValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleEditor, RoleViewer:
+		return true
+	}
+	return false
+}
+
+// CanEdit returns true if the role is allowed to manage projects.
+func CanEdit(role string) bool { return role == RoleAdmin || role == RoleEditor }
+
+// IsAdmin returns true if the role is allowed to manage users.
+func IsAdmin(role string) bool { return role == RoleAdmin }
+
+// GetRole returns the user's role.
+func GetRole(dbConn *sql.DB, username string) (string, error) {
+	return db.GetUserRole(dbConn, username)
+}
+
+// SetRole changes the user's role, refusing to demote the last admin.
+func SetRole(dbConn *sql.DB, username, role string) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("invalid role %q, must be %s, %s, or %s", role, RoleAdmin, RoleEditor, RoleViewer)
+	}
+
+	current, err := db.GetUserRole(dbConn, username)
+	if err != nil {
+		return err
+	}
+	if current == RoleAdmin && role != RoleAdmin {
+		if err := ensureOtherAdmin(dbConn); err != nil {
+			return err
+		}
+	}
+
+	return db.SetUserRole(dbConn, username, role)
+}
+
+// ListUsers returns all users and their roles.
+func ListUsers(dbConn *sql.DB) ([]User, error) {
+	rows, err := db.GetUsersWithRoles(dbConn)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]User, len(rows))
+	for i, row := range rows {
+		list[i] = User{Username: row["username"], Role: row["role"]}
+	}
+	return list, nil
+}
+
+// ensureOtherAdmin returns ErrLastAdmin if there's only one admin left.
+func ensureOtherAdmin(dbConn *sql.DB) error {
+	admins, err := db.CountUsersWithRole(dbConn, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if admins <= 1 {
+		return ErrLastAdmin
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"git.sr.ht/~amolith/willow/db"
+)
+
+const (
+	// DefaultSessionLifetime is how long a session lasts without being used
+	// when no lifetime is configured
+	DefaultSessionLifetime = 7 * 24 * time.Hour
+	// sessionTouchInterval is how often a session's last use is recorded
+	sessionTouchInterval = time.Minute
+)
+
+var ErrUnknownSession = errors.New("no such session")
+
+// Session is one of a user's logged in devices. The ID identifies it without
+// revealing its token.
+type Session struct {
+	ID        string
+	IP        string
+	UserAgent string
+	CreatedAt string
+	LastSeen  string
+	Expires   string
+	// Current is true for the session the list was requested with
+	Current bool
+}
+
+// ListSessions returns the user's unexpired sessions, most recently used
+// first, marking the one with the token current.
+func ListSessions(dbConn *sql.DB, username, current string) ([]Session, error) {
+	rows, err := db.GetUserSessions(dbConn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var sessions []Session
+	for _, row := range rows {
+		expires, err := time.Parse(time.RFC3339, row["expires"])
+		if err != nil || expires.Before(now) {
+			continue
+		}
+		sessions = append(sessions, Session{
+			ID:        sessionID(row["token"]),
+			IP:        row["ip"],
+			UserAgent: row["user_agent"],
+			CreatedAt: row["created_at"],
+			LastSeen:  row["last_seen"],
+			Expires:   row["expires"],
+			Current:   row["token"] == current,
+		})
+	}
+	return sessions, nil
+}
+
+// TouchSession records that the session was used from the IP and user agent
+// and slides its expiry to lifetime from now.
+func TouchSession(dbConn *sql.DB, session, ip, userAgent string, lifetime time.Duration) error {
+	if lifetime <= 0 {
+		lifetime = DefaultSessionLifetime
+	}
+	now := time.Now()
+	return db.TouchSession(dbConn, session, ip, userAgent, now.Add(-sessionTouchInterval), now.Add(lifetime))
+}
+
+// RevokeSession logs out one of the user's sessions by its ID.
+func RevokeSession(dbConn *sql.DB, username, id string) error {
+	rows, err := db.GetUserSessions(dbConn, username)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if sessionID(row["token"]) == id {
+			return db.DeleteSession(dbConn, row["token"])
+		}
+	}
+	return ErrUnknownSession
+}
+
+// RevokeAllSessions logs the user out everywhere.
+func RevokeAllSessions(dbConn *sql.DB, username string) error {
+	return db.DeleteUserSessions(dbConn, username)
+}
+
+// PurgeExpiredSessions deletes sessions that have expired and returns how many
+// there were.
+func PurgeExpiredSessions(dbConn *sql.DB) (int, error) {
+	return db.DeleteExpiredSessions(dbConn, time.Now())
+}
+
+// PurgeSessionsLoop purges expired sessions every interval, forever.
+func PurgeSessionsLoop(dbConn *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for {
+		purged, err := PurgeExpiredSessions(dbConn)
+		if err != nil {
+			fmt.Println("Error purging expired sessions:", err)
+		} else if purged > 0 {
+			fmt.Println("Purged", purged, "expired sessions")
+		}
+		<-ticker.C
+	}
+}
+
+// sessionID derives a session's public ID from its token
+func sessionID(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("%x", hash[:8])
+}
@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	dbConn := testDB(t)
+	if err := Register(dbConn, "bob", "hunter2", RoleEditor); err != nil {
+		t.Fatal(err)
+	}
+
+	laptop, _, err := CreateSession(dbConn, "alice", "192.0.2.1", "Laptop", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	phone, _, err := CreateSession(dbConn, "alice", "192.0.2.2", "Phone", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _, err := CreateSession(dbConn, "alice", "192.0.2.3", "Old", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbConn.Exec("UPDATE sessions SET expires = ? WHERE token = ?", time.Now().Add(-time.Hour).Format(time.RFC3339), expired); err != nil {
+		t.Fatal(err)
+	}
+	bobs, _, err := CreateSession(dbConn, "bob", "192.0.2.4", "Desktop", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := ListSessions(dbConn, "alice", laptop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("ListSessions() returned %d sessions, want 2", len(sessions))
+	}
+	var phoneID string
+	for _, s := range sessions {
+		if s.Current != (s.UserAgent == "Laptop") {
+			t.Errorf("session %q Current = %v", s.UserAgent, s.Current)
+		}
+		if s.UserAgent == "Phone" {
+			phoneID = s.ID
+		}
+	}
+
+	if err := RevokeSession(dbConn, "bob", phoneID); err != ErrUnknownSession {
+		t.Errorf("revoking another user's session error = %v, want %v", err, ErrUnknownSession)
+	}
+	if err := RevokeSession(dbConn, "alice", phoneID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := SessionUser(dbConn, phone); ok {
+		t.Error("revoked session is still valid")
+	}
+
+	purged, err := PurgeExpiredSessions(dbConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("PurgeExpiredSessions() = %d, want 1", purged)
+	}
+
+	if err := RevokeAllSessions(dbConn, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := SessionUser(dbConn, laptop); ok {
+		t.Error("session is still valid after logging out everywhere")
+	}
+
+	if err := Delete(dbConn, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := SessionUser(dbConn, bobs); ok {
+		t.Error("deleted user's session is still valid")
+	}
+}
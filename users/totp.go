@@ -0,0 +1,279 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image/png"
+	"strings"
+	"time"
+
+	"git.sr.ht/~amolith/willow/db"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	// totpPeriod is how many seconds each code is valid for
+	totpPeriod = 30
+	// recoveryCodeCount is how many recovery codes a user gets at a time
+	recoveryCodeCount = 10
+	// pendingLoginTimeout is how long a user has to enter their code after
+	// their password
+	pendingLoginTimeout = 5 * time.Minute
+	// maxTOTPAttempts is how many wrong codes can be entered before the user
+	// has to start logging in again
+	maxTOTPAttempts = 5
+)
+
+var (
+	ErrTOTPEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotStarted = errors.New("two-factor authentication enrolment hasn't been started")
+	ErrInvalidCode    = errors.New("invalid code")
+)
+
+// TOTPEnrolment is what the user needs to add Willow to their authenticator
+type TOTPEnrolment struct {
+	Secret string
+	URI    string
+	// QRCode is a data: URI of a PNG QR code encoding URI
+	QRCode string
+}
+
+// TOTPEnabled returns true if the user has confirmed a TOTP secret.
+func TOTPEnabled(dbConn *sql.DB, username string) (bool, error) {
+	_, confirmed, _, err := db.GetTOTP(dbConn, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return confirmed, err
+}
+
+// BeginTOTPEnrolment returns the user's pending TOTP secret, generating and
+// storing a new one if they don't have one yet or restart is true, so
+// reloading the page doesn't invalidate a QR code that was already scanned.
+// The secret isn't used for logging in until ConfirmTOTP is called with a
+// valid code.
+func BeginTOTPEnrolment(dbConn *sql.DB, username string, restart bool) (TOTPEnrolment, error) {
+	secret, confirmed, _, err := db.GetTOTP(dbConn, username)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return TOTPEnrolment{}, err
+	}
+	if confirmed {
+		return TOTPEnrolment{}, ErrTOTPEnabled
+	}
+
+	opts := totp.GenerateOpts{
+		Issuer:      "Willow",
+		AccountName: username,
+		Period:      totpPeriod,
+	}
+	if secret != "" && !restart {
+		opts.Secret, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+		if err != nil {
+			return TOTPEnrolment{}, err
+		}
+	}
+	key, err := totp.Generate(opts)
+	if err != nil {
+		return TOTPEnrolment{}, err
+	}
+
+	if key.Secret() != secret {
+		if err := db.UpsertTOTPSecret(dbConn, username, key.Secret()); err != nil {
+			return TOTPEnrolment{}, err
+		}
+	}
+
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return TOTPEnrolment{}, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return TOTPEnrolment{}, err
+	}
+
+	return TOTPEnrolment{
+		Secret: key.Secret(),
+		URI:    key.URL(),
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication if the code is valid for the
+// secret from BeginTOTPEnrolment and returns the user's recovery codes.
+func ConfirmTOTP(dbConn *sql.DB, username, code string) ([]string, error) {
+	secret, confirmed, _, err := db.GetTOTP(dbConn, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTOTPNotStarted
+	}
+	if err != nil {
+		return nil, err
+	}
+	if confirmed {
+		return nil, ErrTOTPEnabled
+	}
+
+	ok, err := checkTOTPCode(dbConn, username, secret, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	if err := db.ConfirmTOTP(dbConn, username); err != nil {
+		return nil, err
+	}
+	return RegenerateRecoveryCodes(dbConn, username)
+}
+
+// DisableTOTP removes the user's TOTP secret and recovery codes.
+func DisableTOTP(dbConn *sql.DB, username string) error {
+	return db.DeleteTOTP(dbConn, username)
+}
+
+// ValidateTOTP returns true if the code is currently valid for the user's
+// authenticator or is one of their unused recovery codes. Each code can only
+// be used once.
+func ValidateTOTP(dbConn *sql.DB, username, code string) (bool, error) {
+	secret, confirmed, _, err := db.GetTOTP(dbConn, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil || !confirmed {
+		return false, err
+	}
+
+	ok, err := checkTOTPCode(dbConn, username, secret, code)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	return db.UseRecoveryCode(dbConn, username, hashRecoveryCode(code))
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes and returns the
+// new ones. Only their hashes are stored, so they can't be shown again.
+func RegenerateRecoveryCodes(dbConn *sql.DB, username string) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = code[:8] + "-" + code[8:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	if err := db.ReplaceRecoveryCodes(dbConn, username, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes the user has.
+func RecoveryCodesLeft(dbConn *sql.DB, username string) (int, error) {
+	return db.CountRecoveryCodes(dbConn, username)
+}
+
+// StartPendingLogin records that the user has entered the correct password and
+// returns a token identifying the login until they enter their second factor.
+func StartPendingLogin(dbConn *sql.DB, username string) (string, time.Time, error) {
+	token, err := generateSalt()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiry := time.Now().Add(pendingLoginTimeout)
+	if err := db.CreatePendingLogin(dbConn, token, username, expiry); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiry, nil
+}
+
+// FinishPendingLogin checks the second factor for a pending login, returning
+// the username it belongs to and whether the code is valid. The pending login
+// is discarded on success or after too many wrong codes.
+func FinishPendingLogin(dbConn *sql.DB, token, code string) (string, bool, error) {
+	username, expiry, attempts, err := db.GetPendingLogin(dbConn, token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if expiry.Before(time.Now()) || attempts >= maxTOTPAttempts {
+		return "", false, db.DeletePendingLogin(dbConn, token)
+	}
+
+	ok, err := ValidateTOTP(dbConn, username, code)
+	if err != nil {
+		return "", false, err
+	}
+	if !ok {
+		return username, false, db.IncrementPendingLoginAttempts(dbConn, token)
+	}
+
+	return username, true, db.DeletePendingLogin(dbConn, token)
+}
+
+// PendingLoginValid returns true if the token belongs to a pending login that
+// can still be finished.
+func PendingLoginValid(dbConn *sql.DB, token string) (bool, error) {
+	_, expiry, attempts, err := db.GetPendingLogin(dbConn, token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return expiry.After(time.Now()) && attempts < maxTOTPAttempts, nil
+}
+
+// checkTOTPCode returns true if the code matches the secret for the current,
+// previous, or next time step and wasn't already used.
+func checkTOTPCode(dbConn *sql.DB, username, secret, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != 6 {
+		return false, nil
+	}
+
+	now := time.Now()
+	for _, skew := range []int{-1, 0, 1} {
+		t := now.Add(time.Duration(skew*totpPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(secret, t, totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed generating TOTP code: %w", err)
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return db.SetTOTPCounter(dbConn, username, t.Unix()/totpPeriod)
+		}
+	}
+	return false, nil
+}
+
+// hashRecoveryCode normalises a recovery code and returns its hash. Recovery
+// codes are long and random, so a fast hash is enough.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(code))
+	return fmt.Sprintf("%x", hash)
+}
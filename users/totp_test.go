@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.sr.ht/~amolith/willow/db"
+	"github.com/pquerna/otp/totp"
+)
+
+func testDB(t *testing.T) *sql.DB {
+	dbConn, err := db.Open(filepath.Join(t.TempDir(), "willow.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+	if err := db.InitialiseDatabase(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(dbConn); err != nil {
+		t.Fatal(err)
+	}
+	if err := Register(dbConn, "alice", "hunter2", RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	return dbConn
+}
+
+func TestTOTP(t *testing.T) {
+	dbConn := testDB(t)
+
+	enrolment, err := BeginTOTPEnrolment(dbConn, "alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enabled, _ := TOTPEnabled(dbConn, "alice"); enabled {
+		t.Fatal("TOTP enabled before it was confirmed")
+	}
+
+	// Reloading the page shows the same secret until the user asks for a new
+	// one
+	if again, err := BeginTOTPEnrolment(dbConn, "alice", false); err != nil || again != enrolment {
+		t.Errorf("BeginTOTPEnrolment() again = %+v, %v; want the pending secret %+v", again, err, enrolment)
+	}
+	restarted, err := BeginTOTPEnrolment(dbConn, "alice", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restarted.Secret == enrolment.Secret {
+		t.Error("BeginTOTPEnrolment() restarting kept the old secret")
+	}
+	enrolment = restarted
+
+	if _, err := ConfirmTOTP(dbConn, "alice", "000000"); err != ErrInvalidCode {
+		t.Fatalf("ConfirmTOTP() with wrong code error = %v, want %v", err, ErrInvalidCode)
+	}
+
+	// Confirm with the previous step's code so the current one is still
+	// unused
+	previous, err := totp.GenerateCode(enrolment.Secret, time.Now().Add(-totpPeriod*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := ConfirmTOTP(dbConn, "alice", previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+
+	current, err := totp.GenerateCode(enrolment.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		code string
+		want bool
+	}{
+		{"current code", current, true},
+		{"replayed code", current, false},
+		{"earlier code", previous, false},
+		{"recovery code", codes[0], true},
+		{"reused recovery code", codes[0], false},
+		{"recovery code without dash", codes[1][:8] + codes[1][9:], true},
+		{"wrong code", "not-a-code", false},
+	}
+	for _, tt := range tests {
+		got, err := ValidateTOTP(dbConn, "alice", tt.code)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: ValidateTOTP() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if left, _ := RecoveryCodesLeft(dbConn, "alice"); left != recoveryCodeCount-2 {
+		t.Errorf("RecoveryCodesLeft() = %d, want %d", left, recoveryCodeCount-2)
+	}
+}
+
+func TestPendingLoginAttempts(t *testing.T) {
+	dbConn := testDB(t)
+
+	enrolment, err := BeginTOTPEnrolment(dbConn, "alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.GenerateCode(enrolment.Secret, time.Now().Add(-totpPeriod*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := ConfirmTOTP(dbConn, "alice", code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, _, err := StartPendingLogin(dbConn, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxTOTPAttempts; i++ {
+		if _, ok, err := FinishPendingLogin(dbConn, token, "000000"); ok || err != nil {
+			t.Fatalf("FinishPendingLogin() with wrong code = %v, %v", ok, err)
+		}
+	}
+	if _, ok, _ := FinishPendingLogin(dbConn, token, codes[0]); ok {
+		t.Error("FinishPendingLogin() succeeded after too many attempts")
+	}
+
+	token, _, err = StartPendingLogin(dbConn, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	username, ok, err := FinishPendingLogin(dbConn, token, codes[0])
+	if err != nil || !ok || username != "alice" {
+		t.Errorf("FinishPendingLogin() = %q, %v, %v, want alice, true, nil", username, ok, err)
+	}
+	if valid, _ := PendingLoginValid(dbConn, token); valid {
+		t.Error("pending login still valid after it was finished")
+	}
+}
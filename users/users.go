@@ -8,23 +8,13 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"time"
 
 	"git.sr.ht/~amolith/willow/db"
-	"golang.org/x/crypto/argon2"
 )
 
-// argonHash accepts two strings for the user's password and a random salt,
-// hashes the password using the salt, and returns the hash as a base64-encoded
-// string.
-func argonHash(password, salt string) (string, error) {
-	decodedSalt, err := base64.StdEncoding.DecodeString(salt)
-	if err != nil {
-		return "", err
-	}
-	return base64.StdEncoding.EncodeToString(argon2.IDKey([]byte(password), decodedSalt, 2, 64*1024, 4, 64)), nil
-}
-
 // generateSalt generates a random salt and returns it as a base64-encoded
 // string.
 func generateSalt() (string, error) {
@@ -36,68 +26,115 @@ func generateSalt() (string, error) {
 	return base64.StdEncoding.EncodeToString(salt), nil
 }
 
-// Register accepts a username and password, hashes the password and stores the
-// hash and salt in the database.
-func Register(dbConn *sql.DB, username, password string) error {
-	salt, err := generateSalt()
-	if err != nil {
-		return err
+// Register accepts a username, password, and role, hashes the password and
+// stores the hash and salt in the database.
+func Register(dbConn *sql.DB, username, password, role string) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("invalid role %q, must be %s, %s, or %s", role, RoleAdmin, RoleEditor, RoleViewer)
 	}
 
-	hash, err := argonHash(password, salt)
+	hash, err := hashPassword(password)
 	if err != nil {
 		return err
 	}
 
-	return db.CreateUser(dbConn, username, hash, salt)
+	return db.CreateUser(dbConn, username, hash, "", role)
 }
 
-// Delete removes a user from the database.
-func Delete(dbConn *sql.DB, username string) error { return db.DeleteUser(dbConn, username) }
+// Delete removes a user from the database, refusing to delete the last admin.
+func Delete(dbConn *sql.DB, username string) error {
+	role, err := db.GetUserRole(dbConn, username)
+	if err != nil {
+		return err
+	}
+	if role == RoleAdmin {
+		if err := ensureOtherAdmin(dbConn); err != nil {
+			return err
+		}
+	}
+	return db.DeleteUser(dbConn, username)
+}
 
 // UserAuthorised accepts a username string, a token string, and returns true if the
 // user is authorised, false if not, and an error if one is encountered.
+//
+// Unknown users and users without a password get the same answer as a wrong
+// password after the same amount of work, so responses don't reveal which
+// usernames exist.
 func UserAuthorised(dbConn *sql.DB, username, token string) (bool, error) {
 	dbHash, dbSalt, err := db.GetUser(dbConn, username)
-	if err != nil {
+	if errors.Is(err, sql.ErrNoRows) {
+		dbHash = ""
+	} else if err != nil {
 		return false, err
 	}
 
-	providedHash, err := argonHash(token, dbSalt)
-	if err != nil {
+	// Users from an identity provider don't have a password
+	if dbHash == "" {
+		dummyVerify(token)
+		return false, nil
+	}
+
+	authorised, outdated, err := verifyPassword(token, dbHash, dbSalt)
+	if err != nil || !authorised {
 		return false, err
 	}
 
-	return dbHash == providedHash, nil
+	if outdated {
+		hash, err := hashPassword(token)
+		if err == nil {
+			err = db.SetUserPassword(dbConn, username, hash, "")
+		}
+		if err != nil {
+			fmt.Println("Error upgrading password hash for", username+":", err)
+		}
+	}
+	return true, nil
 }
 
 // SessionAuthorised accepts a session string and returns true if the session is
 // valid and false if not.
 func SessionAuthorised(dbConn *sql.DB, session string) (bool, error) {
+	_, authorised, err := SessionUser(dbConn, session)
+	return authorised, err
+}
+
+// SessionUser accepts a session string and returns the username it belongs to
+// and true if the session is valid, or false if not.
+func SessionUser(dbConn *sql.DB, session string) (string, bool, error) {
 	dbResult, expiry, err := db.GetSession(dbConn, session)
 	if dbResult == "" || expiry.Before(time.Now()) || err != nil {
-		return false, err
+		return "", false, err
 	}
 
-	return true, nil
+	return dbResult, true, nil
 }
 
-// InvalidateSession invalidates a session by setting the expiration date to now.
+// InvalidateSession logs a session out by deleting it.
 func InvalidateSession(dbConn *sql.DB, session string) error {
-	return db.InvalidateSession(dbConn, session, time.Now())
+	return db.DeleteSession(dbConn, session)
 }
 
-// CreateSession accepts a username, generates a token, stores it in the
-// database, and returns it
-func CreateSession(dbConn *sql.DB, username string) (string, time.Time, error) {
+// CreateSession accepts a username and the IP and user agent it's logging in
+// from, generates a token that expires after lifetime without use, stores it in
+// the database, and returns it
+func CreateSession(dbConn *sql.DB, username, ip, userAgent string, lifetime time.Duration) (string, time.Time, error) {
 	token, err := generateSalt()
 	if err != nil {
 		return "", time.Time{}, err
 	}
 
-	expiry := time.Now().Add(7 * 24 * time.Hour)
+	csrf, err := generateSalt()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if lifetime <= 0 {
+		lifetime = DefaultSessionLifetime
+	}
+	expiry := time.Now().Add(lifetime)
 
-	err = db.CreateSession(dbConn, username, token, expiry)
+	err = db.CreateSession(dbConn, username, token, csrf, ip, userAgent, expiry)
 	if err != nil {
 		return "", time.Time{}, err
 	}
@@ -105,5 +142,17 @@ func CreateSession(dbConn *sql.DB, username string) (string, time.Time, error) {
 	return token, expiry, nil
 }
 
+// SessionCSRFToken returns the token that state-changing requests made with
+// the session must include.
+func SessionCSRFToken(dbConn *sql.DB, session string) (string, error) {
+	return db.GetSessionCSRF(dbConn, session)
+}
+
+// RecordFailedLogin records a failed login attempt so admins can spot attacks.
+func RecordFailedLogin(dbConn *sql.DB, username, ip, reason string) error {
+	fmt.Printf("Failed login for %q from %s: %s\n", username, ip, reason)
+	return db.CreateFailedLogin(dbConn, username, ip, reason)
+}
+
 // GetUsers returns a list of all users in the database as a slice of strings.
 func GetUsers(dbConn *sql.DB) ([]string, error) { return db.GetUsers(dbConn) }
@@ -0,0 +1,327 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"git.sr.ht/~amolith/willow/db"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// passkeyTimeout is how long a user has to respond to their browser's prompt
+// to use their authenticator
+const passkeyTimeout = 5 * time.Minute
+
+var (
+	ErrNoPasskeyChallenge = errors.New("unknown or expired passkey challenge")
+	ErrPasskeyLogin       = errors.New("passkey not recognised")
+)
+
+// decoyKey keys the decoy credentials of users without passkeys, so they stay
+// the same for as long as Willow runs
+var decoyKey = func() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}()
+
+// Passkey is a WebAuthn credential as shown to its owner
+type Passkey struct {
+	ID        string
+	Name      string
+	CreatedAt string
+	LastUsed  string
+}
+
+// webauthnUser implements webauthn.User for a Willow user
+type webauthnUser struct {
+	username    string
+	id          []byte
+	credentials []webauthn.Credential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte                         { return u.id }
+func (u *webauthnUser) WebAuthnName() string                       { return u.username }
+func (u *webauthnUser) WebAuthnDisplayName() string                { return u.username }
+func (u *webauthnUser) WebAuthnIcon() string                       { return "" }
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+// NewWebAuthn returns the WebAuthn relying party for a Willow instance served
+// from origin, which must be on rpID or one of its subdomains.
+func NewWebAuthn(rpID, origin string) (*webauthn.WebAuthn, error) {
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: passkeyTimeout, TimeoutUVD: passkeyTimeout}
+	return webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: "Willow",
+		RPOrigins:     []string{origin},
+		Timeouts:      webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+}
+
+// BeginPasskeyRegistration starts registering a new authenticator for the user
+// and returns the options to pass to navigator.credentials.create() and a
+// token identifying the registration.
+func BeginPasskeyRegistration(dbConn *sql.DB, wa *webauthn.WebAuthn, username string) (*protocol.CredentialCreation, string, time.Time, error) {
+	user, err := loadWebAuthnUser(dbConn, username, true)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, len(user.credentials))
+	for i, c := range user.credentials {
+		exclusions[i] = c.Descriptor()
+	}
+
+	creation, session, err := wa.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+
+	token, expiry, err := saveChallenge(dbConn, username, session)
+	return creation, token, expiry, err
+}
+
+// FinishPasskeyRegistration verifies the authenticator's response to
+// navigator.credentials.create() and stores the new credential under the
+// given name.
+func FinishPasskeyRegistration(dbConn *sql.DB, wa *webauthn.WebAuthn, token, username, name string, body io.Reader) error {
+	session, challengeUser, err := takeChallenge(dbConn, token)
+	if err != nil {
+		return err
+	}
+	if challengeUser != username {
+		return ErrNoPasskeyChallenge
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(body)
+	if err != nil {
+		return err
+	}
+
+	user, err := loadWebAuthnUser(dbConn, username, false)
+	if err != nil {
+		return err
+	}
+
+	credential, err := wa.CreateCredential(user, session, parsed)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = "Passkey"
+	}
+	return db.CreateWebAuthnCredential(dbConn, base64.RawURLEncoding.EncodeToString(credential.ID), username, name, string(encoded))
+}
+
+// BeginPasskeyLogin starts a login with an authenticator and returns the
+// options to pass to navigator.credentials.get() and a token identifying the
+// login. If username is empty, any discoverable credential (passkey) can be
+// used; otherwise only that user's credentials are allowed. Users that don't
+// exist or have no passkeys get a decoy credential that can't be used, so the
+// response doesn't reveal which usernames have passkeys.
+func BeginPasskeyLogin(dbConn *sql.DB, wa *webauthn.WebAuthn, username string) (*protocol.CredentialAssertion, string, time.Time, error) {
+	var (
+		assertion *protocol.CredentialAssertion
+		session   *webauthn.SessionData
+		err       error
+	)
+
+	if username == "" {
+		assertion, session, err = wa.BeginDiscoverableLogin()
+	} else {
+		var user *webauthnUser
+		user, err = loadWebAuthnUser(dbConn, username, false)
+		if errors.Is(err, ErrPasskeyLogin) || errors.Is(err, sql.ErrNoRows) || (err == nil && len(user.credentials) == 0) {
+			user, err = decoyUser(username), nil
+		}
+		if err == nil {
+			assertion, session, err = wa.BeginLogin(user)
+		}
+	}
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+
+	token, expiry, err := saveChallenge(dbConn, username, session)
+	return assertion, token, expiry, err
+}
+
+// FinishPasskeyLogin verifies the authenticator's response to
+// navigator.credentials.get() and returns the username it belongs to.
+func FinishPasskeyLogin(dbConn *sql.DB, wa *webauthn.WebAuthn, token string, body io.Reader) (string, error) {
+	session, username, err := takeChallenge(dbConn, token)
+	if err != nil {
+		return "", err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(body)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		user       *webauthnUser
+		credential *webauthn.Credential
+	)
+	if username == "" {
+		credential, err = wa.ValidateDiscoverableLogin(func(_, userHandle []byte) (webauthn.User, error) {
+			handleUser, err := db.GetWebAuthnIDUser(dbConn, base64.RawURLEncoding.EncodeToString(userHandle))
+			if err != nil {
+				return nil, err
+			}
+			user, err = loadWebAuthnUser(dbConn, handleUser, false)
+			return user, err
+		}, session, parsed)
+	} else {
+		user, err = loadWebAuthnUser(dbConn, username, false)
+		if err == nil {
+			credential, err = wa.ValidateLogin(user, session, parsed)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrPasskeyLogin, err)
+	}
+
+	// A signature counter that went backwards means the credential may have
+	// been cloned
+	if credential.Authenticator.CloneWarning {
+		return "", fmt.Errorf("%w: signature counter went backwards", ErrPasskeyLogin)
+	}
+
+	encoded, err := json.Marshal(credential)
+	if err != nil {
+		return "", err
+	}
+	err = db.UpdateWebAuthnCredential(dbConn, base64.RawURLEncoding.EncodeToString(credential.ID), string(encoded))
+	return user.username, err
+}
+
+// ListPasskeys returns the user's registered authenticators.
+func ListPasskeys(dbConn *sql.DB, username string) ([]Passkey, error) {
+	rows, err := db.GetWebAuthnCredentials(dbConn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	passkeys := make([]Passkey, len(rows))
+	for i, row := range rows {
+		passkeys[i] = Passkey{
+			ID:        row["id"],
+			Name:      row["name"],
+			CreatedAt: row["created_at"],
+			LastUsed:  row["last_used"],
+		}
+	}
+	return passkeys, nil
+}
+
+// DeletePasskey removes one of the user's authenticators.
+func DeletePasskey(dbConn *sql.DB, username, id string) error {
+	return db.DeleteWebAuthnCredential(dbConn, username, id)
+}
+
+// decoyUser stands in for a user without passkeys, with a credential derived
+// from their username
+func decoyUser(username string) *webauthnUser {
+	mac := hmac.New(sha256.New, decoyKey)
+	mac.Write([]byte(username))
+	id := mac.Sum(nil)
+	return &webauthnUser{username: username, id: id, credentials: []webauthn.Credential{{ID: id}}}
+}
+
+// loadWebAuthnUser returns the user's handle and credentials, generating a
+// handle if they don't have one yet and create is true.
+func loadWebAuthnUser(dbConn *sql.DB, username string, create bool) (*webauthnUser, error) {
+	id, err := db.GetWebAuthnID(dbConn, username)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		if !create {
+			return nil, ErrPasskeyLogin
+		}
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		id = base64.RawURLEncoding.EncodeToString(b)
+		if err := db.SetWebAuthnID(dbConn, username, id); err != nil {
+			return nil, err
+		}
+	}
+
+	handle, err := base64.RawURLEncoding.DecodeString(id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.GetWebAuthnCredentials(dbConn, username)
+	if err != nil {
+		return nil, err
+	}
+	credentials := make([]webauthn.Credential, len(rows))
+	for i, row := range rows {
+		if err := json.Unmarshal([]byte(row["credential"]), &credentials[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return &webauthnUser{username: username, id: handle, credentials: credentials}, nil
+}
+
+// saveChallenge stores a registration or login's state and returns a token
+// identifying it.
+func saveChallenge(dbConn *sql.DB, username string, session *webauthn.SessionData) (string, time.Time, error) {
+	token, err := generateSalt()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	encoded, err := json.Marshal(session)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiry := time.Now().Add(passkeyTimeout)
+	err = db.CreateWebAuthnChallenge(dbConn, token, username, string(encoded), expiry)
+	return token, expiry, err
+}
+
+// takeChallenge returns a registration or login's state and the user it's for,
+// making sure it can't be used again.
+func takeChallenge(dbConn *sql.DB, token string) (webauthn.SessionData, string, error) {
+	username, encoded, expiry, err := db.TakeWebAuthnChallenge(dbConn, token)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && expiry.Before(time.Now())) {
+		return webauthn.SessionData{}, "", ErrNoPasskeyChallenge
+	}
+	if err != nil {
+		return webauthn.SessionData{}, "", err
+	}
+
+	var session webauthn.SessionData
+	err = json.Unmarshal([]byte(encoded), &session)
+	return session, username, err
+}
@@ -0,0 +1,229 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package users
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+const (
+	testRPID   = "willow.example"
+	testOrigin = "https://willow.example"
+)
+
+// softAuthenticator is a software WebAuthn authenticator holding a single
+// ES256 credential
+type softAuthenticator struct {
+	key        *ecdsa.PrivateKey
+	credID     []byte
+	userHandle []byte
+	counter    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credID := make([]byte, 16)
+	if _, err := rand.Read(credID); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{key: key, credID: credID}
+}
+
+// authData builds authenticator data with the user present and verified flags
+// set, plus the attested credential if attested is true
+func (a *softAuthenticator) authData(t *testing.T, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	a.counter++
+
+	var buf bytes.Buffer
+	buf.Write(rpIDHash[:])
+	flags := byte(protocol.FlagUserPresent | protocol.FlagUserVerified)
+	if attested {
+		flags |= byte(protocol.FlagAttestedCredentialData)
+	}
+	buf.WriteByte(flags)
+	_ = binary.Write(&buf, binary.BigEndian, a.counter)
+
+	if attested {
+		buf.Write(make([]byte, 16)) // AAGUID
+		_ = binary.Write(&buf, binary.BigEndian, uint16(len(a.credID)))
+		buf.Write(a.credID)
+		coseKey, err := cbor.Marshal(map[int]any{
+			1:  2,  // kty: EC2
+			3:  -7, // alg: ES256
+			-1: 1,  // crv: P-256
+			-2: a.key.X.FillBytes(make([]byte, 32)),
+			-3: a.key.Y.FillBytes(make([]byte, 32)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(coseKey)
+	}
+	return buf.Bytes()
+}
+
+func clientData(t *testing.T, ceremony string, challenge protocol.URLEncodedBase64) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge.String(),
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// create responds to navigator.credentials.create()
+func (a *softAuthenticator) create(t *testing.T, creation *protocol.CredentialCreation) []byte {
+	a.userHandle = []byte(creation.Response.User.ID.(protocol.URLEncodedBase64))
+	attestation, err := cbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(t, true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(a.credID)
+	body, err := json.Marshal(map[string]any{
+		"id":    id,
+		"rawId": id,
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData(t, "webauthn.create", creation.Response.Challenge)),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestation),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// get responds to navigator.credentials.get()
+func (a *softAuthenticator) get(t *testing.T, assertion *protocol.CredentialAssertion) []byte {
+	authData := a.authData(t, false)
+	clientDataJSON := clientData(t, "webauthn.get", assertion.Response.Challenge)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(a.credID)
+	body, err := json.Marshal(map[string]any{
+		"id":    id,
+		"rawId": id,
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientDataJSON),
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"signature":         base64.RawURLEncoding.EncodeToString(signature),
+			"userHandle":        base64.RawURLEncoding.EncodeToString(a.userHandle),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestPasskeys(t *testing.T) {
+	dbConn := testDB(t)
+	wa, err := NewWebAuthn(testRPID, testOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator := newSoftAuthenticator(t)
+
+	creation, token, _, err := BeginPasskeyRegistration(dbConn, wa, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := authenticator.create(t, creation)
+	if err := FinishPasskeyRegistration(dbConn, wa, token, "alice", "Laptop", bytes.NewReader(body)); err != nil {
+		t.Fatal(err)
+	}
+	// Challenges can only be used once
+	if err := FinishPasskeyRegistration(dbConn, wa, token, "alice", "Laptop", bytes.NewReader(body)); err != ErrNoPasskeyChallenge {
+		t.Errorf("reusing registration challenge error = %v, want %v", err, ErrNoPasskeyChallenge)
+	}
+
+	passkeys, err := ListPasskeys(dbConn, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passkeys) != 1 || passkeys[0].Name != "Laptop" {
+		t.Fatalf("ListPasskeys() = %+v, want one passkey called Laptop", passkeys)
+	}
+
+	for _, username := range []string{"", "alice"} {
+		assertion, token, _, err := BeginPasskeyLogin(dbConn, wa, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := FinishPasskeyLogin(dbConn, wa, token, bytes.NewReader(authenticator.get(t, assertion)))
+		if err != nil {
+			t.Fatalf("FinishPasskeyLogin() with username %q error = %v", username, err)
+		}
+		if got != "alice" {
+			t.Errorf("FinishPasskeyLogin() with username %q = %q, want alice", username, got)
+		}
+	}
+
+	// A response signed by a different key must be rejected
+	assertion, token, _, err := BeginPasskeyLogin(dbConn, wa, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	impostor := newSoftAuthenticator(t)
+	impostor.credID = authenticator.credID
+	impostor.userHandle = authenticator.userHandle
+	impostor.counter = authenticator.counter
+	if _, err := FinishPasskeyLogin(dbConn, wa, token, bytes.NewReader(impostor.get(t, assertion))); err == nil {
+		t.Error("FinishPasskeyLogin() accepted a signature from the wrong key")
+	}
+
+	if err := DeletePasskey(dbConn, "alice", passkeys[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	// Users without passkeys and users that don't exist look like they have
+	// one, which can't be used
+	for _, username := range []string{"alice", "nobody"} {
+		assertion, token, _, err := BeginPasskeyLogin(dbConn, wa, username)
+		if err != nil {
+			t.Fatalf("BeginPasskeyLogin(%s) without passkeys error = %v", username, err)
+		}
+		if n := len(assertion.Response.AllowedCredentials); n != 1 {
+			t.Errorf("BeginPasskeyLogin(%s) without passkeys allowed %d credentials, want a decoy", username, n)
+		}
+		again, _, _, _ := BeginPasskeyLogin(dbConn, wa, username)
+		if !bytes.Equal(again.Response.AllowedCredentials[0].CredentialID, assertion.Response.AllowedCredentials[0].CredentialID) {
+			t.Errorf("BeginPasskeyLogin(%s) gave a different decoy each time", username)
+		}
+		if _, err := FinishPasskeyLogin(dbConn, wa, token, bytes.NewReader(authenticator.get(t, assertion))); !errors.Is(err, ErrPasskeyLogin) {
+			t.Errorf("FinishPasskeyLogin(%s) with a deleted passkey error = %v, want %v", username, err, ErrPasskeyLogin)
+		}
+	}
+}
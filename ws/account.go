@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"git.sr.ht/~amolith/willow/audit"
+	"git.sr.ht/~amolith/willow/users"
+)
+
+// accountPage is the data for account.html
+type accountPage struct {
+	Username    string
+	Email       string
+	HasPassword bool
+	// PasswordReset is true if reset links can be emailed
+	PasswordReset bool
+	// Message confirms the last change
+	Message string
+}
+
+// passwordResetPage is the data for login-forgot.html and login-reset.html
+type passwordResetPage struct {
+	Token string
+	Sent  bool
+}
+
+// AccountHandler lets users change their password and email address.
+func (h Handler) AccountHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := h.sessionUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		var message string
+		switch r.FormValue("action") {
+		case "email":
+			err = users.SetEmail(h.DbConn, username, bmStrict.Sanitize(r.FormValue("email")))
+			message = "email"
+		case "password":
+			if r.FormValue("new_password") != r.FormValue("confirm_password") {
+				err = errors.New("passwords do not match")
+				break
+			}
+			err = users.ChangePassword(h.DbConn, username, r.FormValue("current_password"), r.FormValue("new_password"))
+			if err != nil {
+				break
+			}
+			// Anyone who knew the old password is logged out, but the user
+			// stays logged in here
+			if err = users.RevokeAllSessions(h.DbConn, username); err == nil {
+				err = h.startSession(w, r, username)
+			}
+			message = "password"
+		default:
+			err = fmt.Errorf("unknown action")
+		}
+
+		if errors.Is(err, users.ErrWrongPassword) {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, err := w.Write([]byte("Incorrect current password"))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte(fmt.Sprintf("Error updating account: %s", err)))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		http.Redirect(w, r, "/account?changed="+message, http.StatusSeeOther)
+		return
+	}
+
+	email, err := users.GetEmail(h.DbConn, username)
+	var hasPassword bool
+	if err == nil {
+		hasPassword, err = users.HasPassword(h.DbConn, username)
+	}
+	if err != nil {
+		fmt.Println("Error getting account:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte("Internal Server Error"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	data := accountPage{
+		Username:      username,
+		Email:         email,
+		HasPassword:   hasPassword,
+		PasswordReset: h.Email != nil,
+	}
+	switch r.URL.Query().Get("changed") {
+	case "email":
+		data.Message = "Your email address has been saved."
+	case "password":
+		data.Message = "Your password has been changed and your other sessions have been logged out."
+	}
+
+	tmpl := h.parseTemplate(r, "static/account.html")
+	if err := tmpl.Execute(w, data); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// ForgotPasswordHandler emails a password reset link to users who have an
+// email address. It responds the same way whether or not the account exists.
+func (h Handler) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if h.Email == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := passwordResetPage{}
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		ip := h.clientIP(r)
+		// Each request counts against the IP so the form can't be used to
+		// flood inboxes
+		if wait := h.Logins.Attempt(ip, ""); wait > 0 {
+			tooManyLogins(w, wait)
+			return
+		}
+
+		username, address, token, err := users.StartPasswordReset(h.DbConn, bmStrict.Sanitize(r.FormValue("username")))
+		if err != nil {
+			fmt.Println("Error starting password reset:", err)
+		} else if token != "" {
+			link := h.baseURL(r) + "/login/reset?token=" + url.QueryEscape(token)
+			body := fmt.Sprintf("Someone, hopefully you, asked to reset the password for %s on Willow.\n\n"+
+				"Open this link within an hour to choose a new one:\n\n%s\n\n"+
+				"If you didn't ask for this, you can ignore this email and your password won't change.\n", username, link)
+			go func() {
+				if err := h.Email.Send(address, "Reset your Willow password", body); err != nil {
+					fmt.Println("Error sending password reset email:", err)
+				}
+			}()
+		}
+		data.Sent = true
+	}
+
+	tmpl := h.parseTemplate(r, "static/login-forgot.html")
+	if err := tmpl.Execute(w, data); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// ResetPasswordHandler lets users choose a new password with the token from a
+// password reset email.
+func (h Handler) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			fmt.Println(err)
+		}
+		if r.FormValue("new_password") != r.FormValue("confirm_password") {
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte("Passwords do not match"))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+
+		username, err := users.FinishPasswordReset(h.DbConn, r.FormValue("token"), r.FormValue("new_password"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte(fmt.Sprintf("Error resetting password: %s", err)))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		audit.Record(h.DbConn, username, audit.ResetPassword, username, "reset link from "+h.clientIP(r))
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if _, err := users.PasswordResetUser(h.DbConn, token); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte(users.ErrInvalidResetToken.Error()))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	tmpl := h.parseTemplate(r, "static/login-reset.html")
+	if err := tmpl.Execute(w, passwordResetPage{Token: bmStrict.Sanitize(token)}); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,160 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ws
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"git.sr.ht/~amolith/willow/audit"
+	"git.sr.ht/~amolith/willow/users"
+)
+
+// adminUsersPage is the data for admin-users.html
+type adminUsersPage struct {
+	User  users.User
+	Users []users.User
+	Roles []string
+}
+
+// AdminUsersHandler lets admins add and delete users and change their roles.
+func (h Handler) AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if !users.IsAdmin(user.Role) {
+		w.WriteHeader(http.StatusForbidden)
+		_, err := w.Write([]byte("Only admins can manage users"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			fmt.Println(err)
+		}
+		action := r.FormValue("action")
+		username := bmStrict.Sanitize(r.FormValue("username"))
+		role := r.FormValue("role")
+
+		if username == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte("No username provided"))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+
+		var auditAction, details string
+		switch action {
+		case "add":
+			if r.FormValue("password") == "" {
+				err = fmt.Errorf("no password provided")
+				break
+			}
+			err = users.Register(h.DbConn, username, r.FormValue("password"), role)
+			auditAction, details = audit.CreateUser, role
+		case "role":
+			err = users.SetRole(h.DbConn, username, role)
+			auditAction, details = audit.SetRole, role
+		case "delete":
+			err = users.Delete(h.DbConn, username)
+			auditAction = audit.DeleteUser
+		default:
+			err = fmt.Errorf("unknown action %q", action)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte(fmt.Sprintf("Error managing user: %s", err)))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		audit.Record(h.DbConn, user.Username, auditAction, username, details)
+
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	allUsers, err := users.ListUsers(h.DbConn)
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte("Internal Server Error"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	data := adminUsersPage{
+		User:  user,
+		Users: allUsers,
+		Roles: []string{users.RoleAdmin, users.RoleEditor, users.RoleViewer},
+	}
+	tmpl := h.parseTemplate(r, "static/admin-users.html")
+	if err := tmpl.Execute(w, data); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// adminAuditPage is the data for admin-audit.html
+type adminAuditPage struct {
+	Entries []audit.Entry
+}
+
+// auditPageSize is how many of the most recent audit log entries are shown
+const auditPageSize = 200
+
+// AdminAuditHandler shows admins the most recent audit log entries and, at
+// /admin/audit.jsonl, exports all of them as JSON lines.
+func (h Handler) AdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if !users.IsAdmin(user.Role) {
+		w.WriteHeader(http.StatusForbidden)
+		_, err := w.Write([]byte("Only admins can view the audit log"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	if strings.HasSuffix(r.URL.Path, ".jsonl") {
+		w.Header().Set("Content-Type", "application/jsonl")
+		w.Header().Set("Content-Disposition", `attachment; filename="willow-audit.jsonl"`)
+		if err := audit.Export(h.DbConn, w); err != nil {
+			fmt.Println("Error exporting audit log:", err)
+		}
+		return
+	}
+
+	entries, err := audit.List(h.DbConn, auditPageSize)
+	if err != nil {
+		fmt.Println("Error listing audit log:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte("Internal Server Error"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	tmpl := h.parseTemplate(r, "static/admin-audit.html")
+	if err := tmpl.Execute(w, adminAuditPage{Entries: entries}); err != nil {
+		fmt.Println(err)
+	}
+}
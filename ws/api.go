@@ -0,0 +1,214 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ws
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"git.sr.ht/~amolith/willow/api"
+	"git.sr.ht/~amolith/willow/audit"
+	"git.sr.ht/~amolith/willow/project"
+	"git.sr.ht/~amolith/willow/users"
+)
+
+// maxAPIRequestSize is the largest request body the API reads
+const maxAPIRequestSize = 1 << 20
+
+type apiTokenPage struct {
+	HasToken bool
+	Token    users.APIToken
+	// NewToken is only set right after it's generated
+	NewToken string
+}
+
+// APITokenHandler shows the user whether they have an API token and lets them
+// generate a new one or revoke it.
+func (h Handler) APITokenHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := h.sessionUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	var page apiTokenPage
+	if r.Method == http.MethodPost {
+		switch bmStrict.Sanitize(r.FormValue("action")) {
+		case "generate":
+			token, err := users.GenerateAPIToken(h.DbConn, username)
+			if err != nil {
+				fmt.Println("Error generating API token:", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				_, err := w.Write([]byte("Internal Server Error"))
+				if err != nil {
+					fmt.Println(err)
+				}
+				return
+			}
+			audit.Record(h.DbConn, username, audit.CreateAPIToken, username, "")
+			page.NewToken = token
+		case "revoke":
+			if err := users.RevokeAPIToken(h.DbConn, username); err != nil {
+				fmt.Println("Error revoking API token:", err)
+			} else {
+				audit.Record(h.DbConn, username, audit.RevokeAPIToken, username, "")
+			}
+			http.Redirect(w, r, "/api", http.StatusSeeOther)
+			return
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte("No data provided"))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+	}
+
+	if page.NewToken == "" {
+		var err error
+		page.Token, page.HasToken, err = users.GetAPIToken(h.DbConn, username)
+		if err != nil {
+			fmt.Println("Error getting API token:", err)
+		}
+	}
+
+	tmpl := h.parseTemplate(r, "static/api.html")
+	if err := tmpl.Execute(w, page); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// APIHandler serves the JSON API under /api/v1/ to anyone presenting a valid
+// API token in the Authorization header. It never looks at session cookies,
+// so it doesn't need CSRF tokens.
+func (h Handler) APIHandler(w http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	username, ok, err := users.APITokenUser(h.DbConn, strings.TrimSpace(token))
+	if err != nil {
+		fmt.Println("Error checking API token:", err)
+	}
+	if !ok {
+		writeAPIError(w, http.StatusUnauthorized, "Invalid API token")
+		return
+	}
+	role, err := users.GetRole(h.DbConn, username)
+	if err != nil {
+		fmt.Println("Error getting user's role:", err)
+		writeAPIError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	endpoint := strings.TrimPrefix(r.URL.Path, api.Prefix)
+	method := http.MethodPost
+	if endpoint == "/projects" {
+		method = http.MethodGet
+	}
+	switch endpoint {
+	case "/projects", "/running", "/refresh":
+	default:
+		writeAPIError(w, http.StatusNotFound, "No such endpoint")
+		return
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeAPIError(w, http.StatusMethodNotAllowed, "Use "+method)
+		return
+	}
+	if method == http.MethodPost && !users.CanEdit(role) {
+		writeAPIError(w, http.StatusForbidden, "Viewers can't manage projects")
+		return
+	}
+
+	switch endpoint {
+	case "/projects":
+		h.apiProjects(w, r, username)
+	case "/running":
+		h.apiSetRunning(w, r, username)
+	case "/refresh":
+		project.RequestRefresh(h.ManualRefresh)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// apiProjects lists the user's projects with the releases the refresh loop has
+// stored, or only those that are outdated when the outdated parameter is true
+func (h Handler) apiProjects(w http.ResponseWriter, r *http.Request, username string) {
+	projects, err := project.GetStoredProjectsWithReleases(h.DbConn, username)
+	if err != nil {
+		fmt.Println(err)
+		writeAPIError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	outdated := r.URL.Query().Get("outdated") == "true"
+	list := make([]api.Project, 0, len(projects))
+	for _, p := range projects {
+		if outdated && !p.Outdated() {
+			continue
+		}
+		list = append(list, api.FromProject(p))
+	}
+	writeAPIJSON(w, http.StatusOK, list)
+}
+
+// apiSetRunning sets the version the user or one of their deployments is
+// running
+func (h Handler) apiSetRunning(w http.ResponseWriter, r *http.Request, username string) {
+	var req api.SetRunning
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxAPIRequestSize)).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	req.Project = strings.TrimSpace(bmStrict.Sanitize(req.Project))
+	req.Version = strings.TrimSpace(bmStrict.Sanitize(req.Version))
+	req.Deployment = strings.TrimSpace(bmStrict.Sanitize(req.Deployment))
+	req.Notes = bmStrict.Sanitize(req.Notes)
+	if req.Project == "" || req.Version == "" {
+		writeAPIError(w, http.StatusBadRequest, "project and version are required")
+		return
+	}
+
+	p, err := project.Find(h.DbConn, username, req.Project)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeAPIError(w, http.StatusNotFound, "You don't track "+req.Project)
+		return
+	} else if errors.Is(err, project.ErrAmbiguous) {
+		writeAPIError(w, http.StatusConflict, req.Project+" matches more than one project; give its ID instead")
+		return
+	} else if err != nil {
+		fmt.Println("Error finding project:", err)
+		writeAPIError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	err = project.SetRunning(h.DbConn, h.Mu, username, p, req.Version, req.Deployment, req.Notes)
+	if errors.Is(err, project.ErrManaged) {
+		writeAPIError(w, http.StatusForbidden, "This project is managed by the projects file")
+		return
+	} else if err != nil {
+		fmt.Println("Error setting running version:", err)
+		writeAPIError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIJSON(w, status, api.Error{Error: message})
+}
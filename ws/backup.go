@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ws
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"git.sr.ht/~amolith/willow/backup"
+	"git.sr.ht/~amolith/willow/project"
+	"git.sr.ht/~amolith/willow/users"
+)
+
+// backupPage is the data for backup.html. Result is nil until a list has been
+// uploaded.
+type backupPage struct {
+	CanEdit bool
+	Formats []string
+	Result  *backup.Result
+}
+
+// BackupHandler offers the user's project list for download and restores
+// lists exported from Willow or feed readers
+func (h Handler) BackupHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	page := backupPage{CanEdit: users.CanEdit(user.Role), Formats: backup.Formats}
+
+	if r.Method == http.MethodPost {
+		if !page.CanEdit {
+			w.WriteHeader(http.StatusForbidden)
+			_, err := w.Write([]byte("Viewers can't manage projects"))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+
+		file, header, err := r.FormFile("list")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte("No project list was uploaded"))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(file, maxManifestSize))
+		file.Close()
+		if err != nil {
+			fmt.Println("Error reading uploaded project list:", err)
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte("Error reading upload"))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+
+		list, err := backup.Decode(header.Filename, data)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte(bmStrict.Sanitize(err.Error())))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		result, err := backup.Restore(h.DbConn, h.Mu, user.Username, list)
+		if err != nil {
+			fmt.Println("Error restoring projects:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			_, err := w.Write([]byte("Internal Server Error"))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		for i, s := range result.Skipped {
+			result.Skipped[i].Err = fmt.Errorf("%s", bmStrict.Sanitize(s.Err.Error()))
+		}
+		page.Result = &result
+
+		if len(result.Restored) > 0 {
+			project.RequestRefresh(h.ManualRefresh)
+		}
+	}
+
+	tmpl := h.parseTemplate(r, "static/backup.html")
+	if err := tmpl.Execute(w, page); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// ExportHandler downloads the user's project list in the format named in the
+// query
+func (h Handler) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if !slices.Contains(backup.Formats, format) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte("Unknown export format"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	list, err := backup.Export(h.DbConn, user.Username)
+	if err != nil {
+		fmt.Println("Error exporting projects:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte("Internal Server Error"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+	var buf bytes.Buffer
+	if err := backup.Encode(&buf, list, format, "Projects "+user.Username+" tracks in Willow"); err != nil {
+		fmt.Println("Error encoding projects:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte("Internal Server Error"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", backup.ContentType(format))
+	w.Header().Set("Content-Disposition", `attachment; filename="willow-projects.`+format+`"`)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,138 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ws
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"text/template"
+
+	"git.sr.ht/~amolith/willow/api"
+	"git.sr.ht/~amolith/willow/users"
+)
+
+const (
+	// csrfField is the form field state-changing forms carry their token in
+	csrfField = "csrf_token"
+	// csrfHeader is the header scripts send the token in instead
+	csrfHeader = "X-CSRF-Token"
+)
+
+// CSRF rejects state-changing requests made with a session cookie unless they
+// carry that session's CSRF token, and those made without one, like logins,
+// if the browser says they came from another site.
+func (h Handler) CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The API only believes tokens in the Authorization header, which
+		// browsers don't add to cross-site requests by themselves
+		if strings.HasPrefix(r.URL.Path, api.Prefix+"/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if h.ProxyAuthHeader != "" {
+			// The proxy adds its header to cross-site requests too and
+			// there's no session to keep a token in, so the browser has to
+			// say the request came from Willow
+			if !h.fromWillow(r) {
+				forbidCSRF(w)
+				return
+			}
+		} else if _, ok := h.sessionUser(r); ok {
+			expected := h.csrfToken(r)
+			got := r.Header.Get(csrfHeader)
+			if got == "" {
+				got = r.PostFormValue(csrfField)
+			}
+			if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(got)) != 1 {
+				forbidCSRF(w)
+				return
+			}
+		} else if !h.sameOrigin(r) {
+			forbidCSRF(w)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// csrfToken returns the CSRF token for the request's session, or an empty
+// string if there isn't a valid one.
+func (h Handler) csrfToken(r *http.Request) string {
+	if _, ok := h.sessionUser(r); !ok {
+		return ""
+	}
+	cookie, err := r.Cookie("id")
+	if err != nil {
+		return ""
+	}
+	token, err := users.SessionCSRFToken(h.DbConn, cookie.Value)
+	if err != nil {
+		fmt.Println("Error getting CSRF token:", err)
+		return ""
+	}
+	return token
+}
+
+// sameOrigin returns false if the request's Origin header names a different
+// host than Willow's. Requests without one, such as those from API clients,
+// are allowed.
+func (h Handler) sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	o, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	b, err := url.Parse(h.baseURL(r))
+	if err != nil {
+		return false
+	}
+	return o.Host == b.Host
+}
+
+// fromWillow returns true if the browser says the request was sent from one
+// of Willow's own pages, through either the Origin or Sec-Fetch-Site header.
+func (h Handler) fromWillow(r *http.Request) bool {
+	if r.Header.Get("Origin") != "" {
+		return h.sameOrigin(r)
+	}
+	return r.Header.Get("Sec-Fetch-Site") == "same-origin"
+}
+
+func forbidCSRF(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusForbidden)
+	_, err := w.Write([]byte("Invalid or missing CSRF token, please reload the page and try again"))
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// parseTemplate parses one of the embedded templates with csrfField and
+// csrfToken functions for the request's session.
+func (h Handler) parseTemplate(r *http.Request, name string) *template.Template {
+	token := h.csrfToken(r)
+	return template.Must(template.New(path.Base(name)).Funcs(template.FuncMap{
+		"csrfField": func() string {
+			if token == "" {
+				return ""
+			}
+			return `<input type="hidden" name="` + csrfField + `" value="` + template.HTMLEscapeString(token) + `">`
+		},
+		"csrfToken": func() string { return token },
+	}).ParseFS(fs, name))
+}
@@ -0,0 +1,398 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ws
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"git.sr.ht/~amolith/willow/project"
+	"git.sr.ht/~amolith/willow/users"
+)
+
+// maxFeedItems is the maximum number of entries included in a feed
+const maxFeedItems = 100
+
+type feedsPage struct {
+	BaseURL   string
+	HasToken  bool
+	CreatedAt string
+	// NewToken is only set right after it's generated
+	NewToken string
+}
+
+type feedItem struct {
+	ID      string
+	Title   string
+	URL     string
+	Content string
+	Date    time.Time
+}
+
+type (
+	atomFeed struct {
+		XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+		Title   string      `xml:"title"`
+		ID      string      `xml:"id"`
+		Updated string      `xml:"updated"`
+		Author  atomAuthor  `xml:"author"`
+		Links   []atomLink  `xml:"link"`
+		Entries []atomEntry `xml:"entry"`
+	}
+
+	atomAuthor struct {
+		Name string `xml:"name"`
+	}
+
+	atomLink struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr,omitempty"`
+	}
+
+	atomEntry struct {
+		Title   string      `xml:"title"`
+		ID      string      `xml:"id"`
+		Updated string      `xml:"updated"`
+		Link    *atomLink   `xml:"link,omitempty"`
+		Content atomContent `xml:"content"`
+	}
+
+	atomContent struct {
+		Type string `xml:"type,attr"`
+		Body string `xml:",chardata"`
+	}
+)
+
+type (
+	rssFeed struct {
+		XMLName xml.Name   `xml:"rss"`
+		Version string     `xml:"version,attr"`
+		Channel rssChannel `xml:"channel"`
+	}
+
+	rssChannel struct {
+		Title       string    `xml:"title"`
+		Link        string    `xml:"link"`
+		Description string    `xml:"description"`
+		Items       []rssItem `xml:"item"`
+	}
+
+	rssItem struct {
+		Title       string  `xml:"title"`
+		Link        string  `xml:"link,omitempty"`
+		Description string  `xml:"description"`
+		GUID        rssGUID `xml:"guid"`
+		PubDate     string  `xml:"pubDate,omitempty"`
+	}
+
+	rssGUID struct {
+		IsPermaLink string `xml:"isPermaLink,attr"`
+		Value       string `xml:",chardata"`
+	}
+)
+
+type (
+	jsonFeed struct {
+		Version     string         `json:"version"`
+		Title       string         `json:"title"`
+		HomePageURL string         `json:"home_page_url"`
+		FeedURL     string         `json:"feed_url"`
+		Items       []jsonFeedItem `json:"items"`
+	}
+
+	jsonFeedItem struct {
+		ID            string `json:"id"`
+		URL           string `json:"url,omitempty"`
+		Title         string `json:"title"`
+		ContentHTML   string `json:"content_html"`
+		DatePublished string `json:"date_published,omitempty"`
+	}
+)
+
+// FeedsHandler lets the user generate the token embedded in their feed URLs
+// and shows the URLs once, right after it's generated.
+func (h Handler) FeedsHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := h.sessionUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	page := feedsPage{BaseURL: h.baseURL(r)}
+	if r.Method == http.MethodPost {
+		token, err := users.RegenerateFeedToken(h.DbConn, username)
+		if err != nil {
+			fmt.Println("Error regenerating feed token:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			_, err := w.Write([]byte("Internal Server Error"))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		page.NewToken = token
+	}
+
+	if page.NewToken == "" {
+		var err error
+		page.CreatedAt, page.HasToken, err = users.FeedTokenCreated(h.DbConn, username)
+		if err != nil {
+			fmt.Println("Error getting feed token:", err)
+		}
+	}
+
+	tmpl := h.parseTemplate(r, "static/feeds.html")
+	if err := tmpl.Execute(w, page); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// FeedHandler serves /feeds/{releases,outdated}.{atom,rss,json} to anyone
+// presenting a valid feed token in the URL.
+func (h Handler) FeedHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok, err := users.FeedTokenUser(h.DbConn, r.URL.Query().Get("token"))
+	if err != nil {
+		fmt.Println("Error checking feed token:", err)
+	}
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, err := w.Write([]byte("Invalid feed token"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	file := strings.TrimPrefix(r.URL.Path, "/feeds/")
+	format := strings.TrimPrefix(path.Ext(file), ".")
+	name := strings.TrimSuffix(file, path.Ext(file))
+
+	projects, err := project.GetProjectsWithReleases(h.DbConn, h.Mu, username)
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte("Internal Server Error"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	var (
+		title string
+		items []feedItem
+	)
+	switch name {
+	case "releases":
+		title = "Willow: new releases"
+		items = releaseItems(projects)
+	case "outdated":
+		title = "Willow: outdated projects"
+		items = outdatedItems(projects)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	baseURL := h.baseURL(r)
+	selfURL := baseURL + r.URL.RequestURI()
+
+	switch format {
+	case "atom":
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		err = writeAtom(w, title, baseURL, selfURL, items)
+	case "rss":
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		err = writeRSS(w, title, baseURL, items)
+	case "json":
+		w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+		err = writeJSONFeed(w, title, baseURL, selfURL, items)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		fmt.Println("Error writing feed:", err)
+	}
+}
+
+// releaseItems returns the most recent releases across all of the user's
+// projects, newest first.
+func releaseItems(projects []project.Project) []feedItem {
+	items := make([]feedItem, 0)
+	for _, p := range projects {
+		for _, release := range p.Releases {
+			items = append(items, feedItem{
+				ID:      "urn:willow:release:" + release.ID,
+				Title:   p.Name + " " + release.Tag,
+				URL:     releaseURL(p, release),
+				Content: releaseHTML(p, release),
+				Date:    release.Date,
+			})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Date.After(items[j].Date)
+	})
+	if len(items) > maxFeedItems {
+		items = items[:maxFeedItems]
+	}
+	return items
+}
+
+// outdatedItems returns an item for each project whose latest release differs
+// from the one the user is running. The item's ID includes the latest release
+// so feed readers show a new entry when yet another release comes out.
+func outdatedItems(projects []project.Project) []feedItem {
+	items := make([]feedItem, 0)
+	for _, p := range projects {
+		if len(p.Releases) == 0 || p.Running == p.Releases[0].Tag {
+			continue
+		}
+		latest := p.Releases[0]
+		items = append(items, feedItem{
+			ID:      "urn:willow:outdated:" + p.ID + ":" + latest.ID,
+			Title:   fmt.Sprintf("%s: %s is available (running %s)", p.Name, latest.Tag, p.Running),
+			URL:     releaseURL(p, latest),
+			Content: releaseHTML(p, latest),
+			Date:    latest.Date,
+		})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Date.After(items[j].Date)
+	})
+	return items
+}
+
+// releaseURL returns a link to the release, falling back to the project
+// itself for forges that don't have release pages.
+func releaseURL(p project.Project, release project.Release) string {
+	if release.URL != "" {
+		return release.URL
+	}
+	return p.URL
+}
+
+// releaseHTML returns the release notes as HTML. Notes from RSS feeds are
+// already (sanitised) HTML while tag messages are plain text.
+func releaseHTML(p project.Project, release project.Release) string {
+	switch p.Forge {
+	case "github", "gitea", "forgejo":
+		return release.Content
+	default:
+		return "<pre>" + html.EscapeString(release.Content) + "</pre>"
+	}
+}
+
+// feedDate returns the date in RFC 3339 format, substituting the current time
+// for releases without a date.
+func feedDate(date time.Time) string {
+	if date.IsZero() {
+		date = time.Now()
+	}
+	return date.UTC().Format(time.RFC3339)
+}
+
+func writeAtom(w io.Writer, title, baseURL, selfURL string, items []feedItem) error {
+	// The token is left out of the feed's ID so it doesn't change when the
+	// token is regenerated
+	id, _, _ := strings.Cut(selfURL, "?")
+	feed := atomFeed{
+		Title:  title,
+		ID:     id,
+		Author: atomAuthor{Name: "Willow"},
+		Links: []atomLink{
+			{Href: baseURL + "/"},
+			{Href: selfURL, Rel: "self"},
+		},
+		Entries: make([]atomEntry, 0, len(items)),
+	}
+
+	updated := time.Time{}
+	for _, item := range items {
+		entry := atomEntry{
+			Title:   item.Title,
+			ID:      item.ID,
+			Updated: feedDate(item.Date),
+			Content: atomContent{Type: "html", Body: item.Content},
+		}
+		if item.URL != "" {
+			entry.Link = &atomLink{Href: item.URL}
+		}
+		feed.Entries = append(feed.Entries, entry)
+		if item.Date.After(updated) {
+			updated = item.Date
+		}
+	}
+	feed.Updated = feedDate(updated)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(feed)
+}
+
+func writeRSS(w io.Writer, title, baseURL string, items []feedItem) error {
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       title,
+			Link:        baseURL + "/",
+			Description: title,
+			Items:       make([]rssItem, 0, len(items)),
+		},
+	}
+
+	for _, item := range items {
+		rssItem := rssItem{
+			Title:       item.Title,
+			Link:        item.URL,
+			Description: item.Content,
+			GUID:        rssGUID{IsPermaLink: "false", Value: item.ID},
+		}
+		if !item.Date.IsZero() {
+			rssItem.PubDate = item.Date.UTC().Format(time.RFC1123Z)
+		}
+		feed.Channel.Items = append(feed.Channel.Items, rssItem)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(feed)
+}
+
+func writeJSONFeed(w io.Writer, title, baseURL, selfURL string, items []feedItem) error {
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       title,
+		HomePageURL: baseURL + "/",
+		FeedURL:     selfURL,
+		Items:       make([]jsonFeedItem, 0, len(items)),
+	}
+
+	for _, item := range items {
+		jsonItem := jsonFeedItem{
+			ID:          item.ID,
+			URL:         item.URL,
+			Title:       item.Title,
+			ContentHTML: item.Content,
+		}
+		if !item.Date.IsZero() {
+			jsonItem.DatePublished = item.Date.UTC().Format(time.RFC3339)
+		}
+		feed.Items = append(feed.Items, jsonItem)
+	}
+
+	return json.NewEncoder(w).Encode(feed)
+}
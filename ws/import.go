@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ws
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"git.sr.ht/~amolith/willow/manifest"
+	"git.sr.ht/~amolith/willow/project"
+	"git.sr.ht/~amolith/willow/users"
+)
+
+// maxManifestSize is the most Willow reads of each uploaded manifest; lock
+// files for large projects can run to a few megabytes
+const maxManifestSize = 16 << 20
+
+// importPage is the data for import.html. Result is nil until something's
+// been uploaded.
+type importPage struct {
+	Supported []string
+	Result    *manifest.Result
+}
+
+// ImportHandler tracks the dependencies in uploaded manifests and SBOMs
+func (h Handler) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if !users.CanEdit(user.Role) {
+		w.WriteHeader(http.StatusForbidden)
+		_, err := w.Write([]byte("Viewers can't manage projects"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	page := importPage{Supported: manifest.Supported()}
+
+	if r.Method == http.MethodPost {
+		err := r.ParseMultipartForm(maxManifestSize)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte("Error reading upload: " + bmStrict.Sanitize(err.Error())))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+
+		var docs []manifest.Document
+		for _, header := range r.MultipartForm.File["manifests"] {
+			f, err := header.Open()
+			if err != nil {
+				fmt.Println("Error opening uploaded manifest:", err)
+				continue
+			}
+			data, err := io.ReadAll(io.LimitReader(f, maxManifestSize))
+			f.Close()
+			if err != nil {
+				fmt.Println("Error reading uploaded manifest:", err)
+				continue
+			}
+
+			doc, err := manifest.Parse(header.Filename, data)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_, err := w.Write([]byte(bmStrict.Sanitize(err.Error())))
+				if err != nil {
+					fmt.Println(err)
+				}
+				return
+			}
+			docs = append(docs, doc)
+		}
+		if len(docs) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte("No manifests were uploaded"))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+
+		untrack := r.FormValue("untrack") == "on"
+		resolver := manifest.Resolver{Upstream: r.FormValue("upstream") == "on"}
+		var result manifest.Result
+		for _, doc := range docs {
+			imported, err := manifest.Import(h.DbConn, h.Mu, user.Username, doc, resolver, untrack)
+			if err != nil {
+				fmt.Println("Error importing dependencies:", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				_, err := w.Write([]byte("Internal Server Error"))
+				if err != nil {
+					fmt.Println(err)
+				}
+				return
+			}
+			result.Imported = append(result.Imported, imported.Imported...)
+			result.Skipped = append(result.Skipped, imported.Skipped...)
+			result.Untracked = append(result.Untracked, imported.Untracked...)
+		}
+		for i, o := range result.Skipped {
+			result.Skipped[i].Err = fmt.Errorf("%s", bmStrict.Sanitize(o.Err.Error()))
+		}
+		page.Result = &result
+
+		if len(result.Imported) > 0 {
+			project.RequestRefresh(h.ManualRefresh)
+		}
+	}
+
+	tmpl := h.parseTemplate(r, "static/import.html")
+	if err := tmpl.Execute(w, page); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"git.sr.ht/~amolith/willow/users"
+)
+
+// oidcStateCookie binds a login started with the identity provider to the
+// browser that started it
+const oidcStateCookie = "oidc_state"
+
+// OIDCLoginHandler sends the user to the identity provider to log in.
+func (h Handler) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	state, authURL, err := h.OIDC.AuthCodeURL(r.Context())
+	if err != nil {
+		fmt.Println("Error starting OIDC login:", err)
+		w.WriteHeader(http.StatusBadGateway)
+		_, err := w.Write([]byte("Error contacting the identity provider"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	// Lax rather than Strict because the identity provider redirects back
+	// to us from another site
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/login/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   true,
+	})
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// OIDCCallbackHandler finishes logging in with the identity provider, creating
+// the user's account on their first login.
+func (h Handler) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	params := r.URL.Query()
+	if e := params.Get("error"); e != "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, err := w.Write([]byte(fmt.Sprintf("Identity provider refused login: %s", bmStrict.Sanitize(e))))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	state := params.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte("Login attempt doesn't match this browser, please try again"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/login/oidc", MaxAge: -1})
+
+	identity, err := h.OIDC.Exchange(r.Context(), state, params.Get("code"))
+	if err != nil {
+		fmt.Println("Error finishing OIDC login:", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		_, err := w.Write([]byte("Error logging in with the identity provider"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+	username, err := users.ExternalLogin(h.DbConn, identity.Issuer, identity.Subject, bmStrict.Sanitize(identity.Username), h.OIDCDefaultRole)
+	if errors.Is(err, users.ErrLocalAccount) {
+		w.WriteHeader(http.StatusForbidden)
+		_, err := w.Write([]byte("An account with a password already uses this username, please log in with the form"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+	if errors.Is(err, users.ErrIdentityTaken) {
+		w.WriteHeader(http.StatusForbidden)
+		_, err := w.Write([]byte("Another single sign-on account already uses this username"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+	if err != nil {
+		fmt.Println("Error provisioning OIDC user:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte("Internal Server Error"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	totpEnabled, err := users.TOTPEnabled(h.DbConn, username)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte(fmt.Sprintf("Error logging in: %s", err)))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	next := "/"
+	if totpEnabled {
+		next = "/login/totp"
+		err = h.startPendingLogin(w, username)
+	} else {
+		h.recordLogin(r, username, "single sign-on")
+		err = h.startSession(w, r, username)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte(fmt.Sprintf("Error creating session: %s", err)))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	// Browsers treat a redirect chain that started on the identity provider
+	// as cross-site and wouldn't send our SameSite=Strict cookies, so
+	// navigate from a page of our own instead.
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err = fmt.Fprintf(w, `<!DOCTYPE html><meta http-equiv="refresh" content="0; url=%[1]s"><a href="%[1]s">Continue to Willow</a>`, next)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ws
+
+import (
+	"fmt"
+	"net/http"
+
+	"git.sr.ht/~amolith/willow/users"
+)
+
+// sessionsPage is the data for account-sessions.html
+type sessionsPage struct {
+	Sessions []users.Session
+}
+
+// SessionsHandler lists the devices the user is logged in on and lets them
+// log out of one or all of them.
+func (h Handler) SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := h.sessionUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	cookie, err := r.Cookie("id")
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			fmt.Println(err)
+		}
+		switch r.FormValue("action") {
+		case "revoke":
+			err = users.RevokeSession(h.DbConn, username, r.FormValue("id"))
+		case "revoke-all":
+			err = users.RevokeAllSessions(h.DbConn, username)
+		default:
+			err = fmt.Errorf("unknown action")
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte(fmt.Sprintf("Error logging out: %s", err)))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+
+		// The current session may have been one of those revoked
+		if !h.isAuthorised(r) {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+		return
+	}
+
+	sessions, err := users.ListSessions(h.DbConn, username, cookie.Value)
+	if err != nil {
+		fmt.Println("Error listing sessions:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte("Internal Server Error"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	tmpl := h.parseTemplate(r, "static/account-sessions.html")
+	if err := tmpl.Execute(w, sessionsPage{Sessions: sessions}); err != nil {
+		fmt.Println(err)
+	}
+}
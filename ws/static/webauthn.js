@@ -0,0 +1,107 @@
+/*
+ * SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// The server sends and expects binary fields as unpadded base64url strings
+
+function fromBase64URL(value) {
+	const base64 = value.replace(/-/g, "+").replace(/_/g, "/");
+	const binary = atob(base64 + "===".slice((base64.length + 3) % 4));
+	return Uint8Array.from(binary, (c) => c.charCodeAt(0)).buffer;
+}
+
+function toBase64URL(buffer) {
+	const binary = String.fromCharCode(...new Uint8Array(buffer));
+	return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+function decodeDescriptors(descriptors) {
+	return (descriptors || []).map((d) => ({ ...d, id: fromBase64URL(d.id) }));
+}
+
+function encodeCredential(credential) {
+	const response = {};
+	for (const key of ["clientDataJSON", "attestationObject", "authenticatorData", "signature", "userHandle"]) {
+		if (credential.response[key]) {
+			response[key] = toBase64URL(credential.response[key]);
+		}
+	}
+	if (credential.response.getTransports) {
+		response.transports = credential.response.getTransports();
+	}
+	return JSON.stringify({
+		id: credential.id,
+		rawId: toBase64URL(credential.rawId),
+		type: credential.type,
+		authenticatorAttachment: credential.authenticatorAttachment,
+		clientExtensionResults: credential.getClientExtensionResults(),
+		response: response,
+	});
+}
+
+async function post(url, body, contentType) {
+	const headers = { "Content-Type": contentType };
+	// Only present on pages for logged in users
+	const csrf = document.querySelector('meta[name="csrf-token"]');
+	if (csrf) {
+		headers["X-CSRF-Token"] = csrf.content;
+	}
+	const response = await fetch(url, {
+		method: "POST",
+		headers: headers,
+		body: body,
+	});
+	if (!response.ok) {
+		throw new Error(await response.text());
+	}
+	return response;
+}
+
+async function registerPasskey(name) {
+	const begin = await post("/account/passkeys/begin", "", "application/json");
+	const options = (await begin.json()).publicKey;
+	options.challenge = fromBase64URL(options.challenge);
+	options.user.id = fromBase64URL(options.user.id);
+	options.excludeCredentials = decodeDescriptors(options.excludeCredentials);
+
+	const credential = await navigator.credentials.create({ publicKey: options });
+	await post("/account/passkeys/finish?name=" + encodeURIComponent(name), encodeCredential(credential), "application/json");
+}
+
+async function loginWithPasskey(username) {
+	const form = new URLSearchParams({ username: username });
+	const begin = await post("/login/passkey/begin", form, "application/x-www-form-urlencoded");
+	const options = (await begin.json()).publicKey;
+	options.challenge = fromBase64URL(options.challenge);
+	options.allowCredentials = decodeDescriptors(options.allowCredentials);
+
+	const credential = await navigator.credentials.get({ publicKey: options });
+	await post("/login/passkey/finish", encodeCredential(credential), "application/json");
+}
+
+function showError(err) {
+	document.getElementById("passkey-error").textContent = err.message;
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+	const registerForm = document.getElementById("passkey-register");
+	if (registerForm) {
+		registerForm.addEventListener("submit", (event) => {
+			event.preventDefault();
+			registerPasskey(document.getElementById("passkey-name").value)
+				.then(() => window.location.reload())
+				.catch(showError);
+		});
+	}
+
+	const loginButton = document.getElementById("passkey-login");
+	if (loginButton) {
+		loginButton.addEventListener("click", () => {
+			loginWithPasskey(document.getElementById("username").value)
+				.then(() => window.location.assign("/"))
+				.catch(showError);
+		});
+	}
+});
@@ -0,0 +1,213 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~amolith/willow/users"
+)
+
+// pendingLoginCookie identifies a login that's waiting for its second factor
+const pendingLoginCookie = "pending_login"
+
+// accountTOTPPage is the data for account-totp.html
+type accountTOTPPage struct {
+	Enabled           bool
+	RecoveryCodesLeft int
+	// Enrolment is set while the user is setting up their authenticator
+	Enrolment *users.TOTPEnrolment
+	// RecoveryCodes is only set right after they're generated because they
+	// can't be shown again
+	RecoveryCodes []string
+}
+
+// TOTPLoginHandler asks users with two-factor authentication for their code
+// after they've entered the correct password.
+func (h Handler) TOTPLoginHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(pendingLoginCookie)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		valid, err := users.PendingLoginValid(h.DbConn, cookie.Value)
+		if err != nil {
+			fmt.Println("Error checking pending login:", err)
+		}
+		if !valid {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		tmpl := h.parseTemplate(r, "static/login-totp.html")
+		if err := tmpl.Execute(w, nil); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		ip := h.clientIP(r)
+		if wait := h.Logins.Wait(ip, ""); wait > 0 {
+			tooManyLogins(w, wait)
+			return
+		}
+
+		username, ok, err := users.FinishPendingLogin(h.DbConn, cookie.Value, r.FormValue("code"))
+		if err != nil {
+			fmt.Println("Error checking two-factor code:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			_, err := w.Write([]byte("Internal Server Error"))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		if !ok {
+			if username != "" {
+				h.Logins.Failure(ip, username)
+				if err := users.RecordFailedLogin(h.DbConn, username, ip, "incorrect two-factor code"); err != nil {
+					fmt.Println("Error recording failed login:", err)
+				}
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			_, err := w.Write([]byte("Incorrect code"))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: pendingLoginCookie, Path: "/login/totp", MaxAge: -1})
+		h.recordLogin(r, username, "two-factor authentication")
+		if err := h.startSession(w, r, username); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte(fmt.Sprintf("Error creating session: %s", err)))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
+// AccountTOTPHandler lets users enable and disable two-factor authentication
+// and regenerate their recovery codes.
+func (h Handler) AccountTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := h.sessionUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	data := accountTOTPPage{}
+
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			fmt.Println(err)
+		}
+		code := r.FormValue("code")
+
+		switch r.FormValue("action") {
+		case "confirm":
+			data.RecoveryCodes, err = users.ConfirmTOTP(h.DbConn, username, code)
+		case "restart":
+			_, err = users.BeginTOTPEnrolment(h.DbConn, username, true)
+		case "disable", "recovery":
+			var valid bool
+			valid, err = users.ValidateTOTP(h.DbConn, username, code)
+			if err == nil && !valid {
+				err = users.ErrInvalidCode
+			}
+			if err != nil {
+				break
+			}
+			if r.FormValue("action") == "disable" {
+				err = users.DisableTOTP(h.DbConn, username)
+			} else {
+				data.RecoveryCodes, err = users.RegenerateRecoveryCodes(h.DbConn, username)
+			}
+		default:
+			err = fmt.Errorf("unknown action")
+		}
+
+		if errors.Is(err, users.ErrInvalidCode) {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, err := w.Write([]byte("Incorrect code"))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte(fmt.Sprintf("Error updating two-factor authentication: %s", err)))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+
+		if data.RecoveryCodes == nil {
+			http.Redirect(w, r, "/account/totp", http.StatusSeeOther)
+			return
+		}
+	}
+
+	enabled, err := users.TOTPEnabled(h.DbConn, username)
+	if err == nil && enabled {
+		data.Enabled = true
+		data.RecoveryCodesLeft, err = users.RecoveryCodesLeft(h.DbConn, username)
+	} else if err == nil {
+		var enrolment users.TOTPEnrolment
+		enrolment, err = users.BeginTOTPEnrolment(h.DbConn, username, false)
+		data.Enrolment = &enrolment
+	}
+	if err != nil {
+		fmt.Println("Error getting two-factor authentication status:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte("Internal Server Error"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	tmpl := h.parseTemplate(r, "static/account-totp.html")
+	if err := tmpl.Execute(w, data); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// startPendingLogin sets the cookie for a login waiting on its second factor.
+func (h Handler) startPendingLogin(w http.ResponseWriter, username string) error {
+	token, expiry, err := users.StartPendingLogin(h.DbConn, username)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     pendingLoginCookie,
+		Value:    token,
+		Path:     "/login/totp",
+		MaxAge:   int(time.Until(expiry).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Secure:   true,
+	})
+	return nil
+}
@@ -0,0 +1,251 @@
+// SPDX-FileCopyrightText: Amolith <amolith@secluded.site>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~amolith/willow/users"
+)
+
+// passkeyChallengeCookie identifies a passkey registration or login that's in
+// progress
+const passkeyChallengeCookie = "passkey_challenge"
+
+// passkeysPage is the data for account-passkeys.html
+type passkeysPage struct {
+	Passkeys []users.Passkey
+}
+
+// PasskeysHandler lists the user's passkeys and security keys and lets them
+// delete them. Registering new ones happens in webauthn.js through
+// PasskeyRegisterBeginHandler and PasskeyRegisterFinishHandler.
+func (h Handler) PasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := h.sessionUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if h.WebAuthn == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			fmt.Println(err)
+		}
+		if r.FormValue("action") == "delete" {
+			err = users.DeletePasskey(h.DbConn, username, r.FormValue("id"))
+		} else {
+			err = fmt.Errorf("unknown action")
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte(fmt.Sprintf("Error updating passkeys: %s", err)))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		http.Redirect(w, r, "/account/passkeys", http.StatusSeeOther)
+		return
+	}
+
+	passkeys, err := users.ListPasskeys(h.DbConn, username)
+	if err != nil {
+		fmt.Println("Error listing passkeys:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte("Internal Server Error"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	tmpl := h.parseTemplate(r, "static/account-passkeys.html")
+	if err := tmpl.Execute(w, passkeysPage{Passkeys: passkeys}); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// PasskeyRegisterBeginHandler returns the options for registering a new
+// authenticator.
+func (h Handler) PasskeyRegisterBeginHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := h.sessionUser(r)
+	if !ok || h.WebAuthn == nil || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	creation, token, expiry, err := users.BeginPasskeyRegistration(h.DbConn, h.WebAuthn, username)
+	if err != nil {
+		fmt.Println("Error starting passkey registration:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte("Internal Server Error"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	setPasskeyChallengeCookie(w, token, expiry)
+	writeJSON(w, creation)
+}
+
+// PasskeyRegisterFinishHandler stores a newly registered authenticator.
+func (h Handler) PasskeyRegisterFinishHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := h.sessionUser(r)
+	if !ok || h.WebAuthn == nil || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	cookie, err := r.Cookie(passkeyChallengeCookie)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte("No passkey registration in progress"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+	clearPasskeyChallengeCookie(w)
+
+	name := bmStrict.Sanitize(r.URL.Query().Get("name"))
+	err = users.FinishPasskeyRegistration(h.DbConn, h.WebAuthn, cookie.Value, username, name, http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		fmt.Println("Error registering passkey:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte("Error registering passkey"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PasskeyLoginBeginHandler returns the options for logging in with an
+// authenticator. If a username is provided, only that user's credentials are
+// allowed; otherwise the browser offers any passkey it has for Willow.
+// Each login started counts against the IP like a failed password until it
+// succeeds, which also stops anyone filling the database with challenges, but
+// not against the username, so passkeys still work while someone guesses the
+// user's password.
+func (h Handler) PasskeyLoginBeginHandler(w http.ResponseWriter, r *http.Request) {
+	if h.WebAuthn == nil || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		fmt.Println(err)
+	}
+	username := bmStrict.Sanitize(r.FormValue("username"))
+
+	if wait := h.Logins.Attempt(h.clientIP(r), ""); wait > 0 {
+		tooManyLogins(w, wait)
+		return
+	}
+
+	assertion, token, expiry, err := users.BeginPasskeyLogin(h.DbConn, h.WebAuthn, username)
+	if err != nil {
+		fmt.Println("Error starting passkey login:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte("Internal Server Error"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	setPasskeyChallengeCookie(w, token, expiry)
+	writeJSON(w, assertion)
+}
+
+// PasskeyLoginFinishHandler checks the authenticator's response and starts a
+// session. Passkeys already prove possession of a device, so users with
+// two-factor authentication aren't asked for a code as well.
+func (h Handler) PasskeyLoginFinishHandler(w http.ResponseWriter, r *http.Request) {
+	if h.WebAuthn == nil || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	cookie, err := r.Cookie(passkeyChallengeCookie)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte("No passkey login in progress"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+	clearPasskeyChallengeCookie(w)
+
+	// The attempt was reserved when the login began
+	ip := h.clientIP(r)
+	if wait := h.Logins.Wait(ip, ""); wait > 0 {
+		tooManyLogins(w, wait)
+		return
+	}
+
+	username, err := users.FinishPasskeyLogin(h.DbConn, h.WebAuthn, cookie.Value, http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		if err := users.RecordFailedLogin(h.DbConn, "", ip, err.Error()); err != nil {
+			fmt.Println("Error recording failed login:", err)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		_, err := w.Write([]byte("Passkey not recognised"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	h.Logins.Success(ip, username)
+	h.recordLogin(r, username, "passkey")
+	if err := h.startSession(w, r, username); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte(fmt.Sprintf("Error creating session: %s", err)))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setPasskeyChallengeCookie(w http.ResponseWriter, token string, expiry time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     passkeyChallengeCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(time.Until(expiry).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Secure:   true,
+	})
+}
+
+func clearPasskeyChallengeCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: passkeyChallengeCookie, Path: "/", MaxAge: -1})
+}
+
+// writeJSON writes v as the JSON response body
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -7,17 +7,24 @@ package ws
 import (
 	"database/sql"
 	"embed"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
-	"text/template"
 	"time"
 
+	"git.sr.ht/~amolith/willow/audit"
+	"git.sr.ht/~amolith/willow/email"
+	"git.sr.ht/~amolith/willow/oidc"
 	"git.sr.ht/~amolith/willow/project"
 	"git.sr.ht/~amolith/willow/users"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/microcosm-cc/bluemonday"
 )
 
@@ -27,6 +34,74 @@ type Handler struct {
 	ManualRefresh *chan struct{}
 	Res           *chan []project.Project
 	Mu            *sync.Mutex
+	// BaseURL is the public URL Willow is reachable at, used for absolute
+	// links such as those in feeds
+	BaseURL string
+	// OIDC is the identity provider users can log in with, or nil if single
+	// sign-on isn't configured
+	OIDC *oidc.Provider
+	// OIDCDefaultRole is the role given to users created on their first login
+	// through the identity provider
+	OIDCDefaultRole string
+	// WebAuthn is the relying party for passkeys and security keys, or nil if
+	// they can't be used because BaseURL isn't set
+	WebAuthn *webauthn.WebAuthn
+	// Logins slows down and locks out repeated failed logins
+	Logins *users.LoginLimiter
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For headers
+	// are believed when working out a client's IP
+	TrustedProxies []netip.Prefix
+	// SessionLifetime is how long a session lasts without being used
+	SessionLifetime time.Duration
+	// Email sends password reset links, or is nil if SMTP isn't configured
+	Email *email.Sender
+	// ProxyAuthHeader is the header a trusted reverse proxy puts the logged
+	// in user's name in. When it's set, Willow doesn't handle logins itself.
+	ProxyAuthHeader string
+	// ProxyAuthDefaultRole is the role given to users created on their first
+	// request through the proxy
+	ProxyAuthDefaultRole string
+}
+
+// loginPage is the data for login.html
+type loginPage struct {
+	OIDC          bool
+	Passkeys      bool
+	PasswordReset bool
+}
+
+// selectReleasePage is the data for select-release.html. Deployment is nil when
+// the user is selecting the version they run rather than a deployment's.
+type selectReleasePage struct {
+	project.Project
+	Deployment *project.Deployment
+	// IsAdmin lets the deployment's owner be changed
+	IsAdmin bool
+}
+
+// homePage is the data for home.html
+type homePage struct {
+	User users.User
+	// Viewing is the user whose projects are shown
+	Viewing  string
+	Users    []users.User
+	CanEdit  bool
+	IsAdmin  bool
+	Passkeys bool
+	// ProxyAuth hides logging out and the login settings when the reverse
+	// proxy handles logins
+	ProxyAuth bool
+	Projects  []projectCard
+}
+
+// projectCard is a project on the home page along with whether the current
+// user may modify it
+type projectCard struct {
+	project.Project
+	CanEdit bool
+	// Username and IsAdmin decide which deployments the user may modify
+	Username string
+	IsAdmin  bool
 }
 
 //go:embed static
@@ -35,12 +110,26 @@ var fs embed.FS
 // bmUGC    = bluemonday.UGCPolicy()
 var bmStrict = bluemonday.StrictPolicy()
 
+// canChangeDeployment reports whether the user can edit or delete the
+// deployment; admins can change anyone's
+func canChangeDeployment(user users.User, d project.Deployment) bool {
+	return d.Owner == user.Username || users.IsAdmin(user.Role)
+}
+
 func (h Handler) RootHandler(w http.ResponseWriter, r *http.Request) {
-	if !h.isAuthorised(r) {
+	user, ok := h.currentUser(r)
+	if !ok {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
-	data, err := project.GetProjectsWithReleases(h.DbConn, h.Mu)
+
+	// Anyone can look at someone else's list, but only their own is editable
+	viewing := bmStrict.Sanitize(r.URL.Query().Get("user"))
+	if viewing == "" {
+		viewing = user.Username
+	}
+
+	allUsers, err := users.ListUsers(h.DbConn)
 	if err != nil {
 		fmt.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -50,26 +139,68 @@ func (h Handler) RootHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	tmpl := template.Must(template.ParseFS(fs, "static/home.html"))
+
+	projects, err := project.GetProjectsWithReleases(h.DbConn, h.Mu, viewing)
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte("Internal Server Error"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	data := homePage{
+		User:      user,
+		Viewing:   viewing,
+		Users:     allUsers,
+		CanEdit:   users.CanEdit(user.Role) && viewing == user.Username,
+		IsAdmin:   users.IsAdmin(user.Role),
+		Passkeys:  h.WebAuthn != nil,
+		ProxyAuth: h.ProxyAuthHeader != "",
+	}
+	for _, p := range projects {
+		data.Projects = append(data.Projects, projectCard{Project: p, CanEdit: data.CanEdit, Username: user.Username, IsAdmin: data.IsAdmin})
+	}
+
+	tmpl := h.parseTemplate(r, "static/home.html")
 	if err := tmpl.Execute(w, data); err != nil {
 		fmt.Println(err)
 	}
 }
 
 func (h Handler) NewHandler(w http.ResponseWriter, r *http.Request) {
-	if !h.isAuthorised(r) {
+	user, ok := h.currentUser(r)
+	if !ok {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
+	if !users.CanEdit(user.Role) {
+		w.WriteHeader(http.StatusForbidden)
+		_, err := w.Write([]byte("Viewers can't manage projects"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+	username := user.Username
 	params := r.URL.Query()
 	action := bmStrict.Sanitize(params.Get("action"))
 	if r.Method == http.MethodGet {
 		if action == "" {
-			tmpl := template.Must(template.ParseFS(fs, "static/new.html"))
+			tmpl := h.parseTemplate(r, "static/new.html")
 			if err := tmpl.Execute(w, nil); err != nil {
 				fmt.Println(err)
 			}
-		} else if action != "delete" {
+		} else if action == "delete" || action == "delete-deployment" {
+			w.Header().Set("Allow", http.MethodPost)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			_, err := w.Write([]byte("Deleting requires a POST request"))
+			if err != nil {
+				fmt.Println(err)
+			}
+		} else {
 			submittedURL := bmStrict.Sanitize(params.Get("url"))
 			if submittedURL == "" {
 				w.WriteHeader(http.StatusBadRequest)
@@ -107,7 +238,7 @@ func (h Handler) NewHandler(w http.ResponseWriter, r *http.Request) {
 				Forge: forge,
 			}
 
-			proj, err := project.GetProject(h.DbConn, proj)
+			proj, err := project.GetProject(h.DbConn, username, proj)
 			if err != nil && err != sql.ErrNoRows {
 				w.WriteHeader(http.StatusBadRequest)
 				_, err := w.Write([]byte(fmt.Sprintf("Error getting project: %s", err)))
@@ -127,23 +258,35 @@ func (h Handler) NewHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			tmpl := template.Must(template.ParseFS(fs, "static/select-release.html"))
-			if err := tmpl.Execute(w, proj); err != nil {
-				fmt.Println(err)
-			}
-		} else if action == "delete" {
-			submittedID := params.Get("id")
-			if submittedID == "" {
-				w.WriteHeader(http.StatusBadRequest)
-				_, err := w.Write([]byte("No URL provided"))
+			page := selectReleasePage{Project: proj, IsAdmin: users.IsAdmin(user.Role)}
+			deploymentID := bmStrict.Sanitize(params.Get("deployment"))
+			if deploymentID == "new" {
+				page.Deployment = &project.Deployment{ID: "new", Owner: username}
+			} else if deploymentID != "" {
+				deployment, err := project.GetDeployment(h.DbConn, deploymentID)
 				if err != nil {
-					fmt.Println(err)
+					w.WriteHeader(http.StatusBadRequest)
+					_, err := w.Write([]byte(fmt.Sprintf("Error getting deployment: %s", err)))
+					if err != nil {
+						fmt.Println(err)
+					}
+					return
 				}
-				return
+				if deployment.ProjectID != proj.ID || !canChangeDeployment(user, deployment) {
+					w.WriteHeader(http.StatusForbidden)
+					_, err := w.Write([]byte("That deployment belongs to someone else"))
+					if err != nil {
+						fmt.Println(err)
+					}
+					return
+				}
+				page.Deployment = &deployment
 			}
 
-			project.Untrack(h.DbConn, h.Mu, submittedID)
-			http.Redirect(w, r, "/", http.StatusSeeOther)
+			tmpl := h.parseTemplate(r, "static/select-release.html")
+			if err := tmpl.Execute(w, page); err != nil {
+				fmt.Println(err)
+			}
 		}
 	}
 
@@ -157,10 +300,149 @@ func (h Handler) NewHandler(w http.ResponseWriter, r *http.Request) {
 		urlValue := bmStrict.Sanitize(r.FormValue("url"))
 		forgeValue := bmStrict.Sanitize(r.FormValue("forge"))
 		releaseValue := bmStrict.Sanitize(r.FormValue("release"))
+		deploymentValue := bmStrict.Sanitize(r.FormValue("deployment"))
+
+		switch bmStrict.Sanitize(r.FormValue("action")) {
+		case "delete":
+			if idValue == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, err := w.Write([]byte("No project provided"))
+				if err != nil {
+					fmt.Println(err)
+				}
+				return
+			}
+			if project.IsManaged(h.DbConn, username, idValue) {
+				w.WriteHeader(http.StatusForbidden)
+				_, err := w.Write([]byte("This project is managed by the projects file"))
+				if err != nil {
+					fmt.Println(err)
+				}
+				return
+			}
+			project.Untrack(h.DbConn, h.Mu, username, idValue)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		case "delete-deployment":
+			if idValue == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, err := w.Write([]byte("No deployment provided"))
+				if err != nil {
+					fmt.Println(err)
+				}
+				return
+			}
+			deployment, err := project.GetDeployment(h.DbConn, idValue)
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			} else if err != nil {
+				fmt.Println("Error getting deployment:", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				_, err := w.Write([]byte("Internal Server Error"))
+				if err != nil {
+					fmt.Println(err)
+				}
+				return
+			}
+			if !canChangeDeployment(user, deployment) {
+				w.WriteHeader(http.StatusForbidden)
+				_, err := w.Write([]byte("That deployment belongs to someone else"))
+				if err != nil {
+					fmt.Println(err)
+				}
+				return
+			}
+			if err := project.DeleteDeployment(h.DbConn, h.Mu, idValue); err != nil {
+				fmt.Println("Error deleting deployment:", err)
+			} else {
+				audit.Record(h.DbConn, username, audit.DeleteDeployment, deployment.Name, "")
+			}
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		// If deploymentValue is not empty, we're creating or updating one of
+		// the project's deployments
+		if deploymentValue != "" && idValue != "" && releaseValue != "" {
+			deployment := project.Deployment{
+				ProjectID: idValue,
+				Name:      strings.TrimSpace(bmStrict.Sanitize(r.FormValue("deployment_name"))),
+				Running:   releaseValue,
+				Notes:     bmStrict.Sanitize(r.FormValue("deployment_notes")),
+				Owner:     username,
+			}
+			if deployment.Name == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, err := w.Write([]byte("No deployment name provided"))
+				if err != nil {
+					fmt.Println(err)
+				}
+				return
+			}
+			if deploymentValue != "new" {
+				existing, err := project.GetDeployment(h.DbConn, deploymentValue)
+				if err != nil && !errors.Is(err, sql.ErrNoRows) {
+					fmt.Println("Error getting deployment:", err)
+					w.WriteHeader(http.StatusInternalServerError)
+					_, err := w.Write([]byte("Internal Server Error"))
+					if err != nil {
+						fmt.Println(err)
+					}
+					return
+				}
+				if err != nil || existing.ProjectID != idValue || !canChangeDeployment(user, existing) {
+					w.WriteHeader(http.StatusForbidden)
+					_, err := w.Write([]byte("That deployment belongs to someone else"))
+					if err != nil {
+						fmt.Println(err)
+					}
+					return
+				}
+				deployment.ID = existing.ID
+				deployment.Owner = existing.Owner
+			}
+			// Only admins can hand deployments to someone else
+			if owner := strings.TrimSpace(bmStrict.Sanitize(r.FormValue("deployment_owner"))); owner != "" && users.IsAdmin(user.Role) {
+				_, err := users.GetRole(h.DbConn, owner)
+				if errors.Is(err, sql.ErrNoRows) {
+					w.WriteHeader(http.StatusBadRequest)
+					_, err := w.Write([]byte("There's no user called " + owner))
+					if err != nil {
+						fmt.Println(err)
+					}
+					return
+				} else if err != nil {
+					fmt.Println("Error getting deployment owner:", err)
+					w.WriteHeader(http.StatusInternalServerError)
+					_, err := w.Write([]byte("Internal Server Error"))
+					if err != nil {
+						fmt.Println(err)
+					}
+					return
+				}
+				deployment.Owner = owner
+			}
+			if _, err := project.SaveDeployment(h.DbConn, h.Mu, deployment); err != nil {
+				fmt.Println("Error saving deployment:", err)
+			} else {
+				audit.Record(h.DbConn, username, audit.SaveDeployment, deployment.Name, "running "+deployment.Running)
+			}
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
 
 		// If releaseValue is not empty, we're updating an existing project
 		if idValue != "" && nameValue != "" && urlValue != "" && forgeValue != "" && releaseValue != "" {
-			project.Track(h.DbConn, h.Mu, h.ManualRefresh, nameValue, urlValue, forgeValue, releaseValue)
+			if project.IsManaged(h.DbConn, username, idValue) {
+				w.WriteHeader(http.StatusForbidden)
+				_, err := w.Write([]byte("This project is managed by the projects file"))
+				if err != nil {
+					fmt.Println(err)
+				}
+				return
+			}
+			project.Track(h.DbConn, h.Mu, h.ManualRefresh, username, nameValue, urlValue, forgeValue, releaseValue)
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
 		}
@@ -180,18 +462,19 @@ func (h Handler) NewHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if h.ProxyAuthHeader != "" {
+		h.proxyLoginOnly(w, r)
+		return
+	}
+
 	if r.Method == http.MethodGet {
 		if h.isAuthorised(r) {
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
 		}
 
-		login, err := fs.ReadFile("static/login.html")
-		if err != nil {
-			fmt.Println("Error reading login.html:", err)
-		}
-
-		if _, err := io.WriteString(w, string(login)); err != nil {
+		tmpl := h.parseTemplate(r, "static/login.html")
+		if err := tmpl.Execute(w, loginPage{OIDC: h.OIDC != nil, Passkeys: h.WebAuthn != nil, PasswordReset: h.Email != nil}); err != nil {
 			fmt.Println(err)
 		}
 	}
@@ -213,10 +496,17 @@ func (h Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		ip := h.clientIP(r)
+		if wait := h.Logins.Attempt(ip, username); wait > 0 {
+			tooManyLogins(w, wait)
+			return
+		}
+
 		authorised, err := users.UserAuthorised(h.DbConn, username, password)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			_, err := w.Write([]byte(fmt.Sprintf("Error logging in: %s", err)))
+			fmt.Println("Error checking password:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			_, err := w.Write([]byte("Internal Server Error"))
 			if err != nil {
 				fmt.Println(err)
 			}
@@ -224,6 +514,9 @@ func (h Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if !authorised {
+			if err := users.RecordFailedLogin(h.DbConn, username, ip, "incorrect username or password"); err != nil {
+				fmt.Println("Error recording failed login:", err)
+			}
 			w.WriteHeader(http.StatusUnauthorized)
 			_, err := w.Write([]byte("Incorrect username or password"))
 			if err != nil {
@@ -231,37 +524,92 @@ func (h Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			return
 		}
+		h.Logins.Success(ip, username)
 
-		session, expiry, err := users.CreateSession(h.DbConn, username)
+		totpEnabled, err := users.TOTPEnabled(h.DbConn, username)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			_, err := w.Write([]byte(fmt.Sprintf("Error creating session: %s", err)))
+			_, err := w.Write([]byte(fmt.Sprintf("Error logging in: %s", err)))
 			if err != nil {
 				fmt.Println(err)
 			}
 			return
 		}
 
-		maxAge := int(time.Until(expiry))
+		if totpEnabled {
+			if err := h.startPendingLogin(w, username); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_, err := w.Write([]byte(fmt.Sprintf("Error logging in: %s", err)))
+				if err != nil {
+					fmt.Println(err)
+				}
+				return
+			}
+			http.Redirect(w, r, "/login/totp", http.StatusSeeOther)
+			return
+		}
 
-		cookie := http.Cookie{
-			Name:     "id",
-			Value:    session,
-			MaxAge:   maxAge,
-			HttpOnly: true,
-			SameSite: http.SameSiteStrictMode,
-			Secure:   true,
+		h.recordLogin(r, username, "password")
+		if err := h.startSession(w, r, username); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte(fmt.Sprintf("Error creating session: %s", err)))
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
 		}
 
-		http.SetCookie(w, &cookie)
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
 }
 
+// startSession creates a session for the user and sets its cookie.
+func (h Handler) startSession(w http.ResponseWriter, r *http.Request, username string) error {
+	session, expiry, err := users.CreateSession(h.DbConn, username, h.clientIP(r), userAgent(r), h.SessionLifetime)
+	if err != nil {
+		return err
+	}
+
+	maxAge := int(time.Until(expiry))
+
+	cookie := http.Cookie{
+		Name:     "id",
+		Value:    session,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Secure:   true,
+	}
+
+	http.SetCookie(w, &cookie)
+	return nil
+}
+
+// recordLogin adds a successful login to the audit log
+func (h Handler) recordLogin(r *http.Request, username, method string) {
+	audit.Record(h.DbConn, username, audit.Login, username, method+" from "+h.clientIP(r))
+}
+
 func (h Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if h.ProxyAuthHeader != "" {
+		h.proxyLoginOnly(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, err := w.Write([]byte("Logging out requires a POST request"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
 	cookie, err := r.Cookie("id")
 	if err != nil {
-		fmt.Println(err)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
 	}
 
 	err = users.InvalidateSession(h.DbConn, cookie.Value)
@@ -281,18 +629,162 @@ func (h Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 // isAuthorised makes a database request to the sessions table to see if the
 // user has a valid session cookie.
 func (h Handler) isAuthorised(r *http.Request) bool {
+	_, authorised := h.sessionUser(r)
+	return authorised
+}
+
+// proxyLoginOnly stands in for the login and logout pages when the reverse
+// proxy handles logins.
+func (h Handler) proxyLoginOnly(w http.ResponseWriter, r *http.Request) {
+	if h.isAuthorised(r) {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+	_, err := w.Write([]byte("Please log in through the reverse proxy in front of Willow"))
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// sessionUser returns the username associated with the request's session
+// cookie and whether the session is valid. When the reverse proxy handles
+// logins, it returns the user named in the proxy's header instead.
+func (h Handler) sessionUser(r *http.Request) (string, bool) {
+	if h.ProxyAuthHeader != "" {
+		return h.proxyUser(r)
+	}
+
 	cookie, err := r.Cookie("id")
 	if err != nil {
-		return false
+		return "", false
 	}
 
-	authorised, err := users.SessionAuthorised(h.DbConn, cookie.Value)
+	username, authorised, err := users.SessionUser(h.DbConn, cookie.Value)
 	if err != nil {
 		fmt.Println("Error checking session:", err)
-		return false
+		return "", false
 	}
 
-	return authorised
+	if authorised {
+		err = users.TouchSession(h.DbConn, cookie.Value, h.clientIP(r), userAgent(r), h.SessionLifetime)
+		if err != nil {
+			fmt.Println("Error updating session:", err)
+		}
+	}
+
+	return username, authorised
+}
+
+// proxyUser returns the username in the proxy auth header and whether it can
+// be believed, which is only when the request came straight from a trusted
+// proxy. Users are created on their first request.
+func (h Handler) proxyUser(r *http.Request) (string, bool) {
+	username := bmStrict.Sanitize(strings.TrimSpace(r.Header.Get(h.ProxyAuthHeader)))
+	if username == "" {
+		return "", false
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !h.trustedProxy(addr) {
+		return "", false
+	}
+
+	created, err := users.ProxyLogin(h.DbConn, username, h.ProxyAuthDefaultRole)
+	if err != nil {
+		fmt.Println("Error provisioning user from reverse proxy:", err)
+		return "", false
+	}
+	if created {
+		audit.Record(h.DbConn, username, audit.CreateUser, username, h.ProxyAuthDefaultRole+" through reverse proxy")
+	}
+	return username, true
+}
+
+// currentUser returns the user associated with the request's session cookie,
+// including their role, and whether the session is valid.
+func (h Handler) currentUser(r *http.Request) (users.User, bool) {
+	username, ok := h.sessionUser(r)
+	if !ok {
+		return users.User{}, false
+	}
+
+	role, err := users.GetRole(h.DbConn, username)
+	if err != nil {
+		fmt.Println("Error getting user's role:", err)
+		return users.User{}, false
+	}
+
+	return users.User{Username: username, Role: role}, true
+}
+
+// clientIP returns the IP of the client that sent the request. X-Forwarded-For
+// is only believed for hops added by trusted proxies.
+func (h Handler) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0 && h.trustedProxy(addr); i-- {
+		next, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = next
+	}
+	return addr.Unmap().String()
+}
+
+func (h Handler) trustedProxy(addr netip.Addr) bool {
+	for _, prefix := range h.TrustedProxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// userAgent returns the request's User-Agent header, made safe to show on the
+// sessions page
+func userAgent(r *http.Request) string {
+	ua := r.UserAgent()
+	if len(ua) > 256 {
+		ua = ua[:256]
+	}
+	return bmStrict.Sanitize(ua)
+}
+
+// tooManyLogins tells the client to wait before trying to log in again
+func tooManyLogins(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	w.WriteHeader(http.StatusTooManyRequests)
+	_, err := w.Write([]byte("Too many failed login attempts, please try again later"))
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// baseURL returns the configured base URL or, if there isn't one, guesses it
+// from the request.
+func (h Handler) baseURL(r *http.Request) string {
+	if h.BaseURL != "" {
+		return strings.TrimSuffix(h.BaseURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
 }
 
 func StaticHandler(writer http.ResponseWriter, request *http.Request) {